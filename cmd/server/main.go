@@ -4,10 +4,16 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -34,25 +40,47 @@ func main() {
 	var bindAddress string
 	var tokensPath string
 	var watchInterval time.Duration
+	var watchDebounce time.Duration
+	var filenameParsersPath string
+	var ffprobe bool
+	var probeWorkers int
+	var maxSyncDeletes int
+	var retentionRulesPath string
+	var retentionInterval time.Duration
+	var transcodeConfig storage.TranscodeConfig
+	var transcodeSources string
+	var transcodeInterval time.Duration
+	var jobWorkers int
+	var uploadDir string
+	var uploadTTL time.Duration
 	flag.StringVar(&bindAddress, "bind", "127.0.0.1:8080", "bind address")
 	flag.StringVar(&dbPath, "db-path", "db.sqlite3", "path to database")
 	flag.StringVar(&tokensPath, "tokens-path", "", "path to tokens")
-	flag.DurationVar(&watchInterval, "watch-interval", 5*time.Minute, "interval to watch samples directory")
+	flag.DurationVar(&watchInterval, "watch-interval", 5*time.Minute, "interval to poll samples directory if inotify is unavailable (0 to disable watching)")
+	flag.DurationVar(&watchDebounce, "watch-debounce", 2*time.Second, "time to wait for more changes before syncing changed files")
+	flag.StringVar(&filenameParsersPath, "filename-parsers", "", "path to JSON list of sample filename parsers (default: only 2006-01-02T15:04:05-07:00)")
+	flag.BoolVar(&ffprobe, "ffprobe", true, "use ffprobe for media durations the native probers cannot handle")
+	flag.IntVar(&probeWorkers, "probe-workers", 0, "maximum number of media files probed concurrently by dry-run syncs; other syncs queue probe jobs (see -job-workers) (default: number of CPUs)")
+	flag.IntVar(&jobWorkers, "job-workers", 2, "number of background jobs (e.g. probing media) run concurrently")
+	flag.IntVar(&maxSyncDeletes, "max-sync-deletes", 100, "maximum number of samples a sync deletes without confirmation (negative for no limit)")
+	flag.StringVar(&retentionRulesPath, "retention-rules", "", "path to JSON list of retention rules (default: media is never removed)")
+	flag.StringVar(&transcodeConfig.Ext, "transcode-ext", "", "extension of the media to transcode to with ffmpeg, e.g. opus (default: media is not transcoded)")
+	flag.StringVar(&transcodeConfig.Codec, "transcode-codec", "libopus", "ffmpeg audio codec to transcode with")
+	flag.StringVar(&transcodeConfig.Bitrate, "transcode-bitrate", "32k", "ffmpeg audio bitrate to transcode with")
+	flag.StringVar(&transcodeSources, "transcode-sources", "aiff,wav", "comma-separated extensions of the media to transcode")
+	flag.DurationVar(&transcodeConfig.Grace, "transcode-grace", 7*24*time.Hour, "time to keep the original media after transcoding")
+	flag.DurationVar(&transcodeInterval, "transcode-interval", time.Hour, "interval to look for media to transcode")
+	flag.StringVar(&uploadDir, "upload-dir", "", "time layout of the directory completed uploads are moved into, relative to the samples directory, e.g. 2006/01/02 (default: the samples directory)")
+	flag.DurationVar(&uploadTTL, "upload-ttl", 7*24*time.Hour, "time to keep unfinished uploads (0 to keep them until cancelled)")
+	flag.DurationVar(&retentionInterval, "retention-interval", 24*time.Hour, "interval to apply retention rules (0 to only apply them with the retention subcommand or the admin page)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [sync [sync flags] | retention [retention flags]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	data, err := os.ReadFile(tokensPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	tokenMap := map[string]server.TokenInfo{}
-	err = json.Unmarshal(data, &tokenMap)
-	if err != nil {
-		log.Fatal(err)
-	}
-	tokenMap2 := map[tokens.TokenHash]server.TokenInfo{}
-	for k, v := range tokenMap {
-		tokenMap2[tokens.MustParseTokenHash(k)] = v
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	log.Printf("opening database...")
 	db, err := database.Open(dbPath)
@@ -67,16 +95,125 @@ func main() {
 	log.Printf("database migrated.")
 
 	st := storage.New(getenvNonEmpty("SEEKBACK_SERVER_SAMPLES_PATH"), db)
-	log.Printf("syncing files and database...")
-	err = st.SyncFiles(context.Background())
+	st.ProbeWorkers = probeWorkers
+	st.MaxSyncDeletes = maxSyncDeletes
+	st.UploadDir = uploadDir
+	st.UploadTTL = uploadTTL
+	if !ffprobe {
+		st.FallbackProber = nil
+	}
+	if filenameParsersPath != "" {
+		data, err := os.ReadFile(filenameParsersPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		st.FilenameParsers, err = storage.ParseFilenameParsers(data)
+		if err != nil {
+			log.Fatalf("filename parsers: %s", err)
+		}
+	}
+	if retentionRulesPath != "" {
+		data, err := os.ReadFile(retentionRulesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		st.RetentionRules, err = storage.ParseRetentionRules(data)
+		if err != nil {
+			log.Fatalf("retention rules: %s", err)
+		}
+	}
+
+	if transcodeConfig.Ext != "" {
+		transcodeConfig.SourceExts = strings.Split(transcodeSources, ",")
+		err = transcodeConfig.Validate()
+		if err != nil {
+			log.Fatalf("transcode: %s", err)
+		}
+		st.Transcode = &transcodeConfig
+	}
+
+	if flag.Arg(0) == "sync" {
+		runSync(ctx, st, flag.Args()[1:])
+		return
+	} else if flag.Arg(0) == "retention" {
+		runRetention(ctx, st, flag.Args()[1:])
+		return
+	} else if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(tokensPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("files and database synced.")
+	tokenMap := map[string]server.TokenInfo{}
+	err = json.Unmarshal(data, &tokenMap)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenMap2 := map[tokens.TokenHash]server.TokenInfo{}
+	for k, v := range tokenMap {
+		tokenMap2[tokens.MustParseTokenHash(k)] = v
+	}
+
+	log.Printf("syncing files and database...")
+	err = st.SyncFiles(ctx)
+	if errors.Is(err, storage.ErrTooManyDeletes) {
+		// Serve the existing database; the watcher keeps failing until the deletes are confirmed.
+		log.Printf("files and database not synced: %s; check with `sync -dry-run` and confirm with `sync -confirm-deletes`", err)
+	} else if err != nil {
+		log.Fatal(err)
+	} else {
+		log.Printf("files and database synced.")
+	}
 
+	var wg sync.WaitGroup
 	if watchInterval > 0 {
 		log.Printf("watching samples directory.")
-		go st.WatchAndSyncFiles(watchInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := st.WatchAndSyncFiles(ctx, watchInterval, watchDebounce)
+			if err != nil {
+				log.Printf("watch samples directory: %s", err)
+			}
+		}()
+	}
+
+	if jobWorkers > 0 {
+		log.Printf("running background jobs with %d workers.", jobWorkers)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.RunJobs(ctx, jobWorkers)
+		}()
+	}
+
+	if retentionInterval > 0 && len(st.RetentionRules) != 0 {
+		log.Printf("applying %d retention rules every %s.", len(st.RetentionRules), retentionInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.RunRetention(ctx, retentionInterval)
+		}()
+	}
+
+	if uploadTTL > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.RunUploadSweeper(ctx, time.Hour)
+		}()
+	}
+
+	if st.Transcode != nil && transcodeInterval > 0 {
+		log.Printf("transcoding %s to %s every %s.", transcodeSources, st.Transcode.Ext, transcodeInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.RunTranscoder(ctx, transcodeInterval)
+		}()
 	}
 
 	authKey, err := hex.DecodeString(getenvNonEmpty("SEEKBACK_SERVER_STORE_AUTH_KEY"))
@@ -95,6 +232,68 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	hs := &http.Server{Addr: bindAddress, Handler: s}
+	go func() {
+		<-ctx.Done()
+		log.Printf("shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := hs.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("shutdown: %s", err)
+		}
+	}()
 	log.Printf("listening on %s...", bindAddress)
-	log.Fatal(http.ListenAndServe(bindAddress, s))
+	err = hs.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	wg.Wait()
+	log.Printf("stopped.")
+}
+
+// runSync runs the sync subcommand, which syncs once and prints the report as JSON.
+func runSync(ctx context.Context, st *storage.Storage, args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the changes, without writing to the database")
+	confirmDeletes := fs.Bool("confirm-deletes", false, "delete samples even if there are more than -max-sync-deletes")
+	runJobs := fs.Bool("run-jobs", false, "run the queued background jobs (e.g. probing media) after syncing, instead of leaving them to the server")
+	fs.Parse(args)
+
+	report, err := st.Sync(ctx, storage.SyncOptions{DryRun: *dryRun, ConfirmDeletes: *confirmDeletes})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	encErr := enc.Encode(report)
+	if encErr != nil {
+		log.Fatal(encErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *runJobs && !*dryRun {
+		log.Printf("running queued jobs...")
+		err = st.RunJobsOnce(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("ran queued jobs.")
+	}
+}
+
+// runRetention runs the retention subcommand, which applies the retention rules once and prints the report as JSON.
+func runRetention(ctx context.Context, st *storage.Storage, args []string) {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print the samples whose media would be removed")
+	fs.Parse(args)
+
+	report, err := st.ApplyRetention(ctx, storage.RetentionOptions{DryRun: *dryRun})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	encErr := enc.Encode(report)
+	if encErr != nil {
+		log.Fatal(encErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,7 @@
+DROP TRIGGER clips_fts_after_update;
+DROP TRIGGER clips_fts_before_update;
+DROP TRIGGER clips_fts_before_delete;
+DROP TRIGGER clips_fts_after_insert;
+DROP TABLE clips_fts;
+DROP INDEX clips_sample_id;
+DROP TABLE clips;
@@ -0,0 +1,33 @@
+CREATE TABLE clips(
+  id INTEGER PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  start_offset INTEGER NOT NULL,
+  end_offset INTEGER NOT NULL,
+  title TEXT NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX clips_sample_id ON clips(sample_id);
+
+CREATE VIRTUAL TABLE clips_fts USING fts5(
+  title,
+  note,
+  content='clips',
+  content_rowid='id'
+);
+
+CREATE TRIGGER clips_fts_after_insert AFTER INSERT ON clips BEGIN
+  INSERT INTO clips_fts(rowid, title, note) VALUES (new.id, new.title, new.note);
+END;
+
+CREATE TRIGGER clips_fts_before_delete BEFORE DELETE ON clips BEGIN
+  INSERT INTO clips_fts(clips_fts, rowid, title, note) VALUES ('delete', old.id, old.title, old.note);
+END;
+
+CREATE TRIGGER clips_fts_before_update BEFORE UPDATE OF title, note ON clips BEGIN
+  INSERT INTO clips_fts(clips_fts, rowid, title, note) VALUES ('delete', old.id, old.title, old.note);
+END;
+
+CREATE TRIGGER clips_fts_after_update AFTER UPDATE OF title, note ON clips BEGIN
+  INSERT INTO clips_fts(rowid, title, note) VALUES (new.id, new.title, new.note);
+END;
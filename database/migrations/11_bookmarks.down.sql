@@ -0,0 +1,7 @@
+DROP TRIGGER bookmarks_fts_after_update;
+DROP TRIGGER bookmarks_fts_before_update;
+DROP TRIGGER bookmarks_fts_before_delete;
+DROP TRIGGER bookmarks_fts_after_insert;
+DROP TABLE bookmarks_fts;
+DROP INDEX bookmarks_sample_id;
+DROP TABLE bookmarks;
@@ -0,0 +1,30 @@
+CREATE TABLE bookmarks(
+  id INTEGER PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  offset INTEGER NOT NULL,
+  note TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX bookmarks_sample_id ON bookmarks(sample_id, offset);
+
+CREATE VIRTUAL TABLE bookmarks_fts USING fts5(
+  note,
+  content='bookmarks',
+  content_rowid='id'
+);
+
+CREATE TRIGGER bookmarks_fts_after_insert AFTER INSERT ON bookmarks BEGIN
+  INSERT INTO bookmarks_fts(rowid, note) VALUES (new.id, new.note);
+END;
+
+CREATE TRIGGER bookmarks_fts_before_delete BEFORE DELETE ON bookmarks BEGIN
+  INSERT INTO bookmarks_fts(bookmarks_fts, rowid, note) VALUES ('delete', old.id, old.note);
+END;
+
+CREATE TRIGGER bookmarks_fts_before_update BEFORE UPDATE OF note ON bookmarks BEGIN
+  INSERT INTO bookmarks_fts(bookmarks_fts, rowid, note) VALUES ('delete', old.id, old.note);
+END;
+
+CREATE TRIGGER bookmarks_fts_after_update AFTER UPDATE OF note ON bookmarks BEGIN
+  INSERT INTO bookmarks_fts(rowid, note) VALUES (new.id, new.note);
+END;
@@ -0,0 +1,3 @@
+DROP INDEX retention_log_removed_at;
+DROP TABLE retention_log;
+ALTER TABLE samples DROP COLUMN archived_at;
@@ -0,0 +1,11 @@
+ALTER TABLE samples ADD COLUMN archived_at DATETIME;
+
+CREATE TABLE retention_log(
+  id INTEGER PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  rule TEXT NOT NULL,
+  name TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  removed_at DATETIME NOT NULL
+);
+CREATE INDEX retention_log_removed_at ON retention_log(removed_at);
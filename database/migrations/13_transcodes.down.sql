@@ -0,0 +1,2 @@
+DROP INDEX transcodes_status;
+DROP TABLE transcodes;
@@ -0,0 +1,15 @@
+CREATE TABLE transcodes(
+  source TEXT PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  output TEXT NOT NULL,
+  status TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  source_size INTEGER NOT NULL DEFAULT 0,
+  output_size INTEGER NOT NULL DEFAULT 0,
+  source_duration INTEGER NOT NULL DEFAULT 0,
+  output_duration INTEGER NOT NULL DEFAULT 0,
+  started_at DATETIME NOT NULL,
+  finished_at DATETIME,
+  source_removed_at DATETIME
+);
+CREATE INDEX transcodes_status ON transcodes(status);
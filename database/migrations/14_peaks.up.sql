@@ -0,0 +1,8 @@
+CREATE TABLE peaks(
+  sample_id TEXT PRIMARY KEY,
+  media TEXT NOT NULL,
+  duration INTEGER NOT NULL,
+  data BLOB NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE samples DROP COLUMN speech_duration;
+DROP INDEX speech_segments_sample_id;
+DROP TABLE speech_segments;
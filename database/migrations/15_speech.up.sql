@@ -0,0 +1,7 @@
+CREATE TABLE speech_segments(
+  sample_id TEXT NOT NULL,
+  start_offset INTEGER NOT NULL,
+  end_offset INTEGER NOT NULL
+);
+CREATE INDEX speech_segments_sample_id ON speech_segments(sample_id, start_offset);
+ALTER TABLE samples ADD COLUMN speech_duration INTEGER;
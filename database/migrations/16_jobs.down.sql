@@ -0,0 +1,3 @@
+DROP INDEX jobs_state;
+DROP INDEX jobs_waiting;
+DROP TABLE jobs;
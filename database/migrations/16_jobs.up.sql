@@ -0,0 +1,14 @@
+CREATE TABLE jobs(
+  id INTEGER PRIMARY KEY,
+  kind TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  state TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  error TEXT NOT NULL DEFAULT '',
+  run_after DATETIME NOT NULL,
+  lease_expires_at DATETIME,
+  created_at DATETIME NOT NULL,
+  updated_at DATETIME NOT NULL
+);
+CREATE UNIQUE INDEX jobs_waiting ON jobs(kind, payload) WHERE state != 'running';
+CREATE INDEX jobs_state ON jobs(state, run_after);
@@ -0,0 +1,7 @@
+DROP TRIGGER transcript_cues_fts_after_update;
+DROP TRIGGER transcript_cues_fts_before_update;
+DROP TRIGGER transcript_cues_fts_before_delete;
+DROP TRIGGER transcript_cues_fts_after_insert;
+DROP TABLE transcript_cues_fts;
+DROP INDEX transcript_cues_sample_id;
+DROP TABLE transcript_cues;
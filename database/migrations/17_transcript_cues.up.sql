@@ -0,0 +1,32 @@
+CREATE TABLE transcript_cues(
+  id INTEGER PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  start_offset INTEGER NOT NULL,
+  end_offset INTEGER NOT NULL,
+  text TEXT NOT NULL,
+  voice TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX transcript_cues_sample_id ON transcript_cues(sample_id, start_offset);
+
+CREATE VIRTUAL TABLE transcript_cues_fts USING fts5(
+  text,
+  voice,
+  content='transcript_cues',
+  content_rowid='id'
+);
+
+CREATE TRIGGER transcript_cues_fts_after_insert AFTER INSERT ON transcript_cues BEGIN
+  INSERT INTO transcript_cues_fts(rowid, text, voice) VALUES (new.id, new.text, new.voice);
+END;
+
+CREATE TRIGGER transcript_cues_fts_before_delete BEFORE DELETE ON transcript_cues BEGIN
+  INSERT INTO transcript_cues_fts(transcript_cues_fts, rowid, text, voice) VALUES ('delete', old.id, old.text, old.voice);
+END;
+
+CREATE TRIGGER transcript_cues_fts_before_update BEFORE UPDATE OF text, voice ON transcript_cues BEGIN
+  INSERT INTO transcript_cues_fts(transcript_cues_fts, rowid, text, voice) VALUES ('delete', old.id, old.text, old.voice);
+END;
+
+CREATE TRIGGER transcript_cues_fts_after_update AFTER UPDATE OF text, voice ON transcript_cues BEGIN
+  INSERT INTO transcript_cues_fts(rowid, text, voice) VALUES (new.id, new.text, new.voice);
+END;
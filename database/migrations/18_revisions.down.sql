@@ -0,0 +1,2 @@
+DROP INDEX revisions_sample_id;
+DROP TABLE revisions;
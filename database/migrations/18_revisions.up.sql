@@ -0,0 +1,11 @@
+CREATE TABLE revisions(
+  id INTEGER PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  field TEXT NOT NULL,
+  body TEXT NOT NULL,
+  author TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX revisions_sample_id ON revisions(sample_id, field, id);
+INSERT INTO revisions (sample_id, field, body, created_at) SELECT id, 'summary', summary, CURRENT_TIMESTAMP FROM samples WHERE summary != '';
+INSERT INTO revisions (sample_id, field, body, created_at) SELECT id, 'transcript', transcript, CURRENT_TIMESTAMP FROM samples WHERE transcript != '';
@@ -0,0 +1,6 @@
+DROP TRIGGER samples_fts_after_update;
+DROP TRIGGER samples_fts_before_update;
+DROP TRIGGER samples_fts_before_delete;
+DROP TRIGGER samples_fts_after_insert;
+DROP INDEX files_sample_id;
+DROP TABLE files;
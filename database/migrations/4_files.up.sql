@@ -0,0 +1,26 @@
+CREATE TABLE files(
+  name TEXT PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  mtime INTEGER NOT NULL,
+  hash TEXT NOT NULL
+);
+CREATE INDEX files_sample_id ON files(sample_id);
+
+CREATE TRIGGER samples_fts_after_insert AFTER INSERT ON samples BEGIN
+  INSERT INTO samples_fts(rowid, id, summary, transcript) VALUES (new.rowid, new.id, new.summary, new.transcript);
+END;
+
+CREATE TRIGGER samples_fts_before_delete BEFORE DELETE ON samples BEGIN
+  INSERT INTO samples_fts(samples_fts, rowid, id, summary, transcript) VALUES ('delete', old.rowid, old.id, old.summary, old.transcript);
+END;
+
+CREATE TRIGGER samples_fts_before_update BEFORE UPDATE OF id, summary, transcript ON samples BEGIN
+  INSERT INTO samples_fts(samples_fts, rowid, id, summary, transcript) VALUES ('delete', old.rowid, old.id, old.summary, old.transcript);
+END;
+
+CREATE TRIGGER samples_fts_after_update AFTER UPDATE OF id, summary, transcript ON samples BEGIN
+  INSERT INTO samples_fts(rowid, id, summary, transcript) VALUES (new.rowid, new.id, new.summary, new.transcript);
+END;
+
+INSERT INTO samples_fts(samples_fts) VALUES ('rebuild');
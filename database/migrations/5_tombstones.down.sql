@@ -0,0 +1,2 @@
+DROP INDEX samples_deleted_at;
+ALTER TABLE samples DROP COLUMN deleted_at;
@@ -0,0 +1,2 @@
+ALTER TABLE samples ADD COLUMN deleted_at DATETIME;
+CREATE INDEX samples_deleted_at ON samples(deleted_at);
@@ -0,0 +1,7 @@
+CREATE TABLE uploads(
+  id TEXT PRIMARY KEY,
+  sample_id TEXT NOT NULL,
+  ext TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  created_at DATETIME NOT NULL
+);
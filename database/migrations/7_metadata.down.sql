@@ -0,0 +1,33 @@
+DROP TRIGGER samples_fts_after_update;
+DROP TRIGGER samples_fts_before_update;
+DROP TRIGGER samples_fts_before_delete;
+DROP TRIGGER samples_fts_after_insert;
+DROP TABLE samples_fts;
+
+CREATE VIRTUAL TABLE samples_fts USING fts5(
+  id,
+  summary,
+  transcript,
+  content='samples'
+);
+
+CREATE TRIGGER samples_fts_after_insert AFTER INSERT ON samples BEGIN
+  INSERT INTO samples_fts(rowid, id, summary, transcript) VALUES (new.rowid, new.id, new.summary, new.transcript);
+END;
+
+CREATE TRIGGER samples_fts_before_delete BEFORE DELETE ON samples BEGIN
+  INSERT INTO samples_fts(samples_fts, rowid, id, summary, transcript) VALUES ('delete', old.rowid, old.id, old.summary, old.transcript);
+END;
+
+CREATE TRIGGER samples_fts_before_update BEFORE UPDATE OF id, summary, transcript ON samples BEGIN
+  INSERT INTO samples_fts(samples_fts, rowid, id, summary, transcript) VALUES ('delete', old.rowid, old.id, old.summary, old.transcript);
+END;
+
+CREATE TRIGGER samples_fts_after_update AFTER UPDATE OF id, summary, transcript ON samples BEGIN
+  INSERT INTO samples_fts(rowid, id, summary, transcript) VALUES (new.rowid, new.id, new.summary, new.transcript);
+END;
+
+INSERT INTO samples_fts(samples_fts) VALUES ('rebuild');
+
+ALTER TABLE samples DROP COLUMN metadata_text;
+ALTER TABLE samples DROP COLUMN metadata;
@@ -0,0 +1,34 @@
+ALTER TABLE samples ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE samples ADD COLUMN metadata_text TEXT NOT NULL DEFAULT '';
+
+DROP TRIGGER samples_fts_after_update;
+DROP TRIGGER samples_fts_before_update;
+DROP TRIGGER samples_fts_before_delete;
+DROP TRIGGER samples_fts_after_insert;
+DROP TABLE samples_fts;
+
+CREATE VIRTUAL TABLE samples_fts USING fts5(
+  id,
+  summary,
+  transcript,
+  metadata_text,
+  content='samples'
+);
+
+CREATE TRIGGER samples_fts_after_insert AFTER INSERT ON samples BEGIN
+  INSERT INTO samples_fts(rowid, id, summary, transcript, metadata_text) VALUES (new.rowid, new.id, new.summary, new.transcript, new.metadata_text);
+END;
+
+CREATE TRIGGER samples_fts_before_delete BEFORE DELETE ON samples BEGIN
+  INSERT INTO samples_fts(samples_fts, rowid, id, summary, transcript, metadata_text) VALUES ('delete', old.rowid, old.id, old.summary, old.transcript, old.metadata_text);
+END;
+
+CREATE TRIGGER samples_fts_before_update BEFORE UPDATE OF id, summary, transcript, metadata_text ON samples BEGIN
+  INSERT INTO samples_fts(samples_fts, rowid, id, summary, transcript, metadata_text) VALUES ('delete', old.rowid, old.id, old.summary, old.transcript, old.metadata_text);
+END;
+
+CREATE TRIGGER samples_fts_after_update AFTER UPDATE OF id, summary, transcript, metadata_text ON samples BEGIN
+  INSERT INTO samples_fts(rowid, id, summary, transcript, metadata_text) VALUES (new.rowid, new.id, new.summary, new.transcript, new.metadata_text);
+END;
+
+INSERT INTO samples_fts(samples_fts) VALUES ('rebuild');
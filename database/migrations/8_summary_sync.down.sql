@@ -0,0 +1,2 @@
+DROP TABLE summary_conflicts;
+ALTER TABLE samples DROP COLUMN summary_hash;
@@ -0,0 +1,8 @@
+ALTER TABLE samples ADD COLUMN summary_hash TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE summary_conflicts(
+  sample_id TEXT PRIMARY KEY,
+  db_summary TEXT NOT NULL,
+  file_summary TEXT NOT NULL,
+  detected_at DATETIME NOT NULL
+);
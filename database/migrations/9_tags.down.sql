@@ -0,0 +1,3 @@
+DROP INDEX sample_tags_tag_id;
+DROP TABLE sample_tags;
+DROP TABLE tags;
@@ -0,0 +1,11 @@
+CREATE TABLE tags(
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE sample_tags(
+  sample_id TEXT NOT NULL,
+  tag_id INTEGER NOT NULL,
+  PRIMARY KEY (sample_id, tag_id)
+);
+CREATE INDEX sample_tags_tag_id ON sample_tags(tag_id);
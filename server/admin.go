@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"nyiyui.ca/seekback-server/storage"
+)
+
+type adminSyncQuery struct {
+	ConfirmDeletes bool `schema:"confirm_deletes"`
+}
+
+type adminSyncResponse struct {
+	Report storage.SyncReport `json:"report"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// adminSync syncs the samples directory and responds with the report as JSON.
+// GET does a dry run; POST writes the changes.
+// If the sync would delete too many samples, the response has status 409 and nothing is written, unless confirm_deletes=true.
+func (s *Server) adminSync(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+	decoder := newDecoder(r)
+	decoder.IgnoreUnknownKeys(true)
+	var query adminSyncQuery
+	err = decoder.Decode(&query, r.Form)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+
+	report, err := s.st.Sync(r.Context(), storage.SyncOptions{
+		DryRun:         r.Method == "GET",
+		ConfirmDeletes: query.ConfirmDeletes,
+	})
+	resp := adminSyncResponse{Report: report}
+	status := 200
+	if errors.Is(err, storage.ErrTooManyDeletes) {
+		resp.Error = err.Error()
+		status = 409
+	} else if err != nil {
+		log.Printf("error syncing: %s", err)
+		http.Error(w, "error syncing", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Printf("error encoding json: %s", err)
+		return
+	}
+}
+
+func (s *Server) adminTombstones(w http.ResponseWriter, r *http.Request) {
+	sps, err := s.st.Tombstones(r.Context())
+	if err != nil {
+		log.Printf("error getting tombstones: %s", err)
+		http.Error(w, "error getting tombstones", 500)
+		return
+	}
+	s.renderTemplate("admin-tombstones.html", w, r, map[string]interface{}{
+		"samples": sps,
+	})
+}
+
+// adminTombstonePurge permanently deletes a tombstoned sample.
+func (s *Server) adminTombstonePurge(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	err := s.st.SamplePurge(r.Context(), id)
+	if errors.Is(err, storage.ErrNotTombstoned) {
+		http.Error(w, "sample is not tombstoned", 404)
+		return
+	} else if err != nil {
+		log.Printf("error purging sample: %s", err)
+		http.Error(w, "error purging sample", 500)
+		return
+	}
+	http.Redirect(w, r, "/admin/tombstones", 302)
+}
+
+// adminTombstoneRestore restores a tombstoned sample whose media is in the samples directory.
+func (s *Server) adminTombstoneRestore(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	err := s.st.SampleRestore(r.Context(), id)
+	if errors.Is(err, storage.ErrNotTombstoned) {
+		http.Error(w, "sample is not tombstoned", 404)
+		return
+	} else if errors.Is(err, storage.ErrNoMedia) {
+		http.Error(w, "sample has no media in the samples directory", 409)
+		return
+	} else if err != nil {
+		log.Printf("error restoring sample: %s", err)
+		http.Error(w, "error restoring sample", 500)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s", id), 302)
+}
+
+func (s *Server) adminConflicts(w http.ResponseWriter, r *http.Request) {
+	conflicts, err := s.st.SummaryConflicts(r.Context())
+	if err != nil {
+		log.Printf("error getting summary conflicts: %s", err)
+		http.Error(w, "error getting summary conflicts", 500)
+		return
+	}
+	s.renderTemplate("admin-conflicts.html", w, r, map[string]interface{}{
+		"conflicts": conflicts,
+	})
+}
+
+type adminConflictResolveQuery struct {
+	Summary string `schema:"summary"`
+}
+
+// adminConflictResolve resolves a summary conflict by setting the summary (in both the database and the file).
+func (s *Server) adminConflictResolve(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+	decoder := newDecoder(r)
+	var query adminConflictResolveQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	err = s.st.SampleSummarySet(id, query.Summary, requestAuthor(r), r.Context())
+	if errors.Is(err, storage.ErrSampleNotFound) {
+		http.Error(w, "sample not found", 404)
+		return
+	} else if err != nil {
+		log.Printf("error setting summary: %s", err)
+		http.Error(w, "error setting summary", 500)
+		return
+	}
+	http.Redirect(w, r, "/admin/conflicts", 302)
+}
+
+// adminRetention shows the retention rules, a dry run of them, and the retention log.
+func (s *Server) adminRetention(w http.ResponseWriter, r *http.Request) {
+	report, err := s.st.ApplyRetention(r.Context(), storage.RetentionOptions{DryRun: true})
+	if err != nil {
+		log.Printf("error previewing retention: %s", err)
+		http.Error(w, "error previewing retention", 500)
+		return
+	}
+	entries, err := s.st.RetentionLog(r.Context(), 100)
+	if err != nil {
+		log.Printf("error getting retention log: %s", err)
+		http.Error(w, "error getting retention log", 500)
+		return
+	}
+	s.renderTemplate("admin-retention.html", w, r, map[string]interface{}{
+		"rules":  s.st.RetentionRules,
+		"report": report,
+		"log":    entries,
+	})
+}
+
+// adminRetentionApply applies the retention rules now, instead of waiting for the next scheduled run.
+func (s *Server) adminRetentionApply(w http.ResponseWriter, r *http.Request) {
+	_, err := s.st.ApplyRetention(r.Context(), storage.RetentionOptions{})
+	if err != nil {
+		log.Printf("error applying retention: %s", err)
+		http.Error(w, "error applying retention", 500)
+		return
+	}
+	http.Redirect(w, r, "/admin/retention", 302)
+}
+
+func (s *Server) adminTranscodes(w http.ResponseWriter, r *http.Request) {
+	pending, err := s.st.TranscodePending(r.Context())
+	if err != nil {
+		log.Printf("error getting pending transcodes: %s", err)
+		http.Error(w, "error getting pending transcodes", 500)
+		return
+	}
+	transcodes, err := s.st.Transcodes(r.Context())
+	if err != nil {
+		log.Printf("error getting transcodes: %s", err)
+		http.Error(w, "error getting transcodes", 500)
+		return
+	}
+	s.renderTemplate("admin-transcodes.html", w, r, map[string]interface{}{
+		"config":     s.st.Transcode,
+		"pending":    pending,
+		"transcodes": transcodes,
+	})
+}
+
+type adminTranscodeRetryQuery struct {
+	// Source is in the form instead of the path, as it may contain slashes.
+	Source string `schema:"source,required"`
+}
+
+// adminTranscodeRetry makes the next run transcode a source whose transcode failed.
+func (s *Server) adminTranscodeRetry(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+	decoder := newDecoder(r)
+	var query adminTranscodeRetryQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	err = s.st.TranscodeRetry(r.Context(), query.Source)
+	if errors.Is(err, storage.ErrTranscodeNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		log.Printf("error retrying transcode: %s", err)
+		http.Error(w, "error retrying transcode", 500)
+		return
+	}
+	http.Redirect(w, r, "/admin/transcodes", 302)
+}
+
+func (s *Server) adminJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.st.Jobs(r.Context())
+	if err != nil {
+		log.Printf("error getting jobs: %s", err)
+		http.Error(w, "error getting jobs", 500)
+		return
+	}
+	s.renderTemplate("admin-jobs.html", w, r, map[string]interface{}{
+		"jobs": jobs,
+	})
+}
+
+// adminJobRetry queues a failed job again.
+func (s *Server) adminJobRetry(w http.ResponseWriter, r *http.Request) {
+	id, ok := intID(w, r)
+	if !ok {
+		return
+	}
+	err := s.st.JobRetry(r.Context(), id)
+	if errors.Is(err, storage.ErrJobNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if err != nil {
+		log.Printf("error retrying job: %s", err)
+		http.Error(w, "error retrying job", 500)
+		return
+	}
+	http.Redirect(w, r, "/admin/jobs", 302)
+}
+
+// adminJobRebuildFTS queues a rebuild of the full text search indexes.
+func (s *Server) adminJobRebuildFTS(w http.ResponseWriter, r *http.Request) {
+	err := s.st.EnqueueJob(r.Context(), storage.JobRebuildFTS, struct{}{})
+	if err != nil {
+		log.Printf("error queueing job: %s", err)
+		http.Error(w, "error queueing job", 500)
+		return
+	}
+	http.Redirect(w, r, "/admin/jobs", 302)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"slices"
 
@@ -15,9 +16,18 @@ type TokenInfo struct {
 
 type Permission string
 
+type tokenHashKey struct{}
+
+// TokenHashKey is the key for the tokens.TokenHash of the API token in the request context.
+// When using apiAuthz, this key will be set.
+var TokenHashKey tokenHashKey
+
 const (
 	PermissionWriteTranscript Permission = "write:transcript"
 	PermissionReadEvents      Permission = "read:events"
+	PermissionWriteSamples    Permission = "write:samples"
+	PermissionReadTags        Permission = "read:tags"
+	PermissionWriteTags       Permission = "write:tags"
 )
 
 func (s *Server) apiAuthz(permissionsRequired ...Permission) func(next http.Handler) http.Handler {
@@ -35,7 +45,19 @@ func (s *Server) apiAuthz(permissionsRequired ...Permission) func(next http.Hand
 					return
 				}
 			}
+			r = r.WithContext(context.WithValue(r.Context(), TokenHashKey, token.Hash()))
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// requestAuthor returns who made the request, for revisions: the GitHub login, or the hash of the API token.
+func requestAuthor(r *http.Request) string {
+	if data, ok := r.Context().Value(LoginUserDataKey).(githubUserData); ok {
+		return data.Login
+	}
+	if hash, ok := r.Context().Value(TokenHashKey).(tokens.TokenHash); ok {
+		return hash.String()
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"nyiyui.ca/seekback-server/storage"
+)
+
+type bookmarkQuery struct {
+	Note string `schema:"note"`
+	// Offset is parsed by parseOffset.
+	Offset string `schema:"offset"`
+}
+
+// bookmarkError writes the response for errors from the bookmark methods of storage.Storage.
+func bookmarkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidBookmark):
+		http.Error(w, err.Error(), 422)
+	case errors.Is(err, storage.ErrBookmarkNotFound), errors.Is(err, storage.ErrSampleNotFound):
+		http.Error(w, err.Error(), 404)
+	default:
+		log.Printf("error saving bookmark: %s", err)
+		http.Error(w, "error saving bookmark", 500)
+	}
+}
+
+// decodeBookmarkForm decodes the bookmark in the request's form. If it fails, the response is written and ok is false.
+func decodeBookmarkForm(w http.ResponseWriter, r *http.Request) (b storage.Bookmark, ok bool) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return b, false
+	}
+	decoder := newDecoder(r)
+	var query bookmarkQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return b, false
+	}
+	offset, err := parseOffset(query.Offset)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return b, false
+	}
+	return storage.Bookmark{Note: query.Note, Offset: offset}, true
+}
+
+// sampleBookmarksPost creates a bookmark.
+// Bookmarks made during playback (by sample.js) ask for JSON, so that playback is not interrupted by a redirect.
+func (s *Server) sampleBookmarksPost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	b, ok := decodeBookmarkForm(w, r)
+	if !ok {
+		return
+	}
+	b.SampleID = id
+	b, err := s.st.BookmarkCreate(r.Context(), b)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, 201, b)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s#bookmarks", id), 302)
+}
+
+func (s *Server) bookmarkPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := intID(w, r)
+	if !ok {
+		return
+	}
+	b, ok := decodeBookmarkForm(w, r)
+	if !ok {
+		return
+	}
+	b.ID = id
+	err := s.st.BookmarkUpdate(r.Context(), b)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	b, err = s.st.BookmarkGet(r.Context(), id)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s#bookmarks", b.SampleID), 302)
+}
+
+func (s *Server) bookmarkDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := intID(w, r)
+	if !ok {
+		return
+	}
+	b, err := s.st.BookmarkGet(r.Context(), id)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	err = s.st.BookmarkDelete(r.Context(), id)
+	if err != nil {
+		bookmarkError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s#bookmarks", b.SampleID), 302)
+}
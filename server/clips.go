@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"nyiyui.ca/seekback-server/storage"
+)
+
+type clipQuery struct {
+	Title string `schema:"title"`
+	Note  string `schema:"note"`
+	// Start and End are parsed by parseOffset.
+	Start string `schema:"start"`
+	End   string `schema:"end"`
+}
+
+// clip returns the clip in the form.
+func (q clipQuery) clip() (storage.Clip, error) {
+	start, err := parseOffset(q.Start)
+	if err != nil {
+		return storage.Clip{}, err
+	}
+	end, err := parseOffset(q.End)
+	if err != nil {
+		return storage.Clip{}, err
+	}
+	return storage.Clip{Title: q.Title, Note: q.Note, StartOffset: start, EndOffset: end}, nil
+}
+
+// clipError writes the response for errors from the clip methods of storage.Storage.
+func clipError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidClip):
+		http.Error(w, err.Error(), 422)
+	case errors.Is(err, storage.ErrClipNotFound), errors.Is(err, storage.ErrSampleNotFound):
+		http.Error(w, err.Error(), 404)
+	default:
+		log.Printf("error saving clip: %s", err)
+		http.Error(w, "error saving clip", 500)
+	}
+}
+
+// decodeClipForm decodes the clip in the request's form. If it fails, the response is written and ok is false.
+func decodeClipForm(w http.ResponseWriter, r *http.Request) (c storage.Clip, ok bool) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return c, false
+	}
+	decoder := newDecoder(r)
+	var query clipQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return c, false
+	}
+	c, err = query.clip()
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return c, false
+	}
+	return c, true
+}
+
+// intID parses the id path value. If it fails, the response is written and ok is false.
+func intID(w http.ResponseWriter, r *http.Request) (id int64, ok bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", 404)
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *Server) sampleClipsPost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	c, ok := decodeClipForm(w, r)
+	if !ok {
+		return
+	}
+	c.SampleID = id
+	c, err := s.st.ClipCreate(r.Context(), c)
+	if err != nil {
+		clipError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/clip/%d", c.ID), 302)
+}
+
+func (s *Server) clipView(w http.ResponseWriter, r *http.Request) {
+	id, ok := intID(w, r)
+	if !ok {
+		return
+	}
+	c, err := s.st.ClipGet(r.Context(), id)
+	if errors.Is(err, storage.ErrClipNotFound) {
+		http.Error(w, "clip not found", 404)
+		return
+	} else if err != nil {
+		log.Printf("error getting clip: %s", err)
+		http.Error(w, "error getting clip", 500)
+		return
+	}
+	sample, err := s.st.SampleGet(c.SampleID)
+	if err != nil {
+		log.Printf("error getting sample: %s", err)
+		http.Error(w, "error getting sample", 500)
+		return
+	}
+	s.renderTemplate("clip.html", w, r, map[string]interface{}{
+		"clip":   c,
+		"sample": sample,
+	})
+}
+
+func (s *Server) clipPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := intID(w, r)
+	if !ok {
+		return
+	}
+	c, ok := decodeClipForm(w, r)
+	if !ok {
+		return
+	}
+	c.ID = id
+	err := s.st.ClipUpdate(r.Context(), c)
+	if err != nil {
+		clipError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/clip/%d", id), 302)
+}
+
+func (s *Server) clipDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := intID(w, r)
+	if !ok {
+		return
+	}
+	c, err := s.st.ClipGet(r.Context(), id)
+	if err != nil {
+		clipError(w, err)
+		return
+	}
+	err = s.st.ClipDelete(r.Context(), id)
+	if err != nil {
+		clipError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s", c.SampleID), 302)
+}
@@ -3,14 +3,17 @@ package server
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"reflect"
 	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/deiu/rdf2go"
@@ -92,11 +95,45 @@ func (s *Server) setup() error {
 
 	s.mux.Handle("GET /samples", composeFunc(s.samplesView, s.mainLogin))
 	s.mux.Handle("GET /sample/{id}", composeFunc(s.sampleView, s.mainLogin))
-	s.mux.Handle("GET /file/{name}", composeFunc(s.fileServe, s.mainLogin))
+	s.mux.Handle("GET /file/{name...}", composeFunc(s.fileServe, s.mainLogin))
 	s.mux.Handle("POST /sample/{id}/transcript", composeFunc(s.sampleTranscriptPost, s.apiAuthz(PermissionWriteTranscript)))
 	s.mux.Handle("POST /sample/{id}/summary", composeFunc(s.sampleSummaryPost, s.mainLogin))
-	//s.mux.Handle("POST /sample/new", composeFunc(s.sampleNew, s.mainLogin))
-	s.mux.Handle("GET /static", http.FileServer(http.FS(staticFS)))
+	s.mux.Handle("GET /sample/{id}/history", composeFunc(s.sampleHistory, s.mainLogin))
+	s.mux.Handle("POST /revision/{id}/revert", composeFunc(s.revisionRevertPost, s.mainLogin))
+	s.mux.Handle("POST /sample/{id}/metadata", composeFunc(s.sampleMetadataPost, s.mainLogin))
+	s.mux.Handle("POST /sample/{id}/tags", composeFunc(s.sampleTagsPost, s.mainLogin))
+	s.mux.Handle("POST /sample/{id}/clips", composeFunc(s.sampleClipsPost, s.mainLogin))
+	s.mux.Handle("GET /clip/{id}", composeFunc(s.clipView, s.mainLogin))
+	s.mux.Handle("POST /clip/{id}", composeFunc(s.clipPost, s.mainLogin))
+	s.mux.Handle("POST /clip/{id}/delete", composeFunc(s.clipDeletePost, s.mainLogin))
+	s.mux.Handle("GET /sample/{id}/peaks", composeFunc(s.samplePeaksGet, s.mainLogin))
+	s.mux.Handle("POST /sample/{id}/bookmarks", composeFunc(s.sampleBookmarksPost, s.mainLogin))
+	s.mux.Handle("POST /bookmark/{id}", composeFunc(s.bookmarkPost, s.mainLogin))
+	s.mux.Handle("POST /bookmark/{id}/delete", composeFunc(s.bookmarkDeletePost, s.mainLogin))
+	s.mux.Handle("POST /sample/{id}/tags/remove", composeFunc(s.sampleTagRemovePost, s.mainLogin))
+	s.mux.Handle("GET /sample/{id}/tags", composeFunc(s.sampleTagsGet, s.apiAuthz(PermissionReadTags)))
+	s.mux.Handle("PUT /sample/{id}/tags/{tag}", composeFunc(s.sampleTagPut, s.apiAuthz(PermissionWriteTags)))
+	s.mux.Handle("DELETE /sample/{id}/tags/{tag}", composeFunc(s.sampleTagDelete, s.apiAuthz(PermissionWriteTags)))
+	s.mux.Handle("GET /admin/sync", composeFunc(s.adminSync, s.mainLogin))
+	s.mux.Handle("POST /admin/sync", composeFunc(s.adminSync, s.mainLogin))
+	s.mux.Handle("GET /admin/conflicts", composeFunc(s.adminConflicts, s.mainLogin))
+	s.mux.Handle("POST /admin/conflicts/{id}/resolve", composeFunc(s.adminConflictResolve, s.mainLogin))
+	s.mux.Handle("GET /admin/tombstones", composeFunc(s.adminTombstones, s.mainLogin))
+	s.mux.Handle("POST /admin/tombstones/{id}/purge", composeFunc(s.adminTombstonePurge, s.mainLogin))
+	s.mux.Handle("POST /admin/tombstones/{id}/restore", composeFunc(s.adminTombstoneRestore, s.mainLogin))
+	s.mux.Handle("GET /admin/retention", composeFunc(s.adminRetention, s.mainLogin))
+	s.mux.Handle("POST /admin/retention", composeFunc(s.adminRetentionApply, s.mainLogin))
+	s.mux.Handle("GET /admin/transcodes", composeFunc(s.adminTranscodes, s.mainLogin))
+	s.mux.Handle("POST /admin/transcodes/retry", composeFunc(s.adminTranscodeRetry, s.mainLogin))
+	s.mux.Handle("GET /admin/jobs", composeFunc(s.adminJobs, s.mainLogin))
+	s.mux.Handle("POST /admin/jobs/{id}/retry", composeFunc(s.adminJobRetry, s.mainLogin))
+	s.mux.Handle("POST /admin/jobs/rebuild-fts", composeFunc(s.adminJobRebuildFTS, s.mainLogin))
+	s.mux.Handle("POST /sample/new", composeFunc(s.sampleNew, s.apiAuthz(PermissionWriteSamples)))
+	s.mux.Handle("GET /upload/{upload}", composeFunc(s.uploadGet, s.apiAuthz(PermissionWriteSamples)))
+	s.mux.Handle("PATCH /upload/{upload}", composeFunc(s.uploadPatch, s.apiAuthz(PermissionWriteSamples)))
+	s.mux.Handle("DELETE /upload/{upload}", composeFunc(s.uploadDelete, s.apiAuthz(PermissionWriteSamples)))
+	s.mux.Handle("POST /upload/{upload}/complete", composeFunc(s.uploadComplete, s.apiAuthz(PermissionWriteSamples)))
+	s.mux.Handle("GET /static/", http.FileServer(http.FS(staticFS)))
 	s.mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/samples", http.StatusFound) // we may want to change this redirect later on
 	})
@@ -128,6 +165,15 @@ type eventsQuery struct {
 	TimeStart time.Time `schema:"time_start"`
 	TimeEnd   time.Time `schema:"time_end"`
 	Overlap   bool      `schema:"overlap"`
+	// Clips makes the response an object with samples, clips and bookmarks, instead of an array of samples.
+	Clips bool `schema:"clips"`
+}
+
+// eventsWithClips is the response of getEvents with clips=true.
+type eventsWithClips struct {
+	Samples   []storage.SamplePreviewWithSnippet `json:"samples"`
+	Clips     []storage.ClipWithSnippet          `json:"clips"`
+	Bookmarks []storage.BookmarkWithSnippet      `json:"bookmarks"`
 }
 
 type Event struct {
@@ -135,6 +181,12 @@ type Event struct {
 	Summary string `json:"summary"`
 }
 
+// getEvents returns the samples in the time range given by time_start and time_end (RFC 3339),
+// either contained in it, or overlapping it with overlap=true, newest first.
+//
+// By default, the response is a JSON array of samples (storage.SamplePreviewWithSnippet), as it always was.
+// With clips=true, the response is instead a JSON object (eventsWithClips) with the samples under "samples",
+// and the clips and bookmarks in the time range, newest first, under "clips" and "bookmarks".
 func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
 	decoder := schema.NewDecoder()
 	decoder.RegisterConverter(time.Time{}, func(s string) reflect.Value {
@@ -170,8 +222,31 @@ func (s *Server) getEvents(w http.ResponseWriter, r *http.Request) {
 		return sps[i].Start.After(sps[j].Start)
 	})
 
+	var events interface{} = sps
+	if query.Clips {
+		clips, err := s.st.SearchClips(so, r.Context())
+		if err != nil {
+			log.Printf("error getting clips: %s", err)
+			http.Error(w, "error getting clips", 500)
+			return
+		}
+		sort.Slice(clips, func(i, j int) bool {
+			return clips[i].Start.After(clips[j].Start)
+		})
+		bookmarks, err := s.st.SearchBookmarks(so, r.Context())
+		if err != nil {
+			log.Printf("error getting bookmarks: %s", err)
+			http.Error(w, "error getting bookmarks", 500)
+			return
+		}
+		sort.Slice(bookmarks, func(i, j int) bool {
+			return bookmarks[i].Time.After(bookmarks[j].Time)
+		})
+		events = eventsWithClips{Samples: sps, Clips: clips, Bookmarks: bookmarks}
+	}
+
 	enc := json.NewEncoder(w)
-	err = enc.Encode(sps)
+	err = enc.Encode(events)
 	if err != nil {
 		log.Printf("error encoding json: %s", err)
 		http.Error(w, "error encoding json", 500)
@@ -183,6 +258,12 @@ type samplesViewQuery struct {
 	TimeStart *time.Time `schema:"time_start"`
 	TimeEnd   *time.Time `schema:"time_end"`
 	Query     string     `schema:"query"`
+	// Tags is a comma-separated list of tags.
+	Tags string `schema:"tags"`
+	// TagsMode is "all" or "any" (the default).
+	TagsMode string `schema:"tags_mode"`
+	// Silent is "hide" or "dim" for samples in which no speech was detected, or "show" (the default).
+	Silent string `schema:"silent"`
 }
 
 func (s *Server) samplesView(w http.ResponseWriter, r *http.Request) {
@@ -201,12 +282,21 @@ func (s *Server) samplesView(w http.ResponseWriter, r *http.Request) {
 		query.TimeEnd = nil
 	}
 
-	so := storage.SearchOptions{Query: query.Query}
+	tags, err := storage.ParseTags(query.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	so := storage.SearchOptions{Query: query.Query, Tags: tags, TagsMatchAll: query.TagsMode == "all"}
 	if query.TimeStart != nil && query.TimeEnd != nil {
 		so.SetOverlap(*query.TimeStart, *query.TimeEnd)
 	}
 
-	sps, err := s.st.Search(so, r.Context())
+	// HideSilent is not set on so, as it only applies to samples (not clips or bookmarks).
+	sampleSo := so
+	sampleSo.HideSilent = query.Silent == "hide"
+	sps, err := s.st.Search(sampleSo, r.Context())
 	if err != nil {
 		log.Printf("error getting sample list: %s", err)
 		http.Error(w, fmt.Sprintf("error getting sample list: %s", err), 500)
@@ -224,10 +314,37 @@ func (s *Server) samplesView(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	var clips []storage.ClipWithSnippet
+	if so.Query != "" || len(so.Tags) != 0 || so.StartBefore != nil {
+		clips, err = s.st.SearchClips(so, r.Context())
+		if err != nil {
+			log.Printf("error getting clips: %s", err)
+			http.Error(w, "error getting clips", 500)
+			return
+		}
+	}
+	var bookmarks []storage.BookmarkWithSnippet
+	if so.Query != "" {
+		bookmarks, err = s.st.SearchBookmarks(so, r.Context())
+		if err != nil {
+			log.Printf("error getting bookmarks: %s", err)
+			http.Error(w, "error getting bookmarks", 500)
+			return
+		}
+	}
+	tagCounts, err := s.st.TagCounts(r.Context())
+	if err != nil {
+		log.Printf("error getting tag counts: %s", err)
+		http.Error(w, "error getting tag counts", 500)
+		return
+	}
 	s.renderTemplate("samples.html", w, r, map[string]interface{}{
 		"query":                     query,
 		"samples":                   sps,
 		"allSamplesHaveTranscripts": allSamplesHaveTranscripts,
+		"tagCounts":                 tagCounts,
+		"clips":                     clips,
+		"bookmarks":                 bookmarks,
 	})
 }
 
@@ -251,29 +368,87 @@ func (s *Server) sampleView(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "error getting overlaps", 500)
 		return
 	}
+	clips, err := s.st.SampleClips(r.Context(), id)
+	if err != nil {
+		log.Printf("error getting clips: %s", err)
+		http.Error(w, "error getting clips", 500)
+		return
+	}
+	bookmarks, err := s.st.SampleBookmarks(r.Context(), id)
+	if err != nil {
+		log.Printf("error getting bookmarks: %s", err)
+		http.Error(w, "error getting bookmarks", 500)
+		return
+	}
+	speech, err := s.st.SampleSpeech(r.Context(), id)
+	if err != nil {
+		log.Printf("error getting speech segments: %s", err)
+		http.Error(w, "error getting speech segments", 500)
+		return
+	}
 	s.renderTemplate("sample.html", w, r, map[string]interface{}{
-		"sample":   sample,
-		"overlaps": overlaps,
+		"sample":    sample,
+		"overlaps":  overlaps,
+		"clips":     clips,
+		"bookmarks": bookmarks,
+		"speech":    speech,
 	})
 }
 
+// transcriptFormats maps the Content-Types of transcripts that are not WebVTT to their formats.
+// Other Content-Types (e.g. none, or application/x-www-form-urlencoded from clients that send it by default) are taken as WebVTT, as they were before other formats were accepted.
+var transcriptFormats = map[string]storage.TranscriptFormat{
+	"application/x-subrip": storage.TranscriptSRT,
+	"application/srt":      storage.TranscriptSRT,
+	"text/srt":             storage.TranscriptSRT,
+	"application/json":     storage.TranscriptWhisper,
+}
+
+// transcriptErrorsText returns the errors in a transcript, one per line.
+func transcriptErrorsText(errs storage.TranscriptErrors) string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
 func (s *Server) sampleTranscriptPost(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if id == "" {
 		http.Error(w, "missing id", 400)
 		return
 	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, ok := transcriptFormats[contentType]
+	if !ok {
+		format = storage.TranscriptVTT
+	}
 	transcript, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "error reading transcript", 500)
 		return
 	}
-	err = s.st.SampleTranscriptSet(id, string(transcript), r.Context())
-	if err != nil {
+	skipped, err := s.st.SampleTranscriptImport(r.Context(), id, format, transcript, requestAuthor(r))
+	var terrs storage.TranscriptErrors
+	if errors.As(err, &terrs) {
+		http.Error(w, transcriptErrorsText(terrs), 422)
+		return
+	} else if errors.Is(err, storage.ErrSampleNotFound) {
+		http.Error(w, "sample not found", 404)
+		return
+	} else if errors.Is(err, storage.ErrNoMedia) {
+		http.Error(w, "sample has no media in the samples directory", 409)
+		return
+	} else if err != nil {
 		log.Printf("error setting transcript: %s", err)
 		http.Error(w, "error setting transcript", 500)
 		return
 	}
+	if len(skipped) != 0 {
+		http.Error(w, "transcript set, skipping cues with errors:\n"+transcriptErrorsText(skipped), 200)
+		return
+	}
 	http.Error(w, "transcript set", 200)
 }
 
@@ -302,10 +477,103 @@ func (s *Server) sampleSummaryPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.st.SampleSummarySet(id, query.Summary, r.Context())
+	err = s.st.SampleSummarySet(id, query.Summary, requestAuthor(r), r.Context())
+	if errors.Is(err, storage.ErrSampleNotFound) {
+		http.Error(w, "sample not found", 404)
+		return
+	} else if err != nil {
+		log.Printf("error setting summary: %s", err)
+		http.Error(w, "error setting summary", 500)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s", id), 302)
+}
+
+type sampleMetadataPostQuery struct {
+	Device     string `schema:"device"`
+	App        string `schema:"app"`
+	AppVersion string `schema:"app_version"`
+	// Numbers are optional, so they are parsed by parseOptionalFloat.
+	InputGain string `schema:"input_gain"`
+	Latitude  string `schema:"latitude"`
+	Longitude string `schema:"longitude"`
+	Altitude  string `schema:"altitude"`
+	Accuracy  string `schema:"accuracy"`
+}
+
+// metadata returns the metadata in the form. The location is only set if latitude and longitude are.
+func (q sampleMetadataPostQuery) metadata() (storage.SampleMetadata, error) {
+	m := storage.SampleMetadata{
+		Device:     q.Device,
+		App:        q.App,
+		AppVersion: q.AppVersion,
+	}
+	var err error
+	m.InputGain, err = parseOptionalFloat(q.InputGain, "input gain")
 	if err != nil {
-		log.Printf("error setting transcript: %s", err)
-		http.Error(w, "error setting transcript", 500)
+		return m, err
+	}
+	latitude, err := parseOptionalFloat(q.Latitude, "latitude")
+	if err != nil {
+		return m, err
+	}
+	longitude, err := parseOptionalFloat(q.Longitude, "longitude")
+	if err != nil {
+		return m, err
+	}
+	if (latitude == nil) != (longitude == nil) {
+		return m, errors.New("latitude and longitude must be set together")
+	}
+	if latitude != nil {
+		m.Location = &storage.GeoLocation{Latitude: *latitude, Longitude: *longitude}
+		m.Location.Altitude, err = parseOptionalFloat(q.Altitude, "altitude")
+		if err != nil {
+			return m, err
+		}
+		m.Location.Accuracy, err = parseOptionalFloat(q.Accuracy, "accuracy")
+		if err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+func (s *Server) sampleMetadataPost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+
+	decoder := newDecoder(r)
+	var query sampleMetadataPostQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	m, err := query.metadata()
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	err = s.st.SampleMetadataSet(r.Context(), id, m)
+	if errors.Is(err, storage.ErrInvalidMetadata) {
+		http.Error(w, err.Error(), 422)
+		return
+	} else if errors.Is(err, storage.ErrNoMedia) {
+		http.Error(w, "sample has no media in the samples directory", 409)
+		return
+	} else if err != nil {
+		log.Printf("error setting metadata: %s", err)
+		http.Error(w, "error setting metadata", 500)
 		return
 	}
 	http.Redirect(w, r, fmt.Sprintf("/sample/%s", id), 302)
@@ -317,11 +585,25 @@ func (s *Server) fileServe(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing name", 400)
 		return
 	}
+	// name is a slash-separated path relative to the samples directory (samples may be in subdirectories).
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) || strings.HasPrefix(local, ".") || strings.Contains(name, "/.") {
+		http.Error(w, "invalid name", 404)
+		return
+	}
 	ext := filepath.Ext(name)
-	if !slices.Contains(storage.AllowedFileTypes, ext[1:]) {
+	if len(ext) == 0 || !(slices.Contains(storage.AllowedFileTypes, ext[1:]) || storage.IsTranscriptOriginal(name)) {
 		http.Error(w, "file type not allowed", 404)
 		return
 	}
 
-	http.ServeFile(w, r, filepath.Join(s.st.SamplesPath, name))
+	// Some media types (e.g. opus) are not in the system MIME database.
+	if mime, ok := storage.MediaFileTypes[ext[1:]]; ok {
+		w.Header().Set("Content-Type", mime)
+	} else if ext == "."+storage.TranscriptExt {
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	} else if ext == ".srt" {
+		w.Header().Set("Content-Type", "application/x-subrip; charset=utf-8")
+	}
+	http.ServeFile(w, r, filepath.Join(s.st.SamplesPath, local))
 }
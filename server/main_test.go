@@ -0,0 +1,258 @@
+//go:build fts5
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/seekback-server/database"
+	"nyiyui.ca/seekback-server/storage"
+)
+
+// newTestServer returns a Server with a storage of samples with the given IDs, each an hour long.
+func newTestServer(t *testing.T, ids ...string) *Server {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = database.Migrate(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, id := range ids {
+		err = os.WriteFile(filepath.Join(dir, id+".opus"), []byte("x"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := storage.New(dir, db)
+	st.FallbackProber = nil
+	ctx := context.Background()
+	_, err = st.Sync(ctx, storage.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, "UPDATE samples SET duration=?", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{st: st}
+}
+
+func TestGetEventsDefaultShape(t *testing.T) {
+	a := "2024-01-02T10:00:00+00:00"
+	s := newTestServer(t, a)
+	_, err := s.st.ClipCreate(context.Background(), storage.Clip{SampleID: a, Title: "clip", StartOffset: time.Minute, EndOffset: 2 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := url.Values{}
+	q.Set("time_start", "2024-01-02T00:00:00Z")
+	q.Set("time_end", "2024-01-03T00:00:00Z")
+	w := httptest.NewRecorder()
+	s.getEvents(w, httptest.NewRequest("GET", "/events?"+q.Encode(), nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	// Without clips=true, the response is an array of samples, with (at least) the fields it always had.
+	var samples []map[string]json.RawMessage
+	err = json.Unmarshal(w.Body.Bytes(), &samples)
+	if err != nil {
+		t.Fatalf("expected an array of samples: %s", err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("expected 1 sample, got %d", len(samples))
+	}
+	for _, key := range []string{"ID", "Start", "Duration", "End", "Summary", "Transcript", "Media", "Snippet"} {
+		if _, ok := samples[0][key]; !ok {
+			t.Fatalf("expected the sample to have %s, got %s", key, w.Body)
+		}
+	}
+	if string(samples[0]["ID"]) != `"`+a+`"` {
+		t.Fatalf("expected sample %s, got %s", a, samples[0]["ID"])
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	a := "2024-01-02T10:00:00+00:00"
+	b := "2024-01-02T12:00:00+00:00"
+	outside := "2024-01-03T10:00:00+00:00"
+	s := newTestServer(t, a, b, outside)
+	ctx := context.Background()
+	for _, id := range []string{a, b, outside} {
+		_, err := s.st.ClipCreate(ctx, storage.Clip{SampleID: id, Title: "clip " + id, StartOffset: time.Minute, EndOffset: 2 * time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.st.BookmarkCreate(ctx, storage.Bookmark{SampleID: id, Offset: time.Minute, Note: "bookmark " + id})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(clips bool) []byte {
+		t.Helper()
+		q := url.Values{}
+		q.Set("time_start", "2024-01-02T00:00:00Z")
+		q.Set("time_end", "2024-01-03T00:00:00Z")
+		if clips {
+			q.Set("clips", "true")
+		}
+		w := httptest.NewRecorder()
+		s.getEvents(w, httptest.NewRequest("GET", "/events?"+q.Encode(), nil))
+		if w.Code != 200 {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		return w.Body.Bytes()
+	}
+
+	var samples []struct {
+		ID string `json:"id"`
+	}
+	err := json.Unmarshal(get(false), &samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0].ID != b || samples[1].ID != a {
+		t.Fatalf("expected samples %s and %s, got %+v", b, a, samples)
+	}
+
+	var events struct {
+		Samples []struct {
+			ID string `json:"id"`
+		} `json:"samples"`
+		Clips []struct {
+			SampleID string `json:"sample_id"`
+			Title    string `json:"title"`
+		} `json:"clips"`
+		Bookmarks []struct {
+			SampleID string    `json:"sample_id"`
+			Note     string    `json:"note"`
+			Time     time.Time `json:"time"`
+		} `json:"bookmarks"`
+	}
+	err = json.Unmarshal(get(true), &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Samples) != 2 {
+		t.Fatalf("expected 2 samples, got %+v", events.Samples)
+	}
+	if len(events.Clips) != 2 || events.Clips[0].SampleID != b || events.Clips[1].SampleID != a {
+		t.Fatalf("expected the clips of %s and %s, got %+v", b, a, events.Clips)
+	}
+	if len(events.Bookmarks) != 2 || events.Bookmarks[0].SampleID != b || events.Bookmarks[1].SampleID != a {
+		t.Fatalf("expected the bookmarks of %s and %s, got %+v", b, a, events.Bookmarks)
+	}
+	want := time.Date(2024, 1, 2, 12, 1, 0, 0, time.UTC)
+	if events.Bookmarks[0].Note != "bookmark "+b || !events.Bookmarks[0].Time.Equal(want) {
+		t.Fatalf("expected the bookmark at %s, got %+v", want, events.Bookmarks[0])
+	}
+}
+
+// TestRenderPages renders each HTML page, as safehtml only reports escaping errors when a template is executed.
+func TestRenderPages(t *testing.T) {
+	a := "2024-01-02T10:00:00+00:00"
+	b := "2024-01-02T12:00:00+00:00"
+	gone := "2024-01-03T10:00:00+00:00"
+	s := newTestServer(t, a, b, gone)
+	err := s.parseTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := s.st
+	ctx := context.Background()
+
+	clip, err := st.ClipCreate(ctx, storage.Clip{SampleID: a, Title: "clip <title>", StartOffset: time.Minute, EndOffset: 2 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = st.BookmarkCreate(ctx, storage.Bookmark{SampleID: a, Offset: time.Minute, Note: "bookmark <note>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SampleTagAdd(ctx, a, "meeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SampleSummarySet(a, "first summary", "someone", ctx)
+	if err == nil {
+		err = st.SampleSummarySet(a, "second summary", "someone", ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SampleTranscriptSet(a, "WEBVTT\n\n00:00.000 --> 00:01.000\n<v Speaker>hello\n", "someone", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"INSERT INTO speech_segments (sample_id, start_offset, end_offset) VALUES ('" + a + "', 0, 1000000000)",
+		"UPDATE samples SET speech_duration=1000000000 WHERE id='" + a + "'",
+		"UPDATE samples SET speech_duration=0 WHERE id='" + b + "'",
+		"INSERT INTO summary_conflicts (sample_id, db_summary, file_summary, detected_at) VALUES ('" + b + "', 'db', 'file', '2024-01-04T00:00:00Z')",
+		"INSERT INTO transcodes (source, sample_id, output, status, error, started_at) VALUES ('" + b + ".opus', '" + b + "', '" + b + ".ogg', 'failed', 'ffmpeg failed', '2024-01-04T00:00:00Z')",
+		"INSERT INTO retention_log (sample_id, rule, name, size, removed_at) VALUES ('" + b + "', 'old', '" + b + ".wav', 3, '2024-01-04T00:00:00Z')",
+	} {
+		_, err = st.DB.ExecContext(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Remove(filepath.Join(st.SamplesPath, gone+".opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = st.Sync(ctx, storage.SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.RetentionRules = []storage.RetentionRule{{Name: "old", OlderThanDays: 1}}
+	st.Transcode = &storage.TranscodeConfig{Ext: "ogg", Codec: "libopus", Bitrate: "32k", SourceExts: []string{"opus"}}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		values  map[string]string
+	}{
+		{"samples", s.samplesView, "/samples", nil},
+		{"samples filtered", s.samplesView, "/samples?query=hello&tags=meeting&tags_mode=all&silent=dim&time_start=2024-01-01T00:00:00Z&time_end=2024-01-05T00:00:00Z", nil},
+		{"samples hiding silent", s.samplesView, "/samples?silent=hide", nil},
+		{"sample", s.sampleView, "/sample/" + a, map[string]string{"id": a}},
+		{"silent sample", s.sampleView, "/sample/" + b, map[string]string{"id": b}},
+		{"clip", s.clipView, "/clip/" + strconv.FormatInt(clip.ID, 10), map[string]string{"id": strconv.FormatInt(clip.ID, 10)}},
+		{"sample history", s.sampleHistory, "/sample/" + a + "/history", map[string]string{"id": a}},
+		{"sample history with a diff", s.sampleHistory, "/sample/" + a + "/history?diff=2", map[string]string{"id": a}},
+		{"admin conflicts", s.adminConflicts, "/admin/conflicts", nil},
+		{"admin tombstones", s.adminTombstones, "/admin/tombstones", nil},
+		{"admin retention", s.adminRetention, "/admin/retention", nil},
+		{"admin transcodes", s.adminTranscodes, "/admin/transcodes", nil},
+		{"admin jobs", s.adminJobs, "/admin/jobs", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			for k, v := range tc.values {
+				r.SetPathValue(k, v)
+			}
+			w := httptest.NewRecorder()
+			tc.handler(w, r)
+			if w.Code != 200 || strings.Contains(w.Body.String(), "template error") {
+				t.Fatalf("expected the page to render, got %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
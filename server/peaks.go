@@ -0,0 +1,40 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"nyiyui.ca/seekback-server/storage"
+)
+
+type peaksResponse struct {
+	// Duration is in seconds; each peak covers Duration/len(Peaks).
+	Duration float64 `json:"duration"`
+	// Peaks are from 0 to 255.
+	Peaks []int  `json:"peaks"`
+	Error string `json:"error,omitempty"`
+}
+
+// samplePeaksGet responds with the waveform peaks of a sample as JSON.
+func (s *Server) samplePeaksGet(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	p, err := s.st.SamplePeaks(r.Context(), id)
+	if errors.Is(err, storage.ErrPeaksNotFound) {
+		http.Error(w, "peaks not found", 404)
+		return
+	} else if err != nil {
+		log.Printf("error getting peaks: %s", err)
+		http.Error(w, "error getting peaks", 500)
+		return
+	}
+	resp := peaksResponse{Duration: p.Duration.Seconds(), Peaks: make([]int, len(p.Data)), Error: p.Error}
+	for i, b := range p.Data {
+		resp.Peaks[i] = int(b)
+	}
+	writeJSON(w, 200, resp)
+}
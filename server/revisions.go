@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/schema"
+	"nyiyui.ca/seekback-server/storage"
+)
+
+// revisionDiffContext is the number of unchanged lines shown around each change in a revision's diff.
+const revisionDiffContext = 3
+
+// revisionEntry is a revision, with its diff from the revision before it if it is shown.
+type revisionEntry struct {
+	storage.Revision
+	Diff []storage.DiffLine
+	// ShowDiff is true for the revision whose diff was asked for. Diffs are only computed when asked for, as long transcripts take a while to diff.
+	ShowDiff bool
+	// Current is true for the latest revision, which there is no point reverting to.
+	Current bool
+}
+
+// revisionEntries returns the entries of revisions (newest first, as returned by storage.Storage.SampleRevisions), with the diff of the revision with ID diffID.
+func revisionEntries(revisions []storage.Revision, diffID int64) []revisionEntry {
+	entries := make([]revisionEntry, len(revisions))
+	for i, rev := range revisions {
+		entries[i] = revisionEntry{
+			Revision: rev,
+			ShowDiff: rev.ID == diffID,
+			Current:  i == 0,
+		}
+		if !entries[i].ShowDiff {
+			continue
+		}
+		var prev string
+		if i+1 < len(revisions) {
+			prev = revisions[i+1].Body
+		}
+		entries[i].Diff = storage.DiffLines(prev, rev.Body, revisionDiffContext)
+	}
+	return entries
+}
+
+type sampleHistoryQuery struct {
+	// Diff is the ID of the revision whose diff is shown.
+	Diff int64 `schema:"diff"`
+}
+
+// sampleHistory shows the revisions of a sample's summary and transcript.
+func (s *Server) sampleHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	var query sampleHistoryQuery
+	err := schema.NewDecoder().Decode(&query, r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	sample, err := s.st.SampleGet(id)
+	if errors.Is(err, storage.ErrSampleNotFound) {
+		http.Error(w, "sample not found", 404)
+		return
+	} else if err != nil {
+		log.Printf("error getting sample: %s", err)
+		http.Error(w, "error getting sample", 500)
+		return
+	}
+	summaries, err := s.st.SampleRevisions(r.Context(), id, storage.RevisionSummary)
+	if err != nil {
+		log.Printf("error getting summary revisions: %s", err)
+		http.Error(w, "error getting revisions", 500)
+		return
+	}
+	transcripts, err := s.st.SampleRevisions(r.Context(), id, storage.RevisionTranscript)
+	if err != nil {
+		log.Printf("error getting transcript revisions: %s", err)
+		http.Error(w, "error getting revisions", 500)
+		return
+	}
+	s.renderTemplate("sample-history.html", w, r, map[string]interface{}{
+		"sample":      sample,
+		"summaries":   revisionEntries(summaries, query.Diff),
+		"transcripts": revisionEntries(transcripts, query.Diff),
+	})
+}
+
+// revisionRevertPost sets a revision's field back to the revision.
+func (s *Server) revisionRevertPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := intID(w, r)
+	if !ok {
+		return
+	}
+	rev, err := s.st.RevisionRevert(r.Context(), id, requestAuthor(r))
+	if errors.Is(err, storage.ErrRevisionNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	} else if errors.Is(err, storage.ErrSampleNotFound) {
+		http.Error(w, "sample not found", 404)
+		return
+	} else if errors.Is(err, storage.ErrNoMedia) {
+		http.Error(w, "sample has no media in the samples directory", 409)
+		return
+	} else if err != nil {
+		log.Printf("error reverting revision %d: %s", id, err)
+		http.Error(w, "error reverting revision", 500)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s/history", rev.SampleID), 302)
+}
@@ -0,0 +1,223 @@
+'use strict';
+
+// Seeks the player on the sample page to the time in the location hash (#t=<seconds>).
+const seekToHash = () => {
+  const match = window.location.hash.match(/^#t=(\d+(?:\.\d+)?)$/);
+  const player = document.querySelector('#playback video');
+  if (!match || !player) {
+    return;
+  }
+  player.currentTime = parseFloat(match[1]);
+  player.play();
+};
+
+// Formats seconds as m:ss.fff (the form accepted for clip offsets).
+const formatOffset = (seconds) => {
+  const m = Math.floor(seconds / 60);
+  const s = (seconds % 60).toFixed(3).padStart(6, '0');
+  return `${m}:${s}`;
+};
+
+// Buttons with data-set-offset="<input name>" set the input to the player's current time.
+const handleSetOffset = (e) => {
+  const button = e.target.closest('[data-set-offset]');
+  const player = document.querySelector('#playback video');
+  if (!button || !player) {
+    return;
+  }
+  e.preventDefault();
+  const input = button.form.elements[button.dataset.setOffset];
+  input.value = formatOffset(player.currentTime);
+};
+
+// Pressing b bookmarks the player's current time, with a note asked for with a prompt.
+// The bookmark is added to the list without reloading, so playback continues.
+const handleBookmarkKey = async (e) => {
+  const list = document.querySelector('#bookmark-list');
+  const player = document.querySelector('#playback video');
+  if (e.key !== 'b' || e.ctrlKey || e.metaKey || e.altKey || !list || !player) {
+    return;
+  }
+  if (e.target.closest('input, textarea, select, [contenteditable]')) {
+    return;
+  }
+  e.preventDefault();
+  const offset = player.currentTime;
+  const note = window.prompt(`Bookmark at ${formatOffset(offset)}`, '');
+  if (note === null) {
+    return;
+  }
+  const body = new FormData();
+  body.set('offset', offset.toFixed(3));
+  body.set('note', note);
+  const resp = await fetch(`/sample/${encodeURIComponent(list.dataset.sampleId)}/bookmarks`, {
+    method: 'POST',
+    headers: { Accept: 'application/json' },
+    body: new URLSearchParams(body),
+  });
+  if (!resp.ok) {
+    window.alert(`Bookmark failed: ${await resp.text()}`);
+    return;
+  }
+  const bookmark = await resp.json();
+  const seconds = bookmark.offset / 1e9;
+  const li = document.createElement('li');
+  const a = document.createElement('a');
+  a.href = `#t=${seconds}`;
+  a.textContent = formatOffset(seconds);
+  li.append(a, ` ${bookmark.note}`);
+  const next = [...list.children].find((el) => {
+    const link = el.querySelector('a[href^="#t="]');
+    return link && parseFloat(link.hash.slice(3)) > seconds;
+  });
+  list.insertBefore(li, next || null);
+  drawWaveform();
+};
+
+// Returns the speech segments (in seconds) listed on the page.
+const speechSegments = () => [...document.querySelectorAll('#speech-list a[href^="#t="]')]
+  .map((a) => ({ start: parseFloat(a.hash.slice(3)), end: parseFloat(a.dataset.end) }));
+
+// Seeks the player to the start of the first speech segment after the current time.
+const nextSpeech = () => {
+  const player = document.querySelector('#playback video');
+  if (!player) {
+    return;
+  }
+  // a little slack, so that repeated presses do not stay at the same segment
+  const next = speechSegments().find((seg) => seg.start > player.currentTime + 0.1);
+  if (!next) {
+    return;
+  }
+  player.currentTime = next.start;
+  player.play();
+};
+
+// Pressing n (or the Next Speech button) jumps to the next speech segment.
+const handleNextSpeech = (e) => {
+  if (e.type === 'click') {
+    if (e.target.closest('#next-speech')) {
+      nextSpeech();
+    }
+    return;
+  }
+  if (e.key !== 'n' || e.ctrlKey || e.metaKey || e.altKey) {
+    return;
+  }
+  if (e.target.closest('input, textarea, select, [contenteditable]')) {
+    return;
+  }
+  e.preventDefault();
+  nextSpeech();
+};
+
+// The waveform above the player, drawn from the peaks served by /sample/{id}/peaks.
+const waveform = { peaks: null, duration: 0 };
+
+// Returns the start times (in seconds) of the transcript cues, and of the cues matching the query the page was opened with (search hits).
+const cueTimes = (player) => {
+  const query = (new URLSearchParams(window.location.search).get('query') || '').toLowerCase();
+  const cues = [];
+  const hits = [];
+  for (const track of player.textTracks) {
+    for (const cue of track.cues || []) {
+      cues.push(cue.startTime);
+      if (query && cue.text.toLowerCase().includes(query)) {
+        hits.push(cue.startTime);
+      }
+    }
+  }
+  return { cues, hits };
+};
+
+// Returns the offsets (in seconds) of the bookmarks listed on the page.
+const bookmarkTimes = () => [...document.querySelectorAll('#bookmark-list a[href^="#t="]')]
+  .map((a) => parseFloat(a.hash.slice(3)));
+
+const drawWaveform = () => {
+  const canvas = document.querySelector('#waveform');
+  const player = document.querySelector('#playback video');
+  if (!canvas || !player || !waveform.peaks) {
+    return;
+  }
+  const dpr = window.devicePixelRatio || 1;
+  canvas.width = canvas.clientWidth * dpr;
+  canvas.height = canvas.clientHeight * dpr;
+  const { width, height } = canvas;
+  const ctx = canvas.getContext('2d');
+  ctx.clearRect(0, 0, width, height);
+  const x = (seconds) => seconds / waveform.duration * width;
+
+  ctx.fillStyle = 'rgba(70, 170, 70, 0.15)';
+  for (const seg of speechSegments()) {
+    ctx.fillRect(x(seg.start), 0, x(seg.end) - x(seg.start), height);
+  }
+
+  // Peaks are scaled to the loudest one, so that quiet recordings are visible too.
+  const loudest = Math.max(1, ...waveform.peaks);
+  const barWidth = width / waveform.peaks.length;
+  ctx.fillStyle = '#888';
+  waveform.peaks.forEach((peak, i) => {
+    const h = Math.max(1, peak / loudest * height);
+    ctx.fillRect(i * barWidth, (height - h) / 2, Math.max(barWidth, 1), h);
+  });
+
+  const { cues, hits } = cueTimes(player);
+  ctx.fillStyle = '#4a4';
+  for (const t of cues) {
+    ctx.fillRect(x(t), height - 4 * dpr, dpr, 4 * dpr);
+  }
+  ctx.fillStyle = 'rgba(255, 200, 0, 0.6)';
+  for (const t of hits) {
+    ctx.fillRect(x(t) - dpr, 0, 3 * dpr, height);
+  }
+  ctx.fillStyle = '#36c';
+  for (const t of bookmarkTimes()) {
+    ctx.beginPath();
+    ctx.moveTo(x(t) - 4 * dpr, 0);
+    ctx.lineTo(x(t) + 4 * dpr, 0);
+    ctx.lineTo(x(t), 6 * dpr);
+    ctx.fill();
+  }
+  ctx.fillStyle = '#c00';
+  ctx.fillRect(x(player.currentTime), 0, dpr, height);
+};
+
+const loadWaveform = async () => {
+  const canvas = document.querySelector('#waveform');
+  const player = document.querySelector('#playback video');
+  if (!canvas || !player) {
+    return;
+  }
+  const resp = await fetch(canvas.dataset.peaksSrc);
+  if (!resp.ok) {
+    canvas.hidden = true;
+    return;
+  }
+  const data = await resp.json();
+  if (data.peaks.length === 0) {
+    canvas.hidden = true;
+    return;
+  }
+  waveform.peaks = data.peaks;
+  waveform.duration = data.duration;
+  canvas.addEventListener('click', (e) => {
+    const rect = canvas.getBoundingClientRect();
+    player.currentTime = (e.clientX - rect.left) / rect.width * waveform.duration;
+    player.play();
+  });
+  player.addEventListener('timeupdate', drawWaveform);
+  for (const track of player.querySelectorAll('track')) {
+    track.addEventListener('load', drawWaveform);
+  }
+  window.addEventListener('resize', drawWaveform);
+  drawWaveform();
+};
+
+window.addEventListener('hashchange', seekToHash);
+document.addEventListener('DOMContentLoaded', seekToHash);
+document.addEventListener('DOMContentLoaded', loadWaveform);
+document.addEventListener('click', handleSetOffset);
+document.addEventListener('keydown', handleBookmarkKey);
+document.addEventListener('click', handleNextSpeech);
+document.addEventListener('keydown', handleNextSpeech);
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"nyiyui.ca/seekback-server/storage"
+)
+
+// tagError writes the response for errors from the tag methods of storage.Storage.
+func tagError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidTag):
+		http.Error(w, err.Error(), 422)
+	case errors.Is(err, storage.ErrSampleNotFound):
+		http.Error(w, err.Error(), 404)
+	default:
+		log.Printf("error setting tags: %s", err)
+		http.Error(w, "error setting tags", 500)
+	}
+}
+
+type sampleTagsPostQuery struct {
+	// Tags is a comma-separated list of tags to add.
+	Tags string `schema:"tags"`
+}
+
+func (s *Server) sampleTagsPost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+	decoder := newDecoder(r)
+	var query sampleTagsPostQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	tags, err := storage.ParseTags(query.Tags)
+	if err != nil {
+		tagError(w, err)
+		return
+	}
+	for _, tag := range tags {
+		err = s.st.SampleTagAdd(r.Context(), id, tag)
+		if err != nil {
+			tagError(w, err)
+			return
+		}
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s", id), 302)
+}
+
+type sampleTagRemovePostQuery struct {
+	Tag string `schema:"tag,required"`
+}
+
+func (s *Server) sampleTagRemovePost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "missing id", 400)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+	decoder := newDecoder(r)
+	var query sampleTagRemovePostQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	err = s.st.SampleTagRemove(r.Context(), id, query.Tag)
+	if err != nil {
+		tagError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/sample/%s", id), 302)
+}
+
+func (s *Server) sampleTagsGet(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.st.SampleTags(r.Context(), r.PathValue("id"))
+	if err != nil {
+		log.Printf("error getting tags: %s", err)
+		http.Error(w, "error getting tags", 500)
+		return
+	}
+	writeJSON(w, 200, tags)
+}
+
+func (s *Server) sampleTagPut(w http.ResponseWriter, r *http.Request) {
+	err := s.st.SampleTagAdd(r.Context(), r.PathValue("id"), r.PathValue("tag"))
+	if err != nil {
+		tagError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) sampleTagDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.st.SampleTagRemove(r.Context(), r.PathValue("id"), r.PathValue("tag"))
+	if err != nil {
+		tagError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
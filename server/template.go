@@ -144,6 +144,8 @@ func (s *Server) parseTemplate(basename string) (*template.Template, error) {
 			"vcsInfo": func() string {
 				return vcsInfo
 			},
+			"formatOffset": formatOffset,
+			"formatSize":   formatSize,
 			"percent": func(f float64) string {
 				return fmt.Sprintf("%.f%%", f*100)
 			},
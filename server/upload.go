@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"nyiyui.ca/seekback-server/storage"
+)
+
+// Uploads work like this:
+//  1. POST /sample/new with the sample ID, media extension and size creates an upload.
+//  2. PATCH /upload/{upload} with the Upload-Offset header appends a chunk of the media.
+//     If a request fails, GET /upload/{upload} (or HEAD) returns the offset to resume from.
+//  3. POST /upload/{upload}/complete with an optional summary, transcript and metadata moves the files into the samples directory.
+
+type sampleNewQuery struct {
+	ID   string `schema:"id,required"`
+	Ext  string `schema:"ext,required"`
+	Size int64  `schema:"size,required"`
+}
+
+type uploadCompleteQuery struct {
+	Summary    string `schema:"summary"`
+	Transcript string `schema:"transcript"`
+	// Metadata is the sidecar JSON (see storage.SampleMetadata).
+	Metadata string `schema:"metadata"`
+}
+
+type uploadCompleteResponse struct {
+	SampleID string   `json:"sample_id"`
+	Files    []string `json:"files"`
+}
+
+// maxUploadFormSize is the maximum size of the form (summary and transcript) of POST /upload/{upload}/complete kept in memory.
+const maxUploadFormSize = 32 << 20
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("error encoding json: %s", err)
+	}
+}
+
+// uploadError writes the response for errors from the upload methods of storage.Storage.
+func uploadError(w http.ResponseWriter, err error, action string) {
+	var terrs storage.TranscriptErrors
+	switch {
+	case errors.Is(err, storage.ErrUploadNotFound):
+		http.Error(w, err.Error(), 404)
+	case errors.Is(err, storage.ErrUploadBusy), errors.Is(err, storage.ErrUploadOffset), errors.Is(err, storage.ErrSampleExists), errors.Is(err, storage.ErrUploadIncomplete):
+		http.Error(w, err.Error(), 409)
+	case errors.Is(err, storage.ErrUploadTooLarge):
+		http.Error(w, err.Error(), 413)
+	case errors.Is(err, storage.ErrInvalidSampleID), errors.Is(err, storage.ErrUnsupportedMedia), errors.Is(err, storage.ErrInvalidMetadata):
+		http.Error(w, err.Error(), 422)
+	case errors.As(err, &terrs):
+		http.Error(w, transcriptErrorsText(terrs), 422)
+	default:
+		log.Printf("error %s: %s", action, err)
+		http.Error(w, fmt.Sprintf("error %s", action), 500)
+	}
+}
+
+func (s *Server) sampleNew(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+	decoder := newDecoder(r)
+	var query sampleNewQuery
+	err = decoder.Decode(&query, r.Form)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	u, err := s.st.UploadCreate(r.Context(), query.ID, query.Ext, query.Size)
+	if err != nil {
+		uploadError(w, err, "creating upload")
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/upload/%s", u.ID))
+	w.Header().Set("Upload-Offset", "0")
+	writeJSON(w, 201, u)
+}
+
+func (s *Server) uploadGet(w http.ResponseWriter, r *http.Request) {
+	u, err := s.st.UploadGet(r.Context(), r.PathValue("upload"))
+	if err != nil {
+		uploadError(w, err, "getting upload")
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	writeJSON(w, 200, u)
+}
+
+// uploadPatch appends the request body to the upload at the offset in the Upload-Offset header.
+// The response's Upload-Offset header has the new offset, also if the request failed.
+func (s *Server) uploadPatch(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset header", 400)
+		return
+	}
+	newOffset, err := s.st.UploadWrite(r.Context(), r.PathValue("upload"), offset, r.Body)
+	if !errors.Is(err, storage.ErrUploadNotFound) && !errors.Is(err, storage.ErrUploadBusy) {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	}
+	if err != nil {
+		uploadError(w, err, "writing upload")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) uploadDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.st.UploadCancel(r.Context(), r.PathValue("upload"))
+	if err != nil {
+		uploadError(w, err, "deleting upload")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) uploadComplete(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(maxUploadFormSize)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, fmt.Sprintf("form parse failed: %s", err), 422)
+		return
+	}
+	decoder := newDecoder(r)
+	decoder.IgnoreUnknownKeys(true)
+	var query uploadCompleteQuery
+	err = decoder.Decode(&query, r.PostForm)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("query data decode failed: %s", err), 422)
+		return
+	}
+	u, err := s.st.UploadGet(r.Context(), r.PathValue("upload"))
+	if err != nil {
+		uploadError(w, err, "getting upload")
+		return
+	}
+	names, err := s.st.UploadComplete(r.Context(), u.ID, query.Summary, query.Transcript, query.Metadata)
+	if err != nil && names == nil {
+		uploadError(w, err, "completing upload")
+		return
+	} else if err != nil {
+		// The files are in place, so the next sync picks them up.
+		log.Printf("error syncing uploaded sample %s: %s", u.SampleID, err)
+	}
+	w.Header().Set("Location", fmt.Sprintf("/sample/%s", u.SampleID))
+	writeJSON(w, 201, uploadCompleteResponse{SampleID: u.SampleID, Files: names})
+}
@@ -2,7 +2,10 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,3 +19,74 @@ func parseFormTime(s string, r *http.Request) (time.Time, error) {
 	now := time.Now()
 	return time.Date(now.Year(), now.Month(), now.Day(), timeRaw.Hour(), timeRaw.Minute(), 0, 0, time.Local), nil
 }
+
+// parseOptionalFloat parses a number form value. It returns nil if the value is empty.
+func parseOptionalFloat(s, name string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", name)
+	}
+	return &f, nil
+}
+
+// parseOffset parses an offset within a sample, in the form [[h:]m:]s[.fff], or as a Go duration (e.g. 1m30s).
+func parseOffset(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("offset %q must be in the form [[h:]m:]s", s)
+	}
+	var seconds float64
+	for i, part := range parts {
+		last := i == len(parts)-1
+		var f float64
+		var err error
+		if last {
+			f, err = strconv.ParseFloat(part, 64)
+		} else {
+			var n int
+			n, err = strconv.Atoi(part)
+			f = float64(n)
+		}
+		if err != nil || f < 0 || (i > 0 && f >= 60) {
+			return 0, fmt.Errorf("offset %q must be in the form [[h:]m:]s", s)
+		}
+		seconds = seconds*60 + f
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// formatOffset formats an offset within a sample as h:mm:ss(.fff) or m:ss(.fff).
+func formatOffset(d time.Duration) string {
+	h := d / time.Hour
+	m := d % time.Hour / time.Minute
+	sec := float64(d%time.Minute) / float64(time.Second)
+	secs := strconv.FormatFloat(sec, 'f', -1, 64)
+	if sec < 10 {
+		secs = "0" + secs
+	}
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%s", h, m, secs)
+	}
+	return fmt.Sprintf("%d:%s", m, secs)
+}
+
+// formatSize formats a size in bytes with a binary prefix (e.g. 1.5 MiB).
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBookmarkNotFound is returned for bookmarks that do not exist.
+var ErrBookmarkNotFound = errors.New("bookmark not found")
+
+// ErrInvalidBookmark is returned for bookmarks outside of their sample.
+var ErrInvalidBookmark = errors.New("invalid bookmark")
+
+// Bookmark is a position in a sample, with an optional note.
+type Bookmark struct {
+	ID       int64  `db:"id" json:"id"`
+	SampleID string `db:"sample_id" json:"sample_id"`
+	// Offset is relative to the start of the sample.
+	Offset time.Duration `db:"offset" json:"offset"`
+	// Note is in Markdown.
+	Note      string    `db:"note" json:"note"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	// SampleStart is the start of the sample. Time is the absolute time of the bookmark.
+	SampleStart time.Time `db:"sample_start" json:"-"`
+	Time        time.Time `db:"-" json:"time"`
+}
+
+func (b *Bookmark) setTime() {
+	b.Time = b.SampleStart.Add(b.Offset)
+}
+
+type BookmarkWithSnippet struct {
+	Bookmark
+	Snippet string `db:"snippet" json:"snippet"`
+}
+
+// validate checks the bookmark against the sample's duration. If the duration is not known (zero), only the sign is checked.
+func (b Bookmark) validate(sampleDuration time.Duration) error {
+	if b.Offset < 0 {
+		return fmt.Errorf("%w: negative offset", ErrInvalidBookmark)
+	}
+	if sampleDuration != 0 && b.Offset > sampleDuration {
+		return fmt.Errorf("%w: after the end of the sample (%s)", ErrInvalidBookmark, sampleDuration)
+	}
+	return nil
+}
+
+// BookmarkCreate creates a bookmark. The ID and creation time are set by this method.
+func (s *Storage) BookmarkCreate(ctx context.Context, b Bookmark) (Bookmark, error) {
+	duration, err := s.sampleDuration(ctx, b.SampleID)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	err = b.validate(duration)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	b.CreatedAt = time.Now()
+	res, err := s.DB.NamedExecContext(ctx, "INSERT INTO bookmarks (sample_id, offset, note, created_at) VALUES (:sample_id, :offset, :note, :created_at)", b)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	b.ID, err = res.LastInsertId()
+	if err != nil {
+		return Bookmark{}, err
+	}
+	return s.BookmarkGet(ctx, b.ID)
+}
+
+// BookmarkUpdate updates the offset and note of a bookmark.
+func (s *Storage) BookmarkUpdate(ctx context.Context, b Bookmark) error {
+	old, err := s.BookmarkGet(ctx, b.ID)
+	if err != nil {
+		return err
+	}
+	duration, err := s.sampleDuration(ctx, old.SampleID)
+	if err != nil {
+		return err
+	}
+	err = b.validate(duration)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.NamedExecContext(ctx, "UPDATE bookmarks SET offset=:offset, note=:note WHERE id=:id", b)
+	return err
+}
+
+// BookmarkDelete deletes a bookmark.
+func (s *Storage) BookmarkDelete(ctx context.Context, id int64) error {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM bookmarks WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBookmarkNotFound
+	}
+	return nil
+}
+
+// bookmarkSelect selects bookmarks with the start of their samples.
+const bookmarkSelect = "SELECT bookmarks.*, samples.start AS sample_start FROM bookmarks JOIN samples ON samples.id = bookmarks.sample_id "
+
+// BookmarkGet returns a bookmark.
+func (s *Storage) BookmarkGet(ctx context.Context, id int64) (Bookmark, error) {
+	var b Bookmark
+	err := s.DB.GetContext(ctx, &b, bookmarkSelect+"WHERE bookmarks.id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Bookmark{}, ErrBookmarkNotFound
+	} else if err != nil {
+		return Bookmark{}, err
+	}
+	b.setTime()
+	return b, nil
+}
+
+// SampleBookmarks returns the bookmarks of a sample, in order of their offsets.
+func (s *Storage) SampleBookmarks(ctx context.Context, sampleID string) ([]Bookmark, error) {
+	bookmarks := make([]Bookmark, 0)
+	err := s.DB.SelectContext(ctx, &bookmarks, bookmarkSelect+"WHERE bookmarks.sample_id=? ORDER BY bookmarks.offset, bookmarks.id", sampleID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bookmarks {
+		bookmarks[i].setTime()
+	}
+	return bookmarks, nil
+}
+
+// SearchBookmarks returns the bookmarks whose notes match so.Query, or all bookmarks if so.Query is empty.
+// The other options apply as in SearchClips, with the bookmark's time as both the start and the end.
+func (s *Storage) SearchBookmarks(so SearchOptions, ctx context.Context) ([]BookmarkWithSnippet, error) {
+	var query string
+	var args []interface{}
+	if so.Query == "" {
+		query = "SELECT bookmarks.*, samples.start AS sample_start, '' AS snippet FROM bookmarks JOIN samples ON samples.id = bookmarks.sample_id "
+	} else {
+		query = `
+SELECT bookmarks.*, samples.start AS sample_start, m.snippet AS snippet FROM bookmarks JOIN samples ON samples.id = bookmarks.sample_id JOIN (
+  SELECT rowid, snippet(bookmarks_fts, -1, '**', '**', '…', 64) AS snippet FROM bookmarks_fts WHERE bookmarks_fts MATCH ?
+) AS m ON m.rowid = bookmarks.id
+`
+		args = append(args, so.Query)
+	}
+	t := "(unixepoch(samples.start) + bookmarks.offset / 1000000000)"
+	where, whereArgs, err := so.filter(t, t, "bookmarks.sample_id")
+	if err != nil {
+		return nil, err
+	}
+	query += "WHERE samples.deleted_at IS NULL " + where + "ORDER BY unixepoch(samples.start), bookmarks.offset"
+	args = append(args, whereArgs...)
+
+	bookmarks := make([]BookmarkWithSnippet, 0)
+	err = s.DB.SelectContext(ctx, &bookmarks, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bookmarks {
+		bookmarks[i].setTime()
+	}
+	return bookmarks, nil
+}
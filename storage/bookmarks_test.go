@@ -0,0 +1,125 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBookmarkCreate(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+09:00"
+	syncSamples(t, s, id)
+	cases := []struct {
+		name   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"ok", time.Minute, true},
+		{"start", 0, true},
+		{"end", time.Hour, true},
+		{"negative", -time.Second, false},
+		{"after sample", 2 * time.Hour, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b, err := s.BookmarkCreate(ctx, Bookmark{SampleID: id, Offset: c.offset, Note: c.name})
+			if !c.ok {
+				if !errors.Is(err, ErrInvalidBookmark) {
+					t.Fatalf("expected ErrInvalidBookmark, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Date(2024, 1, 2, 10, 0, 0, 0, time.FixedZone("", 9*60*60))
+			if b.ID == 0 || b.Note != c.name || !b.Time.Equal(start.Add(c.offset)) {
+				t.Fatalf("unexpected bookmark %+v", b)
+			}
+		})
+	}
+	_, err := s.BookmarkCreate(ctx, Bookmark{SampleID: "2024-01-03T10:00:00+00:00"})
+	if !errors.Is(err, ErrSampleNotFound) {
+		t.Fatalf("expected ErrSampleNotFound, got %v", err)
+	}
+	if n := countRows(t, s, "bookmarks"); n != 3 {
+		t.Fatalf("expected 3 bookmarks, got %d", n)
+	}
+
+	// the duration is not known before the sample is probed
+	_, err = s.DB.ExecContext(ctx, "UPDATE samples SET duration=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.BookmarkCreate(ctx, Bookmark{SampleID: id, Offset: 2 * time.Hour})
+	if err != nil {
+		t.Fatalf("expected no error for an unknown duration, got %s", err)
+	}
+}
+
+func TestSearchBookmarks(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	// tokyo starts before utc, but its stored time sorts after utc's
+	tokyo := "2024-01-02T10:00:00+09:00"
+	utc := "2024-01-02T05:00:00+00:00"
+	syncSamples(t, s, tokyo, utc)
+	err := s.SampleTagAdd(ctx, tokyo, "trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []Bookmark{
+		{SampleID: utc, Offset: 10 * time.Minute, Note: "gamma"},
+		{SampleID: utc, Offset: 0, Note: "beta"},
+		{SampleID: tokyo, Offset: time.Minute, Note: "alpha, the first one"},
+	} {
+		_, err = s.BookmarkCreate(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	utcStart := time.Date(2024, 1, 2, 5, 0, 0, 0, time.UTC)
+	after := utcStart.Add(5 * time.Minute)
+	cases := []struct {
+		name  string
+		so    SearchOptions
+		notes []string
+	}{
+		{"all", SearchOptions{}, []string{"alpha, the first one", "beta", "gamma"}},
+		{"tag", SearchOptions{Tags: []string{"trip"}}, []string{"alpha, the first one"}},
+		{"start after", SearchOptions{StartAfter: &after}, []string{"gamma"}},
+		{"end before", SearchOptions{EndBefore: &after}, []string{"alpha, the first one", "beta"}},
+		{"query", SearchOptions{Query: "first"}, []string{"alpha, the first one"}},
+		{"query and tag", SearchOptions{Query: "gamma", Tags: []string{"trip"}}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bookmarks, err := s.SearchBookmarks(c.so, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notes := make([]string, len(bookmarks))
+			for i, b := range bookmarks {
+				notes[i] = b.Note
+			}
+			if !slices.Equal(notes, c.notes) {
+				t.Fatalf("expected %v, got %v", c.notes, notes)
+			}
+		})
+	}
+
+	bookmarks, err := s.SearchBookmarks(SearchOptions{Query: "gamma"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 1 || bookmarks[0].Snippet != "**gamma**" || !bookmarks[0].Time.Equal(utcStart.Add(10*time.Minute)) {
+		t.Fatalf("expected gamma with its snippet and time, got %+v", bookmarks)
+	}
+}
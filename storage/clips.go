@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrClipNotFound is returned for clips that do not exist.
+var ErrClipNotFound = errors.New("clip not found")
+
+// ErrInvalidClip is returned for clips with an empty title or a range outside of the sample.
+var ErrInvalidClip = errors.New("invalid clip")
+
+// Clip is a named range within a sample.
+type Clip struct {
+	ID       int64  `db:"id" json:"id"`
+	SampleID string `db:"sample_id" json:"sample_id"`
+	// StartOffset and EndOffset are relative to the start of the sample.
+	StartOffset time.Duration `db:"start_offset" json:"start_offset"`
+	EndOffset   time.Duration `db:"end_offset" json:"end_offset"`
+	Title       string        `db:"title" json:"title"`
+	// Note is in Markdown.
+	Note      string    `db:"note" json:"note"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	// SampleStart is the start of the sample. Start and End are the absolute times of the clip.
+	SampleStart time.Time `db:"sample_start" json:"-"`
+	Start       time.Time `db:"-" json:"start"`
+	End         time.Time `db:"-" json:"end"`
+}
+
+func (c *Clip) setTimes() {
+	c.Start = c.SampleStart.Add(c.StartOffset)
+	c.End = c.SampleStart.Add(c.EndOffset)
+}
+
+// Duration returns the length of the clip.
+func (c Clip) Duration() time.Duration {
+	return c.EndOffset - c.StartOffset
+}
+
+type ClipWithSnippet struct {
+	Clip
+	Snippet string `db:"snippet" json:"snippet"`
+}
+
+// validate checks the clip against the sample's duration. If the duration is not known (zero), only the order of the offsets is checked.
+func (c Clip) validate(sampleDuration time.Duration) error {
+	if strings.TrimSpace(c.Title) == "" {
+		return fmt.Errorf("%w: empty title", ErrInvalidClip)
+	}
+	if c.StartOffset < 0 || c.EndOffset <= c.StartOffset {
+		return fmt.Errorf("%w: the end must be after the start, which must not be negative", ErrInvalidClip)
+	}
+	if sampleDuration != 0 && c.EndOffset > sampleDuration {
+		return fmt.Errorf("%w: ends after the sample (%s)", ErrInvalidClip, sampleDuration)
+	}
+	return nil
+}
+
+// sampleDuration returns the duration of a (non-tombstoned) sample.
+func (s *Storage) sampleDuration(ctx context.Context, id string) (time.Duration, error) {
+	var duration time.Duration
+	err := s.DB.GetContext(ctx, &duration, "SELECT duration FROM samples WHERE id=? AND deleted_at IS NULL", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrSampleNotFound
+	}
+	return duration, err
+}
+
+// ClipCreate creates a clip. The ID and creation time are set by this method.
+func (s *Storage) ClipCreate(ctx context.Context, c Clip) (Clip, error) {
+	duration, err := s.sampleDuration(ctx, c.SampleID)
+	if err != nil {
+		return Clip{}, err
+	}
+	err = c.validate(duration)
+	if err != nil {
+		return Clip{}, err
+	}
+	c.CreatedAt = time.Now()
+	res, err := s.DB.NamedExecContext(ctx, "INSERT INTO clips (sample_id, start_offset, end_offset, title, note, created_at) VALUES (:sample_id, :start_offset, :end_offset, :title, :note, :created_at)", c)
+	if err != nil {
+		return Clip{}, err
+	}
+	c.ID, err = res.LastInsertId()
+	if err != nil {
+		return Clip{}, err
+	}
+	return s.ClipGet(ctx, c.ID)
+}
+
+// ClipUpdate updates the range, title and note of a clip.
+func (s *Storage) ClipUpdate(ctx context.Context, c Clip) error {
+	old, err := s.ClipGet(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	duration, err := s.sampleDuration(ctx, old.SampleID)
+	if err != nil {
+		return err
+	}
+	err = c.validate(duration)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.NamedExecContext(ctx, "UPDATE clips SET start_offset=:start_offset, end_offset=:end_offset, title=:title, note=:note WHERE id=:id", c)
+	return err
+}
+
+// ClipDelete deletes a clip.
+func (s *Storage) ClipDelete(ctx context.Context, id int64) error {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM clips WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrClipNotFound
+	}
+	return nil
+}
+
+// clipSelect selects clips with the start of their samples.
+const clipSelect = "SELECT clips.*, samples.start AS sample_start FROM clips JOIN samples ON samples.id = clips.sample_id "
+
+// ClipGet returns a clip, including clips of tombstoned samples.
+func (s *Storage) ClipGet(ctx context.Context, id int64) (Clip, error) {
+	var c Clip
+	err := s.DB.GetContext(ctx, &c, clipSelect+"WHERE clips.id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Clip{}, ErrClipNotFound
+	} else if err != nil {
+		return Clip{}, err
+	}
+	c.setTimes()
+	return c, nil
+}
+
+// SampleClips returns the clips of a sample, in order of their start.
+func (s *Storage) SampleClips(ctx context.Context, sampleID string) ([]Clip, error) {
+	clips := make([]Clip, 0)
+	err := s.DB.SelectContext(ctx, &clips, clipSelect+"WHERE clips.sample_id=? ORDER BY clips.start_offset, clips.id", sampleID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clips {
+		clips[i].setTimes()
+	}
+	return clips, nil
+}
+
+// SearchClips is like Search, but for clips. The time options apply to the clips' absolute times, and the tag options to their samples' tags.
+// Clips of tombstoned samples are not returned.
+func (s *Storage) SearchClips(so SearchOptions, ctx context.Context) ([]ClipWithSnippet, error) {
+	var query string
+	var args []interface{}
+	if so.Query == "" {
+		query = "SELECT clips.*, samples.start AS sample_start, '' AS snippet FROM clips JOIN samples ON samples.id = clips.sample_id "
+	} else {
+		query = `
+SELECT clips.*, samples.start AS sample_start, m.snippet AS snippet FROM clips JOIN samples ON samples.id = clips.sample_id JOIN (
+  SELECT rowid, snippet(clips_fts, -1, '**', '**', '…', 64) AS snippet FROM clips_fts WHERE clips_fts MATCH ?
+) AS m ON m.rowid = clips.id
+`
+		args = append(args, so.Query)
+	}
+	start := "(unixepoch(samples.start) + clips.start_offset / 1000000000)"
+	end := "(unixepoch(samples.start) + clips.end_offset / 1000000000)"
+	where, whereArgs, err := so.filter(start, end, "clips.sample_id")
+	if err != nil {
+		return nil, err
+	}
+	query += "WHERE samples.deleted_at IS NULL " + where + "ORDER BY unixepoch(samples.start), clips.start_offset"
+	args = append(args, whereArgs...)
+
+	clips := make([]ClipWithSnippet, 0)
+	err = s.DB.SelectContext(ctx, &clips, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range clips {
+		clips[i].setTimes()
+	}
+	return clips, nil
+}
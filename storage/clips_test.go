@@ -0,0 +1,124 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// syncSamples writes media for the samples with the given IDs, and syncs them.
+// Each sample is an hour long, as if probed.
+func syncSamples(t *testing.T, s *Storage, ids ...string) {
+	t.Helper()
+	files := make(map[string]string, len(ids))
+	for _, id := range ids {
+		files[id+".opus"] = "x"
+	}
+	writeSampleFiles(t, s, files)
+	ctx := context.Background()
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.DB.ExecContext(ctx, "UPDATE samples SET duration=?", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClipCreateInvalid(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	syncSamples(t, s, id)
+	cases := []struct {
+		name string
+		clip Clip
+	}{
+		{"empty title", Clip{Title: " ", StartOffset: time.Minute, EndOffset: 2 * time.Minute}},
+		{"negative start", Clip{Title: "a", StartOffset: -time.Second, EndOffset: 2 * time.Minute}},
+		{"empty range", Clip{Title: "a", StartOffset: time.Minute, EndOffset: time.Minute}},
+		{"after sample", Clip{Title: "a", StartOffset: time.Minute, EndOffset: 2 * time.Hour}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.clip.SampleID = id
+			_, err := s.ClipCreate(ctx, c.clip)
+			if !errors.Is(err, ErrInvalidClip) {
+				t.Fatalf("expected ErrInvalidClip, got %v", err)
+			}
+		})
+	}
+	_, err := s.ClipCreate(ctx, Clip{SampleID: "2024-01-03T10:00:00+00:00", Title: "a", EndOffset: time.Minute})
+	if !errors.Is(err, ErrSampleNotFound) {
+		t.Fatalf("expected ErrSampleNotFound, got %v", err)
+	}
+	if n := countRows(t, s, "clips"); n != 0 {
+		t.Fatalf("expected no clips, got %d", n)
+	}
+}
+
+func TestSearchClips(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	// tokyo starts before utc, but its stored time sorts after utc's
+	tokyo := "2024-01-02T10:00:00+09:00"
+	utc := "2024-01-02T05:00:00+00:00"
+	syncSamples(t, s, tokyo, utc)
+	err := s.SampleTagAdd(ctx, tokyo, "trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Clip{
+		{SampleID: utc, Title: "gamma", StartOffset: 10 * time.Minute, EndOffset: 11 * time.Minute},
+		{SampleID: utc, Title: "beta", StartOffset: 0, EndOffset: time.Minute},
+		{SampleID: tokyo, Title: "alpha", StartOffset: time.Minute, EndOffset: 2 * time.Minute, Note: "the first one"},
+	} {
+		_, err = s.ClipCreate(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	utcStart := time.Date(2024, 1, 2, 5, 0, 0, 0, time.UTC)
+	after := utcStart.Add(5 * time.Minute)
+	cases := []struct {
+		name   string
+		so     SearchOptions
+		titles []string
+	}{
+		{"all", SearchOptions{}, []string{"alpha", "beta", "gamma"}},
+		{"tag", SearchOptions{Tags: []string{"trip"}}, []string{"alpha"}},
+		{"start after", SearchOptions{StartAfter: &after}, []string{"gamma"}},
+		{"end before", SearchOptions{EndBefore: &after}, []string{"alpha", "beta"}},
+		{"query", SearchOptions{Query: "first"}, []string{"alpha"}},
+		{"query and tag", SearchOptions{Query: "gamma", Tags: []string{"trip"}}, []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clips, err := s.SearchClips(c.so, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			titles := make([]string, len(clips))
+			for i, clip := range clips {
+				titles[i] = clip.Title
+			}
+			if !slices.Equal(titles, c.titles) {
+				t.Fatalf("expected %v, got %v", c.titles, titles)
+			}
+		})
+	}
+
+	clips, err := s.SearchClips(SearchOptions{Query: "gamma"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clips) != 1 || clips[0].Snippet != "**gamma**" || !clips[0].Start.Equal(utcStart.Add(10*time.Minute)) {
+		t.Fatalf("expected gamma with its snippet and time, got %+v", clips)
+	}
+}
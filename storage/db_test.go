@@ -0,0 +1,57 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"nyiyui.ca/seekback-server/database"
+)
+
+// newTestStorage returns a Storage with an empty samples directory and a migrated database.
+// Tests using it need the fts5 build tag, like the server.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = database.Migrate(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(t.TempDir(), db)
+	s.FallbackProber = nil
+	return s
+}
+
+// writeSampleFiles writes files (by name relative to the samples directory) and their directories.
+func writeSampleFiles(t *testing.T, s *Storage, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		p := s.samplePath(name)
+		err := os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(p, []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// ledgerRecord returns the ledger record of name, and whether it exists.
+func ledgerRecord(t *testing.T, s *Storage, name string) (fileRecord, bool) {
+	t.Helper()
+	ledger, err := s.loadLedger(context.Background(), []string{name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := ledger[name]
+	return r, ok
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilenameParser parses the start time of a sample from its ID (the file name without the extension).
+type FilenameParser interface {
+	ParseStart(id string) (time.Time, bool)
+}
+
+// DefaultFilenameParsers accepts IDs like 2006-01-02T15:04:05-07:00.
+var DefaultFilenameParsers = []FilenameParser{
+	LayoutParser{Layout: "2006-01-02T15:04:05-07:00"},
+}
+
+// LayoutParser parses IDs using a time.Parse layout.
+// If the layout has no time zone, Location is used (UTC if nil).
+type LayoutParser struct {
+	Layout   string
+	Location *time.Location
+}
+
+func (p LayoutParser) ParseStart(id string) (time.Time, bool) {
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(p.Layout, id, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// RegexParser parses IDs using a regular expression with named capture groups.
+// The whole ID must match, so Pattern must be anchored (NewRegexParser anchors it).
+//
+// Either the group unix (seconds since the Unix epoch, optionally with a fractional part) must be present,
+// or the groups year, month, day, and optionally hour, minute, second.
+// The time is in Location (UTC if nil).
+type RegexParser struct {
+	Pattern  *regexp.Regexp
+	Location *time.Location
+}
+
+// NewRegexParser returns a RegexParser for pattern, anchored to match the whole ID.
+func NewRegexParser(pattern string, loc *time.Location) (RegexParser, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return RegexParser{}, err
+	}
+	return RegexParser{Pattern: re, Location: loc}, nil
+}
+
+func (p RegexParser) ParseStart(id string) (time.Time, bool) {
+	loc := p.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	m := p.Pattern.FindStringSubmatch(id)
+	if m == nil {
+		return time.Time{}, false
+	}
+	groups := map[string]string{}
+	for i, name := range p.Pattern.SubexpNames() {
+		if name != "" && m[i] != "" {
+			groups[name] = m[i]
+		}
+	}
+	if unix, ok := groups["unix"]; ok {
+		t, ok := parseUnix(unix)
+		if !ok {
+			return time.Time{}, false
+		}
+		return t.In(loc), true
+	}
+	var fields [6]int
+	for i, name := range []string{"year", "month", "day", "hour", "minute", "second"} {
+		v, ok := groups[name]
+		if !ok {
+			if i < 3 {
+				return time.Time{}, false
+			}
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		fields[i] = n
+	}
+	// Times are checked here rather than after time.Date, which also moves times in DST gaps.
+	if fields[3] < 0 || fields[3] > 23 || fields[4] < 0 || fields[4] > 59 || fields[5] < 0 || fields[5] > 59 {
+		return time.Time{}, false
+	}
+	t := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, loc)
+	if t.Month() != time.Month(fields[1]) || t.Day() != fields[2] {
+		// out of range (e.g. February 30th)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseUnix parses seconds since the Unix epoch, optionally with a fractional part (up to nanoseconds).
+// The seconds are parsed as an integer, so that they are exact.
+func parseUnix(s string) (time.Time, bool) {
+	secText, fracText, hasFrac := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secText, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if hasFrac {
+		if fracText == "" || strings.TrimLeft(fracText, "0123456789") != "" {
+			return time.Time{}, false
+		}
+		// digits beyond nanoseconds are dropped
+		fracText = (fracText + "000000000")[:9]
+		nsec, err = strconv.ParseInt(fracText, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if strings.HasPrefix(secText, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(sec, nsec), true
+}
+
+// FilenameParserConfig is the JSON representation of a FilenameParser.
+// Exactly one of Layout and Regex must be set.
+type FilenameParserConfig struct {
+	Layout string `json:"layout"`
+	Regex  string `json:"regex"`
+	// Timezone is an IANA time zone name used when the ID has no time zone.
+	Timezone string `json:"timezone"`
+}
+
+func (c FilenameParserConfig) Parser() (FilenameParser, error) {
+	loc := time.UTC
+	if c.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case c.Layout != "" && c.Regex != "":
+		return nil, errors.New("only one of layout and regex can be set")
+	case c.Layout != "":
+		return LayoutParser{Layout: c.Layout, Location: loc}, nil
+	case c.Regex != "":
+		return NewRegexParser(c.Regex, loc)
+	default:
+		return nil, errors.New("one of layout and regex must be set")
+	}
+}
+
+// ParseFilenameParsers parses a JSON array of FilenameParserConfig.
+func ParseFilenameParsers(data []byte) ([]FilenameParser, error) {
+	var configs []FilenameParserConfig
+	err := json.Unmarshal(data, &configs)
+	if err != nil {
+		return nil, err
+	}
+	parsers := make([]FilenameParser, 0, len(configs))
+	for i, c := range configs {
+		p, err := c.Parser()
+		if err != nil {
+			return nil, fmt.Errorf("parser %d: %w", i, err)
+		}
+		parsers = append(parsers, p)
+	}
+	return parsers, nil
+}
+
+// parseStart returns the start time of the sample with the given ID, using the first parser that accepts it.
+func (s *Storage) parseStart(id string) (time.Time, bool) {
+	for _, p := range s.FilenameParsers {
+		if t, ok := p.ParseStart(id); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
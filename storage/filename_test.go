@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFilenameParsers(t *testing.T) {
+	parsers, err := ParseFilenameParsers([]byte(`[
+  {"layout": "2006-01-02T15:04:05Z07:00"},
+  {"regex": "REC_(?P<year>\\d{4})(?P<month>\\d{2})(?P<day>\\d{2})_(?P<hour>\\d{2})(?P<minute>\\d{2})(?P<second>\\d{2})", "timezone": "Asia/Tokyo"},
+  {"regex": "(?P<unix>\\d{9,10}(\\.\\d+)?)"},
+  {"regex": "day(?P<year>\\d{4})(?P<month>\\d{2})(?P<day>\\d{2})(_\\d+?)?"}
+]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Storage{FilenameParsers: parsers}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		id       string
+		expected time.Time
+		ok       bool
+	}{
+		{"2024-01-02T15:30:00+09:00", time.Date(2024, 1, 2, 6, 30, 0, 0, time.UTC), true},
+		{"2024-01-02T15:30:00Z", time.Date(2024, 1, 2, 15, 30, 0, 0, time.UTC), true},
+		{"REC_20240102_153000", time.Date(2024, 1, 2, 15, 30, 0, 0, tokyo), true},
+		{"REC_20240230_153000", time.Time{}, false},
+		{"xREC_20240102_153000", time.Time{}, false},
+		{"REC_20240102_253000", time.Time{}, false},
+		{"REC_20240102_156100", time.Time{}, false},
+		{"REC_20240102_153060", time.Time{}, false},
+		{"REC_20240102_235959", time.Date(2024, 1, 2, 23, 59, 59, 0, tokyo), true},
+		{"1704209400", time.Date(2024, 1, 2, 15, 30, 0, 0, time.UTC), true},
+		{"1704209400.5", time.Date(2024, 1, 2, 15, 30, 0, 5e8, time.UTC), true},
+		{"1704209400.123456789", time.Date(2024, 1, 2, 15, 30, 0, 123456789, time.UTC), true},
+		{"1704209400.", time.Time{}, false},
+		{"day20240102_12", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), true},
+		{"day20240102_12x", time.Time{}, false},
+		{"notes", time.Time{}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.id, func(t *testing.T) {
+			got, ok := s.parseStart(c.id)
+			if ok != c.ok {
+				t.Fatalf("expected ok=%t, got ok=%t", c.ok, ok)
+			}
+			if ok && !got.Equal(c.expected) {
+				t.Fatalf("expected %s, got %s", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestFilenameParserConfigInvalid(t *testing.T) {
+	cases := []string{
+		`[{}]`,
+		`[{"layout": "2006", "regex": "x"}]`,
+		`[{"regex": "("}]`,
+		`[{"layout": "2006", "timezone": "Nowhere/Nothing"}]`,
+	}
+	for _, c := range cases {
+		_, err := ParseFilenameParsers([]byte(c))
+		if err == nil {
+			t.Fatalf("%s: expected error", c)
+		}
+	}
+}
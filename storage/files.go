@@ -1,19 +1,22 @@
 package storage
 
-import (
-	"fmt"
-	"math"
-	"os/exec"
-	"strconv"
-	"strings"
-	"time"
-)
-
+// MediaFileTypes maps media file extensions (without the dot) to MIME types.
 var MediaFileTypes = map[string]string{
 	"aiff": "audio/aiff",
+	"flac": "audio/flac",
+	"m4a":  "audio/mp4",
 	"mp3":  "audio/mpeg",
+	"ogg":  "audio/ogg",
+	"opus": "audio/ogg; codecs=opus",
+	"wav":  "audio/wav",
+	"webm": "audio/webm",
 }
 
+// MediaPreference lists media file extensions from most to least preferred.
+// When a sample has several media files (encodings), they are listed in this order, so that browsers pick the first one they can play.
+// Compact encodings come first.
+var MediaPreference = []string{"opus", "webm", "m4a", "ogg", "mp3", "flac", "wav", "aiff"}
+
 var AllowedFileTypes []string
 
 func init() {
@@ -22,23 +25,3 @@ func init() {
 	}
 	AllowedFileTypes = append(AllowedFileTypes, "vtt")
 }
-
-// getMediaDuration shells out to ffprobe to get the duration of the file at path.
-// "file:" is prepended to the path, and passed to ffprobe like -i file:path.
-func getMediaDuration(path string) (time.Duration, error) {
-	// command from <https://stackoverflow.com/a/22243834>
-	output, err := exec.Command("ffprobe",
-		"-i", fmt.Sprintf("file:%s", path),
-		"-show_entries", "format=duration",
-		"-v", "quiet",
-		"-of", "csv=p=0",
-	).Output()
-	if err != nil {
-		return 0, fmt.Errorf("cmd: %w", err)
-	}
-	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
-	if err != nil {
-		return 0, err
-	}
-	return time.Duration(math.Round(seconds)) * time.Second, nil
-}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"slices"
+	"sync"
+)
+
+// sampleIndex is an in-memory index of the sample files (and transcript originals) in the samples directory, grouped by sample ID and extension.
+// It is built from a single directory scan and kept current by syncFiles.
+// If a sample has several files with the same extension (in different directories), only one of them is indexed.
+type sampleIndex struct {
+	mu    sync.RWMutex
+	built bool
+	// files maps sample IDs to extensions (without the dot; see sampleFileExt) to file names.
+	files map[string]map[string]string
+	// unparseable is the set of names of sample files whose IDs no FilenameParser accepts.
+	unparseable map[string]struct{}
+}
+
+func newSampleIndex() *sampleIndex {
+	return &sampleIndex{files: map[string]map[string]string{}, unparseable: map[string]struct{}{}}
+}
+
+// reset replaces the index with the given records.
+// It returns the unparseable names that were not known before.
+func (si *sampleIndex) reset(records []fileRecord, unparseable []string) (newUnparseable []string) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.files = make(map[string]map[string]string, len(records))
+	for _, r := range records {
+		si.add(r)
+	}
+	old := si.unparseable
+	si.unparseable = make(map[string]struct{}, len(unparseable))
+	for _, name := range unparseable {
+		if _, ok := old[name]; !ok {
+			newUnparseable = append(newUnparseable, name)
+		}
+		si.unparseable[name] = struct{}{}
+	}
+	si.built = true
+	return newUnparseable
+}
+
+// update adds the given records, and removes the given names that are not in records.
+// It returns the unparseable names that were not known before.
+func (si *sampleIndex) update(names []string, records []fileRecord, unparseable []string) (newUnparseable []string) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	old := si.unparseable
+	si.unparseable = make(map[string]struct{}, len(old))
+	for name := range old {
+		if !slices.Contains(names, name) {
+			si.unparseable[name] = struct{}{}
+		}
+	}
+	for _, name := range unparseable {
+		if _, ok := old[name]; !ok {
+			newUnparseable = append(newUnparseable, name)
+		}
+		si.unparseable[name] = struct{}{}
+	}
+	for _, name := range names {
+		ext := sampleFileExt(name)
+		if len(ext) == 0 {
+			continue
+		}
+		id := sampleIDFromName(name)
+		exts, ok := si.files[id]
+		if !ok || exts[ext] != name {
+			continue
+		}
+		delete(exts, ext)
+		if len(exts) == 0 {
+			delete(si.files, id)
+		}
+	}
+	for _, r := range records {
+		si.add(r)
+	}
+	return newUnparseable
+}
+
+// add must be called with mu held.
+func (si *sampleIndex) add(r fileRecord) {
+	exts, ok := si.files[r.SampleID]
+	if !ok {
+		exts = map[string]string{}
+		si.files[r.SampleID] = exts
+	}
+	exts[sampleFileExt(r.Name)] = r.Name
+}
+
+// get returns the file name of the sample with the given extension.
+func (si *sampleIndex) get(id, ext string) (string, bool) {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	name, ok := si.files[id][ext]
+	return name, ok
+}
+
+// names returns the sorted file names of the sample.
+func (si *sampleIndex) names(id string) []string {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	names := make([]string, 0, len(si.files[id]))
+	for _, name := range si.files[id] {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// media returns the media file names of the sample, in the order of MediaPreference.
+func (si *sampleIndex) media(id string) []string {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	names := make([]string, 0)
+	for _, ext := range MediaPreference {
+		if name, ok := si.files[id][ext]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ids returns the IDs of samples that have media.
+func (si *sampleIndex) ids() []string {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	ids := make([]string, 0, len(si.files))
+	for id, exts := range si.files {
+		for ext := range exts {
+			if _, ok := MediaFileTypes[ext]; ok {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// ensureIndex builds the index if it has not been built yet.
+func (s *Storage) ensureIndex() error {
+	s.index.mu.RLock()
+	built := s.index.built
+	s.index.mu.RUnlock()
+	if built {
+		return nil
+	}
+	records, unparseable, err := s.scanSamplesDir()
+	if err != nil {
+		return err
+	}
+	s.index.reset(records, unparseable)
+	return nil
+}
+
+// UnparseableFiles returns the sample files (relative to the samples directory) whose names no FilenameParser accepts.
+// These files are not synced.
+func (s *Storage) UnparseableFiles() ([]string, error) {
+	err := s.ensureIndex()
+	if err != nil {
+		return nil, err
+	}
+	s.index.mu.RLock()
+	defer s.index.mu.RUnlock()
+	names := make([]string, 0, len(s.index.unparseable))
+	for name := range s.index.unparseable {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
@@ -0,0 +1,109 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSyncFileNamesIndex(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	media := "2024/01/02/" + id + ".opus"
+	summary := "2024/01/02/" + id + "." + SummaryExt
+	// builds the index while the directory is empty
+	err := s.SyncFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		write   map[string]string
+		remove  []string
+		synced  []string
+		files   []string
+		isMedia bool
+	}{
+		{"add media", map[string]string{media: "x"}, nil, []string{media}, []string{media}, true},
+		{"add summary", map[string]string{summary: "summary"}, nil, []string{summary}, []string{media, summary}, true},
+		{"remove media", nil, []string{media}, []string{media}, []string{summary}, false},
+		{"remove summary", nil, []string{summary}, []string{summary}, []string{}, false},
+	}
+	for _, step := range steps {
+		writeSampleFiles(t, s, step.write)
+		for _, name := range step.remove {
+			err = os.Remove(s.samplePath(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = s.SyncFileNames(ctx, step.synced)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := s.SampleFiles(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(files, step.files) {
+			t.Fatalf("%s: expected files %v, got %v", step.name, step.files, files)
+		}
+		if isMedia := slices.Contains(s.index.ids(), id); isMedia != step.isMedia {
+			t.Fatalf("%s: expected the sample to have media: %t", step.name, step.isMedia)
+		}
+	}
+}
+
+func TestTranscriptOriginalIndex(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{"2024/" + id + ".opus": "x"})
+	err := s.SyncFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		format   TranscriptFormat
+		data     string
+		original string
+	}{
+		{TranscriptSRT, "1\n00:00:01,000 --> 00:00:02,000\nhi\n", "2024/" + id + ".srt"},
+		{TranscriptWhisper, `{"segments": [{"start": 1, "end": 2, "text": "hi"}]}`, "2024/" + id + ".whisper.json"},
+		{TranscriptVTT, "WEBVTT\n\n00:01.000 --> 00:02.000\nhi\n", ""},
+	}
+	for _, step := range steps {
+		_, err = s.SampleTranscriptImport(ctx, id, step.format, []byte(step.data), "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, _ := s.SampleTranscriptOriginal(id)
+		if original != step.original {
+			t.Fatalf("%s: expected original %q, got %q", step.format, step.original, original)
+		}
+	}
+
+	// Originals placed in the samples directory are found by a scan, but are not synced.
+	name := "2024/" + id + ".whisper.json"
+	writeSampleFiles(t, s, map[string]string{name: `{"segments": []}`})
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 0 || len(report.Unparseable) != 0 {
+		t.Fatalf("expected nothing to be synced, got %+v", report)
+	}
+	if _, ok := ledgerRecord(t, s, name); ok {
+		t.Fatalf("expected %s not to be in the ledger", name)
+	}
+	original, _ := s.SampleTranscriptOriginal(id)
+	if original != name {
+		t.Fatalf("expected original %q, got %q", name, original)
+	}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSampleIndex(t *testing.T) {
+	a := "2024-01-02T10:00:00+00:00"
+	b := "2024-01-02T11:00:00+00:00"
+	si := newSampleIndex()
+	newUnparseable := si.reset([]fileRecord{
+		{Name: "2024/01/02/" + a + ".wav", SampleID: a},
+		{Name: "2024/01/02/" + a + ".opus", SampleID: a},
+		{Name: "2024/01/02/" + a + ".txt", SampleID: a},
+		{Name: b + ".vtt", SampleID: b},
+	}, []string{"notes.txt"})
+	if !slices.Equal(newUnparseable, []string{"notes.txt"}) {
+		t.Fatalf("expected notes.txt to be new, got %v", newUnparseable)
+	}
+
+	name, ok := si.get(a, "txt")
+	if !ok || name != "2024/01/02/"+a+".txt" {
+		t.Fatalf("get: expected the summary, got %q, %t", name, ok)
+	}
+	if _, ok := si.get(a, "vtt"); ok {
+		t.Fatal("get: expected no transcript")
+	}
+	if media := si.media(a); !slices.Equal(media, []string{"2024/01/02/" + a + ".opus", "2024/01/02/" + a + ".wav"}) {
+		t.Fatalf("media: expected opus before wav, got %v", media)
+	}
+	if media := si.media(b); len(media) != 0 {
+		t.Fatalf("media: expected none, got %v", media)
+	}
+	if names := si.names(a); !slices.Equal(names, []string{"2024/01/02/" + a + ".opus", "2024/01/02/" + a + ".txt", "2024/01/02/" + a + ".wav"}) {
+		t.Fatalf("names: expected the sorted names, got %v", names)
+	}
+	// b has no media
+	if ids := si.ids(); !slices.Equal(ids, []string{a}) {
+		t.Fatalf("ids: expected only %s, got %v", a, ids)
+	}
+
+	newUnparseable = si.update(
+		[]string{"2024/01/02/" + a + ".opus", "2024/01/02/" + a + ".txt", b + ".opus", "notes.txt", "more-notes.txt"},
+		[]fileRecord{{Name: b + ".opus", SampleID: b}},
+		[]string{"more-notes.txt"},
+	)
+	if !slices.Equal(newUnparseable, []string{"more-notes.txt"}) {
+		t.Fatalf("expected more-notes.txt to be new, got %v", newUnparseable)
+	}
+	if media := si.media(a); !slices.Equal(media, []string{"2024/01/02/" + a + ".wav"}) {
+		t.Fatalf("update: expected only the wav to remain, got %v", media)
+	}
+	if _, ok := si.get(a, "txt"); ok {
+		t.Fatal("update: expected the summary to be removed")
+	}
+	ids := si.ids()
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{a, b}) {
+		t.Fatalf("update: expected both samples, got %v", ids)
+	}
+	if !slices.Equal(si.names(b), []string{b + ".opus", b + ".vtt"}) {
+		t.Fatalf("update: expected the transcript to be kept, got %v", si.names(b))
+	}
+
+	si.update([]string{"2024/01/02/" + a + ".wav"}, nil, nil)
+	if names := si.names(a); len(names) != 0 {
+		t.Fatalf("update: expected no files, got %v", names)
+	}
+	if ids := si.ids(); !slices.Equal(ids, []string{b}) {
+		t.Fatalf("update: expected only %s, got %v", b, ids)
+	}
+}
+
+func TestSampleIndexUpdateOtherDirectory(t *testing.T) {
+	id := "2024-01-02T10:00:00+00:00"
+	si := newSampleIndex()
+	si.reset([]fileRecord{{Name: "a/" + id + ".opus", SampleID: id}}, nil)
+	// removing a file that is not the indexed one keeps the indexed one
+	si.update([]string{"b/" + id + ".opus"}, nil, nil)
+	if media := si.media(id); !slices.Equal(media, []string{"a/" + id + ".opus"}) {
+		t.Fatalf("expected the indexed file to be kept, got %v", media)
+	}
+}
@@ -0,0 +1,381 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Jobs are slow or fallible work (e.g. probing media) that is queued in the jobs table and run in the background by RunJobs.
+// Only one job of each kind and payload waits at a time; enqueueing it again while it waits does nothing.
+// Finished jobs are removed from the table.
+const (
+	// JobProbe probes the media duration of a sample, and computes its peaks and speech segments.
+	JobProbe = "probe"
+	// JobRebuildFTS rebuilds the full text search indexes.
+	// It is queued by the first full sync of a process if an index drifted from its table (see checkFTS).
+	JobRebuildFTS = "rebuild_fts"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	// JobFailed means the job failed jobMaxAttempts times. It is not run again until JobRetry is called.
+	JobFailed = "failed"
+)
+
+const (
+	jobMaxAttempts = 5
+	// jobBackoff is the wait before the second attempt; it doubles after each attempt, up to jobMaxBackoff.
+	jobBackoff    = 30 * time.Second
+	jobMaxBackoff = time.Hour
+	// jobLease is how long a worker has a job before other workers may take it over (e.g. if the server was killed).
+	// The lease is renewed while the job runs.
+	jobLease        = 5 * time.Minute
+	jobPollInterval = 10 * time.Second
+)
+
+// jobKinds are the functions that run jobs, by kind. payload is the JSON given to enqueueJob.
+var jobKinds = map[string]func(s *Storage, ctx context.Context, payload []byte) error{
+	JobProbe:      (*Storage).runProbeJob,
+	JobRebuildFTS: (*Storage).runRebuildFTSJob,
+}
+
+// Job is a row in the jobs table.
+type Job struct {
+	ID       int64  `db:"id"`
+	Kind     string `db:"kind"`
+	Payload  string `db:"payload"`
+	State    string `db:"state"`
+	Attempts int    `db:"attempts"`
+	// Error is the error of the last attempt, if it failed.
+	Error          string     `db:"error"`
+	RunAfter       time.Time  `db:"run_after"`
+	LeaseExpiresAt *time.Time `db:"lease_expires_at"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+}
+
+// jobBackoffAfter returns how long to wait before running a job again after it failed attempts times.
+func jobBackoffAfter(attempts int) time.Duration {
+	d := jobBackoff
+	for i := 1; i < attempts && d < jobMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, jobMaxBackoff)
+}
+
+// enqueueJob queues a job, unless the same job is already waiting.
+// If retryFailed is true, a failed job with the same kind and payload is queued again (e.g. because its input changed).
+// Workers are not woken up, so that jobs enqueued in a transaction are not looked for before it is committed; call wakeJobs after.
+func enqueueJob(ctx context.Context, db sqlx.ExecerContext, kind string, payload interface{}, retryFailed bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if retryFailed {
+		_, err = db.ExecContext(ctx, "UPDATE jobs SET state=?, attempts=0, run_after=?, updated_at=? WHERE kind=? AND payload=? AND state=?", JobQueued, now, now, kind, string(data), JobFailed)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = db.ExecContext(ctx, "INSERT OR IGNORE INTO jobs (kind, payload, state, run_after, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)", kind, string(data), JobQueued, now, now, now)
+	return err
+}
+
+// EnqueueJob queues a job, unless the same job is already waiting, and wakes up the workers.
+func (s *Storage) EnqueueJob(ctx context.Context, kind string, payload interface{}) error {
+	if _, ok := jobKinds[kind]; !ok {
+		return fmt.Errorf("unknown job kind %q", kind)
+	}
+	err := enqueueJob(ctx, s.DB, kind, payload, true)
+	if err != nil {
+		return err
+	}
+	s.wakeJobs()
+	return nil
+}
+
+// wakeJobs makes an idle worker look for jobs now, instead of after jobPollInterval.
+func (s *Storage) wakeJobs() {
+	select {
+	case s.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// Jobs returns the queued, running and failed jobs, failed ones first, then in the order they run.
+func (s *Storage) Jobs(ctx context.Context) ([]Job, error) {
+	jobs := make([]Job, 0)
+	err := s.DB.SelectContext(ctx, &jobs, "SELECT * FROM jobs ORDER BY state != ?, state != ?, run_after, id", JobFailed, JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ErrJobNotFound is returned by JobRetry for jobs that do not exist or did not fail.
+var ErrJobNotFound = errors.New("failed job not found")
+
+// JobRetry queues a failed job again, with its attempts reset.
+func (s *Storage) JobRetry(ctx context.Context, id int64) error {
+	now := time.Now()
+	res, err := s.DB.ExecContext(ctx, "UPDATE jobs SET state=?, attempts=0, run_after=?, updated_at=? WHERE id=? AND state=?", JobQueued, now, now, id, JobFailed)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	s.wakeJobs()
+	return nil
+}
+
+// claimJob takes the next job that is due (or whose worker's lease expired), or returns nil if there is none.
+func (s *Storage) claimJob(ctx context.Context) (*Job, error) {
+	now := time.Now()
+	var j Job
+	err := s.DB.GetContext(ctx, &j, "UPDATE jobs SET state=?, attempts=attempts+1, lease_expires_at=?, updated_at=? WHERE id=(SELECT id FROM jobs WHERE (state=? AND unixepoch(run_after) <= ?) OR (state=? AND unixepoch(lease_expires_at) < ?) ORDER BY unixepoch(run_after), id LIMIT 1) RETURNING *", JobRunning, now.Add(jobLease), now, JobQueued, now.Unix(), JobRunning, now.Unix())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// runJob runs a claimed job, and removes it if it succeeded, or queues it again with backoff (or marks it as failed) otherwise.
+// Only errors writing to the database are returned.
+func (s *Storage) runJob(ctx context.Context, j *Job) error {
+	run, ok := jobKinds[j.Kind]
+	var err error
+	if !ok {
+		err = fmt.Errorf("unknown job kind %q", j.Kind)
+	} else {
+		renewCtx, stopRenew := context.WithCancel(ctx)
+		go s.renewJobLease(renewCtx, j.ID)
+		err = run(s, ctx, []byte(j.Payload))
+		stopRenew()
+	}
+	if err == nil {
+		_, err = s.DB.ExecContext(context.Background(), "DELETE FROM jobs WHERE id=?", j.ID)
+		return err
+	}
+
+	now := time.Now()
+	var res sql.Result
+	if ctx.Err() != nil {
+		// interrupted by shutdown; this attempt does not count
+		res, err = s.DB.ExecContext(context.Background(), "UPDATE OR IGNORE jobs SET state=?, attempts=attempts-1, lease_expires_at=NULL, updated_at=? WHERE id=?", JobQueued, now, j.ID)
+	} else if j.Attempts >= jobMaxAttempts {
+		log.Printf("job %d (%s %s) failed: %s", j.ID, j.Kind, j.Payload, err)
+		res, err = s.DB.ExecContext(ctx, "UPDATE OR IGNORE jobs SET state=?, error=?, lease_expires_at=NULL, updated_at=? WHERE id=?", JobFailed, err.Error(), now, j.ID)
+	} else {
+		backoff := jobBackoffAfter(j.Attempts)
+		log.Printf("job %d (%s %s) failed (attempt %d, retrying in %s): %s", j.ID, j.Kind, j.Payload, j.Attempts, backoff, err)
+		res, err = s.DB.ExecContext(ctx, "UPDATE OR IGNORE jobs SET state=?, error=?, run_after=?, lease_expires_at=NULL, updated_at=? WHERE id=?", JobQueued, err.Error(), now.Add(backoff), now, j.ID)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// The same job was enqueued again while this one ran, so that one supersedes this one.
+		_, err = s.DB.ExecContext(context.Background(), "DELETE FROM jobs WHERE id=?", j.ID)
+	}
+	return err
+}
+
+// renewJobLease extends the lease of a running job every half lease, until ctx is done.
+func (s *Storage) renewJobLease(ctx context.Context, id int64) {
+	ticker := time.NewTicker(jobLease / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		_, err := s.DB.ExecContext(ctx, "UPDATE jobs SET lease_expires_at=?, updated_at=? WHERE id=? AND state=?", now.Add(jobLease), now, id, JobRunning)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("renew lease of job %d: %s", id, err)
+		}
+	}
+}
+
+// RunJobsOnce runs jobs until none are due.
+func (s *Storage) RunJobsOnce(ctx context.Context) error {
+	for ctx.Err() == nil {
+		j, err := s.claimJob(ctx)
+		if err != nil {
+			return fmt.Errorf("claim: %w", err)
+		}
+		if j == nil {
+			return nil
+		}
+		err = s.runJob(ctx, j)
+		if err != nil {
+			return fmt.Errorf("job %d: %w", j.ID, err)
+		}
+	}
+	return ctx.Err()
+}
+
+// RunJobs runs jobs with the given number of workers until ctx is done.
+// Idle workers look for jobs every jobPollInterval, or when woken up by EnqueueJob or a sync.
+func (s *Storage) RunJobs(ctx context.Context, workers int) {
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			ticker := time.NewTicker(jobPollInterval)
+			defer ticker.Stop()
+			for {
+				err := s.RunJobsOnce(ctx)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("jobs: %s", err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				case <-s.jobWake:
+				}
+			}
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+}
+
+// probePayload is the payload of JobProbe jobs.
+type probePayload struct {
+	SampleID string `json:"sample_id"`
+}
+
+// runProbeJob probes the first media file of a sample, and writes its duration, peaks and speech segments.
+// Failing to decode the audio is recorded in the peaks table instead of failing the job, as it fails the same way every time.
+func (s *Storage) runProbeJob(ctx context.Context, payload []byte) error {
+	var p probePayload
+	err := json.Unmarshal(payload, &p)
+	if err != nil {
+		return err
+	}
+	err = s.ensureIndex()
+	if err != nil {
+		return err
+	}
+	media := s.index.media(p.SampleID)
+	if len(media) == 0 {
+		// removed since the job was queued
+		return nil
+	}
+	d, err := s.probeDuration(s.samplePath(media[0]))
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", media[0], err)
+	}
+	peaks := Peaks{SampleID: p.SampleID, Media: media[0], Data: []byte{}, CreatedAt: time.Now()}
+	var speech []SpeechSegment
+	levels, err := s.decodeLevels(ctx, s.samplePath(media[0]))
+	if err != nil && ctx.Err() != nil {
+		// interrupted by shutdown, not a decoding failure
+		return ctx.Err()
+	} else if err != nil {
+		log.Printf("decode %s failed: %s", media[0], err)
+		peaks.Error = err.Error()
+	} else {
+		peaks.Data = mergePeaks(levels.Peaks, MaxPeaks)
+		peaks.Duration = levels.Duration
+		speech = detectSpeech(levels.RMS, levels.Length)
+	}
+
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var start time.Time
+	err = tx.GetContext(ctx, &start, "SELECT start FROM samples WHERE id=?", p.SampleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE samples SET duration=?, end=? WHERE id=?", d, start.Add(d), p.SampleID)
+	if err != nil {
+		return fmt.Errorf("duration: %w", err)
+	}
+	_, err = tx.NamedExecContext(ctx, "INSERT INTO peaks (sample_id, media, duration, data, error, created_at) VALUES (:sample_id, :media, :duration, :data, :error, :created_at) ON CONFLICT (sample_id) DO UPDATE SET media=excluded.media, duration=excluded.duration, data=excluded.data, error=excluded.error, created_at=excluded.created_at", peaks)
+	if err != nil {
+		return fmt.Errorf("peaks: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM speech_segments WHERE sample_id=?", p.SampleID)
+	if err != nil {
+		return fmt.Errorf("speech: %w", err)
+	}
+	// Samples that could not be decoded have no speech duration, so that they are not hidden as silent.
+	var speechD *time.Duration
+	if peaks.Error == "" {
+		for _, seg := range speech {
+			seg.SampleID = p.SampleID
+			_, err = tx.NamedExecContext(ctx, "INSERT INTO speech_segments (sample_id, start_offset, end_offset) VALUES (:sample_id, :start_offset, :end_offset)", seg)
+			if err != nil {
+				return fmt.Errorf("speech: %w", err)
+			}
+		}
+		total := speechDuration(speech)
+		speechD = &total
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE samples SET speech_duration=? WHERE id=?", speechD, p.SampleID)
+	if err != nil {
+		return fmt.Errorf("speech duration: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ftsTables are the full text search indexes. They are kept current by triggers on their tables.
+var ftsTables = []string{"samples_fts", "clips_fts", "bookmarks_fts", "transcript_cues_fts"}
+
+// checkFTS queues a JobRebuildFTS job if a full text search index does not match its table.
+// The triggers keep the indexes current, so they only drift if the database was changed without them (e.g. by a migration, or by restoring a backup).
+func (s *Storage) checkFTS(ctx context.Context) error {
+	for _, table := range ftsTables {
+		_, err := s.DB.ExecContext(ctx, "INSERT INTO "+table+"("+table+", rank) VALUES ('integrity-check', 1)")
+		var serr sqlite3.Error
+		if errors.As(err, &serr) && serr.ExtendedCode == sqlite3.ErrCorruptVTab {
+			log.Printf("%s does not match its table, queueing a rebuild.", table)
+			return s.EnqueueJob(ctx, JobRebuildFTS, struct{}{})
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// runRebuildFTSJob rebuilds the full text search indexes from their tables.
+func (s *Storage) runRebuildFTSJob(ctx context.Context, payload []byte) error {
+	for _, table := range ftsTables {
+		_, err := s.DB.ExecContext(ctx, "INSERT INTO "+table+"("+table+") VALUES ('rebuild')")
+		if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
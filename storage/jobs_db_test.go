@@ -0,0 +1,219 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Times in zones far from the local one, so that comparing them as text would give the wrong result.
+var (
+	farWest = time.FixedZone("", -10*60*60)
+	farEast = time.FixedZone("", 14*60*60)
+)
+
+// addTestJobKind adds a job kind running run, and returns the number of times it ran.
+func addTestJobKind(t *testing.T, run func() error) *int {
+	t.Helper()
+	n := new(int)
+	jobKinds["test"] = func(s *Storage, ctx context.Context, payload []byte) error {
+		*n++
+		return run()
+	}
+	t.Cleanup(func() { delete(jobKinds, "test") })
+	return n
+}
+
+// testJob returns the only job.
+func testJob(t *testing.T, s *Storage) Job {
+	t.Helper()
+	var j Job
+	err := s.DB.Get(&j, "SELECT * FROM jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestClaimJob(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	err := enqueueJob(ctx, s.DB, "test", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.DB.Exec("UPDATE jobs SET run_after=?", time.Now().Add(time.Hour).In(farWest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := s.claimJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j != nil {
+		t.Fatalf("expected no job before run_after, got %+v", j)
+	}
+
+	_, err = s.DB.Exec("UPDATE jobs SET run_after=?", time.Now().Add(-time.Minute).In(farEast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err = s.claimJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.State != JobRunning || j.Attempts != 1 || j.LeaseExpiresAt == nil || !j.LeaseExpiresAt.After(time.Now()) {
+		t.Fatalf("expected a running job with a lease, got %+v", j)
+	}
+	other, err := s.claimJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other != nil {
+		t.Fatalf("expected no job while leased, got %+v", other)
+	}
+}
+
+func TestClaimJobLeaseExpired(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	err := enqueueJob(ctx, s.DB, "test", 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := s.claimJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil {
+		t.Fatal("expected a job")
+	}
+	// e.g. the server was killed while running the job
+	_, err = s.DB.Exec("UPDATE jobs SET lease_expires_at=?", time.Now().Add(-time.Minute).In(farEast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.claimJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == nil || again.ID != j.ID || again.Attempts != 2 || !again.LeaseExpiresAt.After(time.Now()) {
+		t.Fatalf("expected the job to be claimed again with a new lease, got %+v", again)
+	}
+}
+
+func TestRunJobBackoff(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	runs := addTestJobKind(t, func() error { return errors.New("oops") })
+	err := s.EnqueueJob(ctx, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	err = s.RunJobsOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := testJob(t, s)
+	if *runs != 1 || j.State != JobQueued || j.Attempts != 1 || j.Error != "oops" || j.LeaseExpiresAt != nil {
+		t.Fatalf("expected a queued job after 1 run, got %d runs and %+v", *runs, j)
+	}
+	if j.RunAfter.Before(before.Add(jobBackoffAfter(1))) {
+		t.Fatalf("expected run_after after the backoff, got %s", j.RunAfter)
+	}
+	err = s.RunJobsOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *runs != 1 {
+		t.Fatalf("expected no run during the backoff, got %d runs", *runs)
+	}
+}
+
+func TestRunJobFailed(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	runs := addTestJobKind(t, func() error { return errors.New("oops") })
+	err := s.EnqueueJob(ctx, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < jobMaxAttempts+1; i++ {
+		// skip the backoff
+		_, err = s.DB.Exec("UPDATE jobs SET run_after=?", time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.RunJobsOnce(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	j := testJob(t, s)
+	if *runs != jobMaxAttempts || j.State != JobFailed || j.Attempts != jobMaxAttempts || j.Error != "oops" {
+		t.Fatalf("expected a failed job after %d runs, got %d runs and %+v", jobMaxAttempts, *runs, j)
+	}
+
+	err = s.JobRetry(ctx, j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RunJobsOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j = testJob(t, s)
+	if *runs != jobMaxAttempts+1 || j.State != JobQueued || j.Attempts != 1 {
+		t.Fatalf("expected the retried job to run again, got %d runs and %+v", *runs, j)
+	}
+}
+
+func TestSyncQueuesFTSRebuild(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x", id + "." + SummaryExt: "hello"})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	err = s.DB.Get(&n, "SELECT COUNT(*) FROM jobs WHERE kind=?", JobRebuildFTS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected no rebuild while the indexes match, got %d", n)
+	}
+
+	// as if the database was changed without the triggers before this process started
+	_, err = s.DB.Exec("INSERT INTO samples_fts(samples_fts) VALUES ('delete-all')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ftsChecked = false
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j Job
+	err = s.DB.Get(&j, "SELECT * FROM jobs WHERE kind=?", JobRebuildFTS)
+	if err != nil {
+		t.Fatalf("expected a rebuild to be queued: %s", err)
+	}
+	err = s.runRebuildFTSJob(ctx, []byte(j.Payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sps, err := s.Search(SearchOptions{Query: "hello"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sps) != 1 || sps[0].ID != id {
+		t.Fatalf("expected the sample to be found after the rebuild, got %+v", sps)
+	}
+}
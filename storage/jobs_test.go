@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobBackoffAfter(t *testing.T) {
+	cases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, c := range cases {
+		d := jobBackoffAfter(c.attempts)
+		if d != c.expected {
+			t.Errorf("attempts %d: expected %s, got %s", c.attempts, c.expected, d)
+		}
+	}
+}
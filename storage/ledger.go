@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fileRecord is a row in the files table (the ledger).
+// The ledger records the state of each file in the samples directory as of the last sync, so that SyncFiles only has to look at samples whose files changed.
+type fileRecord struct {
+	Name     string `db:"name"`
+	SampleID string `db:"sample_id"`
+	Size     int64  `db:"size"`
+	// ModTime is the modification time in Unix nanoseconds.
+	ModTime int64  `db:"mtime"`
+	Hash    string `db:"hash"`
+}
+
+// sameStat reports whether r and r2 have the same size and modification time.
+func (r fileRecord) sameStat(r2 fileRecord) bool {
+	return r.Size == r2.Size && r.ModTime == r2.ModTime
+}
+
+// isSampleFile reports whether the file is tracked by the ledger (media, summary, transcript or metadata).
+func isSampleFile(name string) bool {
+	ext := filepath.Ext(name)
+	if len(ext) == 0 || IsTranscriptOriginal(name) {
+		return false
+	}
+	ext = ext[1:]
+	if _, ok := MediaFileTypes[ext]; ok {
+		return true
+	}
+	return ext == SummaryExt || ext == TranscriptExt || ext == MetadataExt
+}
+
+// isIndexedFile reports whether the file is in the sample index: a sample file, or the original of a converted transcript.
+func isIndexedFile(name string) bool {
+	return isSampleFile(name) || IsTranscriptOriginal(name)
+}
+
+func isMediaFile(name string) bool {
+	ext := filepath.Ext(name)
+	if len(ext) == 0 {
+		return false
+	}
+	_, ok := MediaFileTypes[ext[1:]]
+	return ok
+}
+
+// sampleIDFromName returns the sample ID of the file with the given name (relative to the samples directory).
+// Sample files may be in subdirectories (e.g. YYYY/MM/DD/), but the ID is always derived from the base name.
+func sampleIDFromName(name string) string {
+	base := path.Base(name)
+	ext := sampleFileExt(base)
+	if ext == "" {
+		return base
+	}
+	return base[:len(base)-len(ext)-1]
+}
+
+// sampleFileExt returns the extension (without the dot) the file is indexed by.
+// This is the whole extension of a transcript original (e.g. whisper.json), and the last one otherwise.
+func sampleFileExt(name string) string {
+	for _, ext := range TranscriptOriginalExts {
+		if strings.HasSuffix(name, "."+ext) {
+			return ext
+		}
+	}
+	return strings.TrimPrefix(path.Ext(name), ".")
+}
+
+// scanSamplesDir returns ledger records (without hashes) for all sample files in the samples directory and its subdirectories.
+// Records for transcript originals are returned too, for the index; they are not in the ledger.
+// Names are slash-separated paths relative to the samples directory. Hidden directories are skipped.
+// Files whose sample IDs are not accepted by any of s.FilenameParsers are returned in unparseable instead.
+func (s *Storage) scanSamplesDir() (records []fileRecord, unparseable []string, err error) {
+	records = make([]fileRecord, 0)
+	unparseable = make([]string, 0)
+	err = filepath.WalkDir(s.SamplesPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p != s.SamplesPath {
+				// removed during the walk
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if p != s.SamplesPath && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isIndexedFile(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			// removed since ReadDir
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("stat %s: %w", p, err)
+		}
+		rel, err := filepath.Rel(s.SamplesPath, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if _, ok := s.parseStart(sampleIDFromName(name)); !ok {
+			// transcript originals are not synced either way
+			if isSampleFile(name) {
+				unparseable = append(unparseable, name)
+			}
+			return nil
+		}
+		records = append(records, fileRecord{
+			Name:     name,
+			SampleID: sampleIDFromName(name),
+			Size:     info.Size(),
+			ModTime:  info.ModTime().UnixNano(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, unparseable, nil
+}
+
+// statSampleFiles is like scanSamplesDir, but only for the given names.
+// Names that do not exist or are neither sample files nor transcript originals are skipped.
+func (s *Storage) statSampleFiles(names []string) (records []fileRecord, unparseable []string, err error) {
+	records = make([]fileRecord, 0, len(names))
+	unparseable = make([]string, 0)
+	for _, name := range names {
+		if !isIndexedFile(name) {
+			continue
+		}
+		info, err := os.Stat(s.samplePath(name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("stat %s: %w", name, err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		if _, ok := s.parseStart(sampleIDFromName(name)); !ok {
+			// transcript originals are not synced either way
+			if isSampleFile(name) {
+				unparseable = append(unparseable, name)
+			}
+			continue
+		}
+		records = append(records, fileRecord{
+			Name:     name,
+			SampleID: sampleIDFromName(name),
+			Size:     info.Size(),
+			ModTime:  info.ModTime().UnixNano(),
+		})
+	}
+	return records, unparseable, nil
+}
+
+// samplePath returns the path of the file with the given name (relative to the samples directory).
+func (s *Storage) samplePath(name string) string {
+	return filepath.Join(s.SamplesPath, filepath.FromSlash(name))
+}
+
+// loadLedger returns the ledger records for the given names, or all records if names is nil.
+func (s *Storage) loadLedger(ctx context.Context, names []string) (map[string]fileRecord, error) {
+	records := make([]fileRecord, 0)
+	var err error
+	if names == nil {
+		err = s.DB.SelectContext(ctx, &records, "SELECT * FROM files")
+	} else if len(names) != 0 {
+		var query string
+		var args []interface{}
+		query, args, err = sqlx.In("SELECT * FROM files WHERE name IN (?)", names)
+		if err != nil {
+			return nil, err
+		}
+		err = s.DB.SelectContext(ctx, &records, s.DB.Rebind(query), args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ledger := make(map[string]fileRecord, len(records))
+	for _, r := range records {
+		ledger[r.Name] = r
+	}
+	return ledger, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of the file's contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
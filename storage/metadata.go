@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MetadataExt is the extension of sidecar files with SampleMetadata in JSON.
+const MetadataExt = "json"
+
+// ErrInvalidMetadata is returned for sidecar files that cannot be parsed or have values out of range.
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// SampleMetadata is what the recorder knows about a sample, read from the <id>.json sidecar file.
+// All fields are optional. Fields not in this schema are kept when the file is written back.
+//
+// Example:
+//
+//	{
+//	  "device": "Pixel 7",
+//	  "app": "Seekback",
+//	  "app_version": "1.4.0",
+//	  "input_gain": -3.5,
+//	  "location": {"latitude": 35.6812, "longitude": 139.7671, "accuracy": 12}
+//	}
+type SampleMetadata struct {
+	// Device is the name of the recording device.
+	Device string `json:"device,omitempty"`
+	// App and AppVersion identify the recording app.
+	App        string `json:"app,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+	// InputGain is the input gain in dB.
+	InputGain *float64     `json:"input_gain,omitempty"`
+	Location  *GeoLocation `json:"location,omitempty"`
+}
+
+// metadataKeys are the JSON keys of SampleMetadata's fields.
+var metadataKeys = []string{"device", "app", "app_version", "input_gain", "location"}
+
+// GeoLocation is a WGS 84 position.
+type GeoLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Altitude is in metres above the WGS 84 ellipsoid.
+	Altitude *float64 `json:"altitude,omitempty"`
+	// Accuracy is the horizontal accuracy radius in metres.
+	Accuracy *float64 `json:"accuracy,omitempty"`
+}
+
+// GeoBounds is a latitude and longitude range, in degrees.
+// If West is greater than East, the bounds cross the antimeridian.
+type GeoBounds struct {
+	South, West, North, East float64
+}
+
+// Validate checks that the values are in range.
+func (b GeoBounds) Validate() error {
+	if b.South < -90 || b.North > 90 || b.South > b.North {
+		return fmt.Errorf("latitudes %g to %g out of range", b.South, b.North)
+	}
+	if b.West < -180 || b.West > 180 || b.East < -180 || b.East > 180 {
+		return fmt.Errorf("longitudes %g to %g out of range", b.West, b.East)
+	}
+	return nil
+}
+
+// Validate checks that the values are in range.
+func (m SampleMetadata) Validate() error {
+	if m.Location != nil {
+		if m.Location.Latitude < -90 || m.Location.Latitude > 90 {
+			return fmt.Errorf("latitude %g out of range", m.Location.Latitude)
+		}
+		if m.Location.Longitude < -180 || m.Location.Longitude > 180 {
+			return fmt.Errorf("longitude %g out of range", m.Location.Longitude)
+		}
+		if m.Location.Accuracy != nil && *m.Location.Accuracy < 0 {
+			return errors.New("accuracy must not be negative")
+		}
+	}
+	return nil
+}
+
+// searchText returns the text indexed for full text search.
+func (m SampleMetadata) searchText() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{m.Device, m.App, m.AppVersion} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// ParseSampleMetadata parses and validates a sidecar file.
+func ParseSampleMetadata(data []byte) (SampleMetadata, error) {
+	var m SampleMetadata
+	err := json.Unmarshal(data, &m)
+	if err == nil {
+		err = m.Validate()
+	}
+	if err != nil {
+		return SampleMetadata{}, fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	return m, nil
+}
+
+// Value implements driver.Valuer; metadata is stored as JSON.
+func (m SampleMetadata) Value() (driver.Value, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner.
+func (m *SampleMetadata) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*m = SampleMetadata{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(src), m)
+	case []byte:
+		return json.Unmarshal(src, m)
+	default:
+		return fmt.Errorf("cannot scan %T into SampleMetadata", src)
+	}
+}
+
+// SampleMetadataSet writes m to the sample's sidecar file and syncs it.
+// Fields in an existing sidecar file that are not in SampleMetadata are kept.
+func (s *Storage) SampleMetadataSet(ctx context.Context, id string, m SampleMetadata) error {
+	err := m.Validate()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMetadata, err)
+	}
+	err = s.ensureIndex()
+	if err != nil {
+		return err
+	}
+	name, ok := s.sidecarName(id, MetadataExt)
+	if !ok {
+		return ErrNoMedia
+	}
+
+	fields := map[string]json.RawMessage{}
+	old, err := os.ReadFile(s.samplePath(name))
+	if err == nil {
+		err = json.Unmarshal(old, &fields)
+		if err != nil {
+			// Don't lose data we don't understand.
+			return fmt.Errorf("existing %s is not a JSON object: %w", name, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for _, key := range metadataKeys {
+		delete(fields, key)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.writeFileAtomic(name, append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	return s.SyncFileNames(ctx, []string{name})
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it to name (relative to the samples directory).
+func (s *Storage) writeFileAtomic(name string, data []byte) error {
+	p := s.samplePath(name)
+	f, err := os.CreateTemp(filepath.Dir(p), ".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSampleMetadata(t *testing.T) {
+	m, err := ParseSampleMetadata([]byte(`{"device": "Zoom H5", "app_version": "1.4.0", "input_gain": -3.5, "location": {"latitude": 35.6812, "longitude": 139.7671}, "unknown": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Device != "Zoom H5" || m.AppVersion != "1.4.0" || m.InputGain == nil || *m.InputGain != -3.5 {
+		t.Fatalf("unexpected metadata %+v", m)
+	}
+	if m.Location == nil || m.Location.Latitude != 35.6812 || m.Location.Longitude != 139.7671 {
+		t.Fatalf("unexpected location %+v", m.Location)
+	}
+	if got := m.searchText(); got != "Zoom H5\n1.4.0" {
+		t.Fatalf("unexpected search text %q", got)
+	}
+
+	for _, data := range []string{
+		`[]`,
+		`{"device": 1}`,
+		`{"location": {"latitude": 91, "longitude": 0}}`,
+		`{"location": {"latitude": 0, "longitude": -181}}`,
+	} {
+		_, err := ParseSampleMetadata([]byte(data))
+		if !errors.Is(err, ErrInvalidMetadata) {
+			t.Errorf("%s: expected ErrInvalidMetadata, got %v", data, err)
+		}
+	}
+}
+
+func TestGeoBoundsValidate(t *testing.T) {
+	for _, b := range []GeoBounds{
+		{South: 35, West: 139, North: 36, East: 140},
+		// across the antimeridian
+		{South: -20, West: 170, North: -10, East: -170},
+	} {
+		err := b.Validate()
+		if err != nil {
+			t.Errorf("%+v: %s", b, err)
+		}
+	}
+	for _, b := range []GeoBounds{
+		{South: 36, West: 139, North: 35, East: 140},
+		{South: -91, West: 0, North: 0, East: 1},
+		{South: 0, West: 0, North: 1, East: 181},
+	} {
+		err := b.Validate()
+		if err == nil {
+			t.Errorf("%+v: expected an error", b)
+		}
+	}
+}
+
+func TestSampleMetadataValue(t *testing.T) {
+	gain := 6.0
+	m := SampleMetadata{Device: "Pixel", InputGain: &gain}
+	v, err := m.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 SampleMetadata
+	err = m2.Scan(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.Device != m.Device || m2.InputGain == nil || *m2.InputGain != gain {
+		t.Fatalf("expected %+v, got %+v", m, m2)
+	}
+}
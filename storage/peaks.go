@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxPeaks is the maximum number of peaks stored per sample. Longer samples have longer peaks.
+const MaxPeaks = 4000
+
+// peaksPerSecond is the resolution peaks (and speech) are computed at, before peaks are merged down to at most MaxPeaks.
+const peaksPerSecond = 100
+
+// Peaks is the waveform of a sample's media: the maximum absolute amplitude (out of 255) of consecutive, equally long parts of it.
+type Peaks struct {
+	SampleID string `db:"sample_id"`
+	// Media is the file (relative to the samples directory) the peaks were computed from.
+	Media string `db:"media"`
+	// Duration is the duration of the decoded audio; each peak covers Duration/len(Data).
+	Duration time.Duration `db:"duration"`
+	Data     []byte        `db:"data"`
+	// Error is set if decoding the media failed. Data is empty then.
+	Error     string    `db:"error"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// ErrPeaksNotFound is returned by SamplePeaks for samples without peaks.
+var ErrPeaksNotFound = errors.New("peaks not found")
+
+// SamplePeaks returns the peaks of a sample's media.
+func (s *Storage) SamplePeaks(ctx context.Context, id string) (Peaks, error) {
+	var p Peaks
+	err := s.DB.GetContext(ctx, &p, "SELECT * FROM peaks WHERE sample_id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Peaks{}, ErrPeaksNotFound
+	}
+	return p, err
+}
+
+// pcmFormat describes interleaved PCM samples.
+type pcmFormat struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	Float         bool
+	BigEndian     bool
+}
+
+func (f pcmFormat) validate() error {
+	if f.SampleRate <= 0 || f.Channels <= 0 {
+		return errors.New("invalid sample rate or channel count")
+	}
+	switch {
+	case !f.Float && (f.BitsPerSample == 8 || f.BitsPerSample == 16 || f.BitsPerSample == 24 || f.BitsPerSample == 32):
+	case f.Float && (f.BitsPerSample == 32 || f.BitsPerSample == 64):
+	default:
+		return fmt.Errorf("%d-bit samples: %w", f.BitsPerSample, ErrUnsupportedMedia)
+	}
+	return nil
+}
+
+// amplitude returns the absolute amplitude (from 0 to 1) of the sample in b.
+func (f pcmFormat) amplitude(b []byte) float64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if f.BigEndian {
+		order = binary.BigEndian
+	}
+	var v float64
+	switch {
+	case f.Float && f.BitsPerSample == 32:
+		v = float64(math.Float32frombits(order.Uint32(b)))
+	case f.Float:
+		v = math.Float64frombits(order.Uint64(b))
+	case f.BitsPerSample == 8:
+		// 8-bit WAVE is unsigned, 8-bit AIFF is signed
+		if f.BigEndian {
+			v = float64(int8(b[0])) / (1 << 7)
+		} else {
+			v = (float64(b[0]) - 128) / (1 << 7)
+		}
+	case f.BitsPerSample == 16:
+		v = float64(int16(order.Uint16(b))) / (1 << 15)
+	case f.BitsPerSample == 24:
+		var n int32
+		if f.BigEndian {
+			n = int32(b[0])<<24 | int32(b[1])<<16 | int32(b[2])<<8
+		} else {
+			n = int32(b[2])<<24 | int32(b[1])<<16 | int32(b[0])<<8
+		}
+		v = float64(n) / (1 << 31)
+	case f.BitsPerSample == 32:
+		v = float64(int32(order.Uint32(b))) / (1 << 31)
+	}
+	return min(math.Abs(v), 1)
+}
+
+// pcmLevels are the levels of consecutive parts of decoded audio, each Length long (except for the last one).
+type pcmLevels struct {
+	// Peaks are the maximum absolute amplitudes, and RMS the root mean square amplitudes (of all channels), from 0 to 1.
+	Peaks    []float64
+	RMS      []float64
+	Length   time.Duration
+	Duration time.Duration
+}
+
+// readPCMLevels reads PCM samples from r until EOF, and returns their levels at peaksPerSecond.
+func readPCMLevels(r io.Reader, f pcmFormat) (pcmLevels, error) {
+	err := f.validate()
+	if err != nil {
+		return pcmLevels{}, err
+	}
+	sampleSize := f.BitsPerSample / 8
+	frameSize := sampleSize * f.Channels
+	framesPerPart := int64(max(f.SampleRate/peaksPerSecond, 1))
+	levels := pcmLevels{
+		Peaks:  make([]float64, 0),
+		RMS:    make([]float64, 0),
+		Length: samplesDuration(framesPerPart, int64(f.SampleRate)),
+	}
+	buf := make([]byte, frameSize*4096)
+	var frames int64
+	peak := 0.0
+	sumSquares := 0.0
+	addPart := func(n int64) {
+		levels.Peaks = append(levels.Peaks, peak)
+		levels.RMS = append(levels.RMS, math.Sqrt(sumSquares/float64(n*int64(f.Channels))))
+		peak = 0
+		sumSquares = 0
+	}
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return pcmLevels{}, err
+		}
+		// a partial frame at the end is ignored
+		for i := 0; i+frameSize <= n; i += frameSize {
+			for c := 0; c < f.Channels; c++ {
+				a := f.amplitude(buf[i+c*sampleSize:])
+				peak = max(peak, a)
+				sumSquares += a * a
+			}
+			frames++
+			if frames%framesPerPart == 0 {
+				addPart(framesPerPart)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if frames%framesPerPart != 0 {
+		addPart(frames % framesPerPart)
+	}
+	levels.Duration = samplesDuration(frames, int64(f.SampleRate))
+	return levels, nil
+}
+
+// mergePeaks merges consecutive peaks so that there are at most n of them, and scales them to bytes.
+func mergePeaks(peaks []float64, n int) []byte {
+	per := (len(peaks) + n - 1) / n
+	per = max(per, 1)
+	merged := make([]byte, 0, (len(peaks)+per-1)/per)
+	for i := 0; i < len(peaks); i += per {
+		peak := 0.0
+		for _, p := range peaks[i:min(i+per, len(peaks))] {
+			peak = max(peak, p)
+		}
+		merged = append(merged, byte(math.Round(peak*255)))
+	}
+	return merged
+}
+
+// wavPCM returns the format of a RIFF WAVE file, and leaves r at the start of its data, which is returned as a reader.
+func wavPCM(r io.ReadSeeker) (pcmFormat, io.Reader, error) {
+	var f pcmFormat
+	var header [12]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return f, nil, fmt.Errorf("read header: %w", err)
+	}
+	if !bytes.Equal(header[:4], []byte("RIFF")) || !bytes.Equal(header[8:], []byte("WAVE")) {
+		return f, nil, errors.New("not a WAVE file")
+	}
+	for {
+		var chunkHeader [8]byte
+		_, err = io.ReadFull(r, chunkHeader[:])
+		if err == io.EOF {
+			return f, nil, errors.New("no data chunk")
+		} else if err != nil {
+			return f, nil, fmt.Errorf("read chunk header: %w", err)
+		}
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		switch string(chunkHeader[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return f, nil, errors.New("fmt chunk too short")
+			}
+			fmtChunk := make([]byte, size)
+			_, err = io.ReadFull(r, fmtChunk)
+			if err != nil {
+				return f, nil, fmt.Errorf("read fmt chunk: %w", err)
+			}
+			tag := binary.LittleEndian.Uint16(fmtChunk[0:2])
+			if tag == 0xfffe && size >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE; the format tag is the start of the subformat GUID
+				tag = binary.LittleEndian.Uint16(fmtChunk[24:26])
+			}
+			switch tag {
+			case 1:
+			case 3:
+				f.Float = true
+			default:
+				return f, nil, fmt.Errorf("format tag %#x: %w", tag, ErrUnsupportedMedia)
+			}
+			f.Channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			f.SampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			f.BitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+			_, err = r.Seek(size%2, io.SeekCurrent)
+			if err != nil {
+				return f, nil, fmt.Errorf("skip padding: %w", err)
+			}
+			continue
+		case "data":
+			if f.SampleRate == 0 {
+				return f, nil, errors.New("data chunk before fmt chunk")
+			}
+			if size == 0xffffffff {
+				// size unknown (e.g. the recorder was interrupted); read to the end of the file
+				return f, r, nil
+			}
+			return f, io.LimitReader(r, size), nil
+		}
+		// chunks are padded to an even length
+		_, err = r.Seek(size+size%2, io.SeekCurrent)
+		if err != nil {
+			return f, nil, fmt.Errorf("skip chunk: %w", err)
+		}
+	}
+}
+
+// aiffPCM returns the format of an AIFF or AIFF-C file, and a reader of its sound data.
+func aiffPCM(r io.ReadSeeker) (pcmFormat, io.Reader, error) {
+	f := pcmFormat{BigEndian: true}
+	var header [12]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return f, nil, fmt.Errorf("read header: %w", err)
+	}
+	aifc := bytes.Equal(header[8:], []byte("AIFC"))
+	if !bytes.Equal(header[:4], []byte("FORM")) || !(bytes.Equal(header[8:], []byte("AIFF")) || aifc) {
+		return f, nil, errors.New("not an AIFF file")
+	}
+	for {
+		var chunkHeader [8]byte
+		_, err = io.ReadFull(r, chunkHeader[:])
+		if err == io.EOF {
+			return f, nil, errors.New("no SSND chunk")
+		} else if err != nil {
+			return f, nil, fmt.Errorf("read chunk header: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(chunkHeader[4:]))
+		switch string(chunkHeader[:4]) {
+		case "COMM":
+			if size < 18 || aifc && size < 22 {
+				return f, nil, errors.New("COMM chunk too short")
+			}
+			comm := make([]byte, size)
+			_, err = io.ReadFull(r, comm)
+			if err != nil {
+				return f, nil, fmt.Errorf("read COMM chunk: %w", err)
+			}
+			f.Channels = int(binary.BigEndian.Uint16(comm[0:2]))
+			f.BitsPerSample = int(binary.BigEndian.Uint16(comm[6:8]))
+			f.SampleRate = int(math.Round(parseExtended(comm[8:18])))
+			if aifc {
+				switch string(comm[18:22]) {
+				case "NONE", "twos":
+				case "sowt":
+					f.BigEndian = false
+				case "fl32", "FL32", "fl64", "FL64":
+					f.Float = true
+				default:
+					return f, nil, fmt.Errorf("compression %q: %w", comm[18:22], ErrUnsupportedMedia)
+				}
+			}
+			_, err = r.Seek(size%2, io.SeekCurrent)
+			if err != nil {
+				return f, nil, fmt.Errorf("skip padding: %w", err)
+			}
+			continue
+		case "SSND":
+			if f.SampleRate == 0 {
+				return f, nil, errors.New("SSND chunk before COMM chunk")
+			}
+			var ssnd [8]byte
+			_, err = io.ReadFull(r, ssnd[:])
+			if err != nil {
+				return f, nil, fmt.Errorf("read SSND chunk: %w", err)
+			}
+			offset := int64(binary.BigEndian.Uint32(ssnd[0:4]))
+			_, err = r.Seek(offset, io.SeekCurrent)
+			if err != nil {
+				return f, nil, err
+			}
+			return f, io.LimitReader(r, size-8-offset), nil
+		}
+		// chunks are padded to an even length
+		_, err = r.Seek(size+size%2, io.SeekCurrent)
+		if err != nil {
+			return f, nil, fmt.Errorf("skip chunk: %w", err)
+		}
+	}
+}
+
+// pcmOpeners maps file extensions to functions that read PCM natively.
+var pcmOpeners = map[string]func(io.ReadSeeker) (pcmFormat, io.Reader, error){
+	"wav":  wavPCM,
+	"aiff": aiffPCM,
+}
+
+// decodeLevels decodes the media file at path, natively for PCM files, or with ffmpeg otherwise (or if decoding natively fails).
+// ffmpeg is not used if s.FallbackProber is nil.
+func (s *Storage) decodeLevels(ctx context.Context, path string) (pcmLevels, error) {
+	var nativeErr error
+	ext := filepath.Ext(path)
+	if open, ok := pcmOpeners[strings.TrimPrefix(ext, ".")]; ok {
+		levels, err := func() (pcmLevels, error) {
+			f, err := os.Open(path)
+			if err != nil {
+				return pcmLevels{}, err
+			}
+			defer f.Close()
+			format, data, err := open(f)
+			if err != nil {
+				return pcmLevels{}, err
+			}
+			return readPCMLevels(data, format)
+		}()
+		if err == nil {
+			return levels, nil
+		}
+		nativeErr = err
+	}
+	if s.FallbackProber == nil {
+		if nativeErr != nil {
+			return pcmLevels{}, nativeErr
+		}
+		return pcmLevels{}, fmt.Errorf("%s: %w", ext, ErrUnsupportedMedia)
+	}
+	levels, err := ffmpegLevels(ctx, path)
+	if err != nil && nativeErr != nil {
+		return pcmLevels{}, fmt.Errorf("%w (fallback: %w)", nativeErr, err)
+	}
+	return levels, err
+}
+
+// ffmpegLevels decodes the media file at path to mono 16-bit PCM with ffmpeg, and returns its levels.
+// ffmpeg is killed if ctx is done.
+func ffmpegLevels(ctx context.Context, path string) (pcmLevels, error) {
+	format := pcmFormat{SampleRate: 8000, Channels: 1, BitsPerSample: 16}
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-nostdin", "-v", "error",
+		"-i", fmt.Sprintf("file:%s", path),
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(format.SampleRate), "-f", "s16le", "-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return pcmLevels{}, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return pcmLevels{}, fmt.Errorf("cmd: %w", err)
+	}
+	levels, readErr := readPCMLevels(stdout, format)
+	err = cmd.Wait()
+	if err != nil {
+		return pcmLevels{}, fmt.Errorf("cmd: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return levels, readErr
+}
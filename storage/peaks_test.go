@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWAVPeaks(t *testing.T) {
+	// 1 second of 16-bit stereo at 8000 Hz: silence, then a half-scale second half on the right channel
+	const rate = 8000
+	var data bytes.Buffer
+	for i := 0; i < rate; i++ {
+		right := int16(0)
+		if i >= rate/2 {
+			right = -1 << 14
+		}
+		binary.Write(&data, binary.LittleEndian, [2]int16{0, right})
+	}
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(2)) // channels
+	binary.Write(&buf, binary.LittleEndian, uint32(rate))
+	binary.Write(&buf, binary.LittleEndian, uint32(rate*4))
+	binary.Write(&buf, binary.LittleEndian, uint16(4)) // block align
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(data.Len()))
+	buf.Write(data.Bytes())
+
+	format, r, err := wavPCM(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels, err := readPCMLevels(r, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peaks, d := levels.Peaks, levels.Duration
+	if d != time.Second {
+		t.Fatalf("expected 1s, got %s", d)
+	}
+	if len(peaks) != peaksPerSecond {
+		t.Fatalf("expected %d peaks, got %d", peaksPerSecond, len(peaks))
+	}
+	if peaks[0] != 0 || peaks[len(peaks)-1] != 0.5 {
+		t.Fatalf("unexpected peaks %v", peaks)
+	}
+	// the right channel is at half scale, the left one silent
+	if rms := levels.RMS[len(levels.RMS)-1]; math.Abs(rms-math.Sqrt(0.125)) > 1e-9 {
+		t.Fatalf("unexpected RMS %f", rms)
+	}
+	merged := mergePeaks(peaks, 4)
+	if !bytes.Equal(merged, []byte{0, 0, 128, 128}) {
+		t.Fatalf("unexpected merged peaks %v", merged)
+	}
+}
+
+func TestAIFFPeaks(t *testing.T) {
+	comm := make([]byte, 18)
+	binary.BigEndian.PutUint16(comm[0:], 1)   // channels
+	binary.BigEndian.PutUint32(comm[2:], 200) // sample frames
+	binary.BigEndian.PutUint16(comm[6:], 24)  // sample size
+	// 100 as 80-bit extended: exponent 16383+6, mantissa 100<<57
+	binary.BigEndian.PutUint16(comm[8:], 16383+6)
+	binary.BigEndian.PutUint64(comm[10:], 100<<57)
+	var data bytes.Buffer
+	for i := 0; i < 200; i++ {
+		data.Write([]byte{0x40, 0, 0}) // half scale
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("FORM")
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	buf.WriteString("AIFF")
+	buf.WriteString("COMM")
+	binary.Write(&buf, binary.BigEndian, uint32(len(comm)))
+	buf.Write(comm)
+	buf.WriteString("SSND")
+	binary.Write(&buf, binary.BigEndian, uint32(8+data.Len()))
+	binary.Write(&buf, binary.BigEndian, [2]uint32{0, 0}) // offset, block size
+	buf.Write(data.Bytes())
+
+	format, r, err := aiffPCM(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels, err := readPCMLevels(r, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peaks, d := levels.Peaks, levels.Duration
+	if d != 2*time.Second || len(peaks) != 200 || peaks[0] != 0.5 {
+		t.Fatalf("unexpected duration %s or peaks %v", d, peaks)
+	}
+}
+
+func TestMergePeaks(t *testing.T) {
+	got := mergePeaks([]float64{0.1, 1, 0.2, 0, 0.5}, 2)
+	if !bytes.Equal(got, []byte{255, 128}) {
+		t.Fatalf("unexpected peaks %v", got)
+	}
+	if got := mergePeaks(nil, 2); len(got) != 0 {
+		t.Fatalf("unexpected peaks %v", got)
+	}
+}
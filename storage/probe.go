@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaProber gets the duration of a media file.
+type MediaProber interface {
+	ProbeDuration(path string) (time.Duration, error)
+}
+
+// ErrUnsupportedMedia is returned by a MediaProber that cannot handle the file's encoding.
+var ErrUnsupportedMedia = errors.New("unsupported media")
+
+// MediaProbers maps file extensions (without the dot) to native MediaProbers.
+var MediaProbers = map[string]MediaProber{
+	"aiff": AIFFProber{},
+	"flac": FLACProber{},
+	"m4a":  MP4Prober{},
+	"mp3":  MP3Prober{},
+	"ogg":  OggProber{},
+	"opus": OggProber{},
+	"wav":  WAVProber{},
+	"webm": WebMProber{},
+}
+
+// FFProbeProber shells out to ffprobe to get the duration of the file.
+type FFProbeProber struct{}
+
+// ProbeDuration implements MediaProber.
+// "file:" is prepended to the path, and passed to ffprobe like -i file:path.
+func (FFProbeProber) ProbeDuration(path string) (time.Duration, error) {
+	// command from <https://stackoverflow.com/a/22243834>
+	output, err := exec.Command("ffprobe",
+		"-i", fmt.Sprintf("file:%s", path),
+		"-show_entries", "format=duration",
+		"-v", "quiet",
+		"-of", "csv=p=0",
+	).Output()
+	if err != nil {
+		return 0, fmt.Errorf("cmd: %w", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// probeDuration gets the duration of the media file at path using the native prober for its extension.
+// If there is no native prober or it fails, s.FallbackProber (if not nil) is used.
+func (s *Storage) probeDuration(path string) (time.Duration, error) {
+	var nativeErr error
+	ext := filepath.Ext(path)
+	if len(ext) != 0 {
+		if p, ok := MediaProbers[ext[1:]]; ok {
+			d, err := p.ProbeDuration(path)
+			if err == nil {
+				return d, nil
+			}
+			nativeErr = err
+		}
+	}
+	if s.FallbackProber == nil {
+		if nativeErr != nil {
+			return 0, nativeErr
+		}
+		return 0, fmt.Errorf("%s: %w", ext, ErrUnsupportedMedia)
+	}
+	d, err := s.FallbackProber.ProbeDuration(path)
+	if err != nil && nativeErr != nil {
+		return 0, fmt.Errorf("%w (fallback: %w)", nativeErr, err)
+	}
+	return d, err
+}
+
+// samplesDuration returns the duration of the given number of samples (per channel) at sampleRate, without overflowing for long recordings.
+func samplesDuration(samples, sampleRate int64) time.Duration {
+	return time.Duration(samples/sampleRate)*time.Second + time.Duration(samples%sampleRate)*time.Second/time.Duration(sampleRate)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// AIFFProber reads the COMM chunk of AIFF and AIFF-C files to get the duration.
+type AIFFProber struct{}
+
+// ProbeDuration implements MediaProber.
+func (AIFFProber) ProbeDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return probeAIFFDuration(f)
+}
+
+func probeAIFFDuration(r io.ReadSeeker) (time.Duration, error) {
+	var header [12]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, fmt.Errorf("read header: %w", err)
+	}
+	if !bytes.Equal(header[:4], []byte("FORM")) || !(bytes.Equal(header[8:], []byte("AIFF")) || bytes.Equal(header[8:], []byte("AIFC"))) {
+		return 0, errors.New("not an AIFF file")
+	}
+	for {
+		var chunkHeader [8]byte
+		_, err = io.ReadFull(r, chunkHeader[:])
+		if err == io.EOF {
+			return 0, errors.New("no COMM chunk")
+		} else if err != nil {
+			return 0, fmt.Errorf("read chunk header: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(chunkHeader[4:]))
+		if !bytes.Equal(chunkHeader[:4], []byte("COMM")) {
+			// chunks are padded to an even length
+			_, err = r.Seek(size+size%2, io.SeekCurrent)
+			if err != nil {
+				return 0, fmt.Errorf("skip chunk: %w", err)
+			}
+			continue
+		}
+		if size < 18 {
+			return 0, errors.New("COMM chunk too short")
+		}
+		var comm [18]byte
+		_, err = io.ReadFull(r, comm[:])
+		if err != nil {
+			return 0, fmt.Errorf("read COMM chunk: %w", err)
+		}
+		frames := int64(binary.BigEndian.Uint32(comm[2:6]))
+		sampleRate := int64(math.Round(parseExtended(comm[8:18])))
+		if sampleRate <= 0 {
+			return 0, errors.New("invalid sample rate")
+		}
+		return samplesDuration(frames, sampleRate), nil
+	}
+}
+
+// parseExtended parses an 80-bit IEEE 754 extended precision number, as used for the AIFF sample rate.
+func parseExtended(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[:2]))
+	sign := 1.0
+	if exponent&0x8000 != 0 {
+		sign = -1
+		exponent &= 0x7fff
+	}
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	return sign * math.Ldexp(float64(mantissa), exponent-16383-63)
+}
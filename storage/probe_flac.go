@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// FLACProber reads the STREAMINFO metadata block of FLAC files to get the duration.
+type FLACProber struct{}
+
+// ProbeDuration implements MediaProber.
+func (FLACProber) ProbeDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return probeFLACDuration(f)
+}
+
+func probeFLACDuration(r io.Reader) (time.Duration, error) {
+	br := bufio.NewReader(r)
+	err := skipID3v2(br)
+	if err != nil {
+		return 0, fmt.Errorf("skip ID3v2 tag: %w", err)
+	}
+	// "fLaC", then the STREAMINFO block header (4 bytes), which is always the first metadata block
+	var header [8]byte
+	_, err = io.ReadFull(br, header[:])
+	if err != nil {
+		return 0, fmt.Errorf("read header: %w", err)
+	}
+	if !bytes.Equal(header[:4], []byte("fLaC")) {
+		return 0, errors.New("not a FLAC file")
+	}
+	if header[4]&0x7f != 0 {
+		return 0, errors.New("first metadata block is not STREAMINFO")
+	}
+	var info [18]byte
+	_, err = io.ReadFull(br, info[:])
+	if err != nil {
+		return 0, fmt.Errorf("read STREAMINFO: %w", err)
+	}
+	// 20 bits sample rate, 3 bits channels, 5 bits bits per sample, 36 bits total samples
+	sampleRate := int64(info[10])<<12 | int64(info[11])<<4 | int64(info[12])>>4
+	totalSamples := int64(info[13]&0x0f)<<32 | int64(info[14])<<24 | int64(info[15])<<16 | int64(info[16])<<8 | int64(info[17])
+	if sampleRate == 0 {
+		return 0, errors.New("invalid sample rate")
+	}
+	if totalSamples == 0 {
+		return 0, fmt.Errorf("total samples unknown: %w", ErrUnsupportedMedia)
+	}
+	return samplesDuration(totalSamples, sampleRate), nil
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// MP3Prober reads MPEG audio frame headers to get the duration.
+// If the first frame is a Xing/Info or VBRI header with a frame count, the count is used; otherwise, all frames are counted.
+type MP3Prober struct{}
+
+var mp3Bitrates = [2][3][16]int{
+	// MPEG-1: layer I, II, III (kbit/s)
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+	// MPEG-2 and MPEG-2.5: layer I, II, III (kbit/s)
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+var mp3SampleRates = [3]int{44100, 48000, 32000}
+
+type mp3FrameHeader struct {
+	// version is 1 for MPEG-1, 2 for MPEG-2, and 25 for MPEG-2.5.
+	version    int
+	layer      int
+	bitrate    int // bit/s
+	sampleRate int
+	padding    bool
+	mono       bool
+}
+
+// parseMP3FrameHeader parses the 4-byte frame header. ok is false if b is not a valid header.
+func parseMP3FrameHeader(b []byte) (h mp3FrameHeader, ok bool) {
+	if b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return h, false
+	}
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		h.version = 25
+	case 2:
+		h.version = 2
+	case 3:
+		h.version = 1
+	default:
+		return h, false
+	}
+	layerBits := (b[1] >> 1) & 3
+	if layerBits == 0 {
+		return h, false
+	}
+	h.layer = 4 - int(layerBits)
+	bitrateIndex := b[2] >> 4
+	sampleRateIndex := (b[2] >> 2) & 3
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		// free-format bitrates are not supported
+		return h, false
+	}
+	versionIndex := 0
+	if h.version != 1 {
+		versionIndex = 1
+	}
+	h.bitrate = mp3Bitrates[versionIndex][h.layer-1][bitrateIndex] * 1000
+	h.sampleRate = mp3SampleRates[sampleRateIndex]
+	switch h.version {
+	case 2:
+		h.sampleRate /= 2
+	case 25:
+		h.sampleRate /= 4
+	}
+	h.padding = (b[2]>>1)&1 == 1
+	h.mono = b[3]>>6 == 3
+	return h, true
+}
+
+func (h mp3FrameHeader) samplesPerFrame() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && h.version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// frameLength returns the length of the frame in bytes, including the header.
+func (h mp3FrameHeader) frameLength() int {
+	if h.layer == 1 {
+		n := 12 * h.bitrate / h.sampleRate
+		if h.padding {
+			n++
+		}
+		return n * 4
+	}
+	n := h.samplesPerFrame() / 8 * h.bitrate / h.sampleRate
+	if h.padding {
+		n++
+	}
+	return n
+}
+
+// sideInfoLength returns the length of the layer III side information, which is where the Xing header starts.
+func (h mp3FrameHeader) sideInfoLength() int {
+	switch {
+	case h.version == 1 && h.mono:
+		return 17
+	case h.version == 1:
+		return 32
+	case h.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// vbrFrameCount returns the number of frames given by the Xing/Info or VBRI header in the first frame, if any.
+// The count does not include the header frame itself.
+func (h mp3FrameHeader) vbrFrameCount(frame []byte) (int, bool) {
+	xingOffset := 4 + h.sideInfoLength()
+	if len(frame) >= xingOffset+12 {
+		tag := frame[xingOffset : xingOffset+4]
+		if bytes.Equal(tag, []byte("Xing")) || bytes.Equal(tag, []byte("Info")) {
+			flags := binary.BigEndian.Uint32(frame[xingOffset+4:])
+			if flags&1 != 0 {
+				return int(binary.BigEndian.Uint32(frame[xingOffset+8:])), true
+			}
+			return 0, false
+		}
+	}
+	const vbriOffset = 4 + 32
+	if len(frame) >= vbriOffset+18 && bytes.Equal(frame[vbriOffset:vbriOffset+4], []byte("VBRI")) {
+		return int(binary.BigEndian.Uint32(frame[vbriOffset+14:])), true
+	}
+	return 0, false
+}
+
+// skipID3v2 skips an ID3v2 tag at the start of r, if present.
+func skipID3v2(r *bufio.Reader) error {
+	header, err := r.Peek(10)
+	if err != nil {
+		// too short to have a tag
+		return nil
+	}
+	if !bytes.Equal(header[:3], []byte("ID3")) {
+		return nil
+	}
+	// syncsafe integer
+	size := int(header[6])<<21 | int(header[7])<<14 | int(header[8])<<7 | int(header[9])
+	size += 10
+	if header[5]&0x10 != 0 {
+		// footer present
+		size += 10
+	}
+	_, err = r.Discard(size)
+	return err
+}
+
+// ProbeDuration implements MediaProber.
+func (MP3Prober) ProbeDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return probeMP3Duration(f)
+}
+
+func probeMP3Duration(r io.Reader) (time.Duration, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	err := skipID3v2(br)
+	if err != nil {
+		return 0, fmt.Errorf("skip ID3v2 tag: %w", err)
+	}
+
+	var first *mp3FrameHeader
+	frames := 0
+	for {
+		b, err := br.Peek(4)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		h, ok := parseMP3FrameHeader(b)
+		if !ok || (first != nil && (h.sampleRate != first.sampleRate || h.layer != first.layer)) {
+			// resync (e.g. garbage or trailing tags)
+			_, err = br.Discard(1)
+			if err != nil {
+				break
+			}
+			continue
+		}
+		length := h.frameLength()
+		if first == nil {
+			first = &h
+			frame, err := br.Peek(length)
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			if count, ok := h.vbrFrameCount(frame); ok {
+				return mp3Duration(count, h), nil
+			}
+		}
+		frames++
+		_, err = br.Discard(length)
+		if err != nil {
+			// last frame truncated
+			break
+		}
+	}
+	if first == nil {
+		return 0, errors.New("no MPEG audio frames found")
+	}
+	return mp3Duration(frames, *first), nil
+}
+
+func mp3Duration(frames int, h mp3FrameHeader) time.Duration {
+	return samplesDuration(int64(frames)*int64(h.samplesPerFrame()), int64(h.sampleRate))
+}
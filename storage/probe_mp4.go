@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// MP4Prober reads the mvhd box of MP4 (M4A) files to get the duration.
+type MP4Prober struct{}
+
+// ProbeDuration implements MediaProber.
+func (MP4Prober) ProbeDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return probeMP4Duration(f)
+}
+
+func probeMP4Duration(r io.ReadSeeker) (time.Duration, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	moovStart, moovEnd, err := findMP4Box(r, 0, end, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhdStart, _, err := findMP4Box(r, moovStart, moovEnd, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.Seek(mvhdStart, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	var mvhd [32]byte
+	_, err = io.ReadFull(r, mvhd[:])
+	if err != nil {
+		return 0, fmt.Errorf("read mvhd: %w", err)
+	}
+	var timescale, duration uint64
+	switch mvhd[0] {
+	case 0:
+		// version, flags, creation time, modification time (32 bits each)
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	case 1:
+		// version, flags, creation time, modification time (64 bits each)
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	default:
+		return 0, fmt.Errorf("unknown mvhd version %d", mvhd[0])
+	}
+	if timescale == 0 {
+		return 0, errors.New("invalid timescale")
+	}
+	return samplesDuration(int64(duration), int64(timescale)), nil
+}
+
+// findMP4Box returns the range of the body of the first box of type typ between start and end.
+func findMP4Box(r io.ReadSeeker, start, end int64, typ string) (bodyStart, bodyEnd int64, err error) {
+	for pos := start; pos+8 <= end; {
+		_, err = r.Seek(pos, io.SeekStart)
+		if err != nil {
+			return 0, 0, err
+		}
+		var header [16]byte
+		_, err = io.ReadFull(r, header[:8])
+		if err != nil {
+			return 0, 0, fmt.Errorf("read box header: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerLength := int64(8)
+		switch size {
+		case 0:
+			// extends to the end
+			size = end - pos
+		case 1:
+			_, err = io.ReadFull(r, header[8:16])
+			if err != nil {
+				return 0, 0, fmt.Errorf("read box header: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLength = 16
+		}
+		if size < headerLength {
+			return 0, 0, errors.New("invalid box size")
+		}
+		if string(header[4:8]) == typ {
+			return pos + headerLength, min(pos+size, end), nil
+		}
+		pos += size
+	}
+	return 0, 0, fmt.Errorf("no %s box", typ)
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// OggProber reads the first and last pages of Ogg Opus and Ogg Vorbis files to get the duration.
+type OggProber struct{}
+
+// oggPageHeaderLength is the length of the page header up to (not including) the segment table.
+const oggPageHeaderLength = 27
+
+type oggPage struct {
+	granule  int64
+	serial   uint32
+	segments []byte
+}
+
+func (p oggPage) bodyLength() int {
+	n := 0
+	for _, s := range p.segments {
+		n += int(s)
+	}
+	return n
+}
+
+// parseOggPage parses the page header at the start of b. ok is false if b does not start with a complete page header.
+func parseOggPage(b []byte) (p oggPage, ok bool) {
+	if len(b) < oggPageHeaderLength || !bytes.Equal(b[:4], []byte("OggS")) || b[4] != 0 {
+		return p, false
+	}
+	nSegments := int(b[26])
+	if len(b) < oggPageHeaderLength+nSegments {
+		return p, false
+	}
+	p.granule = int64(binary.LittleEndian.Uint64(b[6:14]))
+	p.serial = binary.LittleEndian.Uint32(b[14:18])
+	p.segments = b[oggPageHeaderLength : oggPageHeaderLength+nSegments]
+	return p, true
+}
+
+// ProbeDuration implements MediaProber.
+func (OggProber) ProbeDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return probeOggDuration(f)
+}
+
+func probeOggDuration(r io.ReadSeeker) (time.Duration, error) {
+	first := make([]byte, oggPageHeaderLength+255+255*255)
+	n, err := io.ReadFull(r, first)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("read first page: %w", err)
+	}
+	first = first[:n]
+	page, ok := parseOggPage(first)
+	if !ok {
+		return 0, errors.New("not an Ogg file")
+	}
+	packet := first[oggPageHeaderLength+len(page.segments):]
+	if len(packet) > page.bodyLength() {
+		packet = packet[:page.bodyLength()]
+	}
+
+	// The granule position counts samples at the given rate; for Opus, it includes the pre-skip.
+	var sampleRate, preSkip int64
+	switch {
+	case len(packet) >= 19 && bytes.Equal(packet[:8], []byte("OpusHead")):
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+	case len(packet) >= 16 && bytes.Equal(packet[:7], []byte("\x01vorbis")):
+		sampleRate = int64(binary.LittleEndian.Uint32(packet[12:16]))
+	default:
+		return 0, fmt.Errorf("unknown Ogg codec: %w", ErrUnsupportedMedia)
+	}
+	if sampleRate == 0 {
+		return 0, errors.New("invalid sample rate")
+	}
+
+	granule, err := lastOggGranule(r, page.serial)
+	if err != nil {
+		return 0, err
+	}
+	samples := granule - preSkip
+	if samples < 0 {
+		samples = 0
+	}
+	return samplesDuration(samples, sampleRate), nil
+}
+
+// lastOggGranule returns the granule position of the last page of the logical stream serial.
+// Pages are searched for backwards from the end of the file.
+func lastOggGranule(r io.ReadSeeker, serial uint32) (int64, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	const chunkSize = 64 * 1024
+	// Overlap chunks so that page headers crossing a chunk boundary are found.
+	const overlap = oggPageHeaderLength + 255
+	for chunkEnd := end; chunkEnd > 0; chunkEnd -= chunkSize - overlap {
+		chunkStart := max(chunkEnd-chunkSize, 0)
+		buf := make([]byte, chunkEnd-chunkStart)
+		_, err = r.Seek(chunkStart, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return 0, fmt.Errorf("read: %w", err)
+		}
+		for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+			page, ok := parseOggPage(buf[i:])
+			// Pages with granule -1 have no packet ending on them.
+			if ok && page.serial == serial && page.granule != -1 {
+				return page.granule, nil
+			}
+		}
+		if chunkStart == 0 {
+			break
+		}
+	}
+	return 0, errors.New("no page with a granule position")
+}
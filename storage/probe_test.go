@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// mp3Frame returns an MPEG-1 layer III, 128 kbit/s, 44.1 kHz, stereo frame with a zeroed body.
+func mp3Frame(padding bool) []byte {
+	h := mp3FrameHeader{version: 1, layer: 3, bitrate: 128000, sampleRate: 44100, padding: padding}
+	frame := make([]byte, h.frameLength())
+	frame[0] = 0xff
+	frame[1] = 0xfb
+	frame[2] = 0x90
+	if padding {
+		frame[2] |= 0x02
+	}
+	return frame
+}
+
+func TestProbeMP3CBR(t *testing.T) {
+	var buf bytes.Buffer
+	// ID3v2 tag with a 20-byte body
+	buf.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 20})
+	buf.Write(make([]byte, 20))
+	const frames = 1000
+	for i := 0; i < frames; i++ {
+		buf.Write(mp3Frame(i%2 == 0))
+	}
+	buf.WriteString("TAG")
+	buf.Write(make([]byte, 125))
+
+	got, err := probeMP3Duration(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := samplesDuration(frames*1152, 44100)
+	if got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestProbeMP3Xing(t *testing.T) {
+	var buf bytes.Buffer
+	header := mp3Frame(false)
+	copy(header[4+32:], "Xing")
+	binary.BigEndian.PutUint32(header[4+32+4:], 1)
+	binary.BigEndian.PutUint32(header[4+32+8:], 123456)
+	buf.Write(header)
+	// only a few frames are actually present; the Xing header wins
+	for i := 0; i < 10; i++ {
+		buf.Write(mp3Frame(false))
+	}
+
+	got, err := probeMP3Duration(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := samplesDuration(123456*1152, 44100)
+	if got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestProbeMP3Invalid(t *testing.T) {
+	_, err := probeMP3Duration(bytes.NewReader([]byte("not an mp3 file")))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestProbeAIFF(t *testing.T) {
+	var buf bytes.Buffer
+	comm := make([]byte, 18)
+	binary.BigEndian.PutUint16(comm[0:], 2)              // channels
+	binary.BigEndian.PutUint32(comm[2:], 48000*90+24000) // sample frames
+	binary.BigEndian.PutUint16(comm[6:], 16)             // sample size
+	// 48000 as 80-bit extended: exponent 16383+15, mantissa 48000<<48
+	binary.BigEndian.PutUint16(comm[8:], 16383+15)
+	binary.BigEndian.PutUint64(comm[10:], 48000<<48)
+
+	buf.WriteString("FORM")
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	buf.WriteString("AIFF")
+	// an odd-length chunk before COMM, which must be padded
+	buf.WriteString("NAME")
+	binary.Write(&buf, binary.BigEndian, uint32(3))
+	buf.WriteString("abc\x00")
+	buf.WriteString("COMM")
+	binary.Write(&buf, binary.BigEndian, uint32(len(comm)))
+	buf.Write(comm)
+
+	got, err := probeAIFFDuration(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 90*time.Second + 500*time.Millisecond
+	if got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestProbeWAV(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1))     // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(2))     // channels
+	binary.Write(&buf, binary.LittleEndian, uint32(16000)) // sample rate
+	binary.Write(&buf, binary.LittleEndian, uint32(16000*4))
+	binary.Write(&buf, binary.LittleEndian, uint16(4)) // block align
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(16000*4*3/2))
+	buf.Write(make([]byte, 16000*4*3/2))
+
+	got, err := probeWAVDuration(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 1500 * time.Millisecond; got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestProbeFLAC(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	buf.Write([]byte{0x80, 0, 0, 34}) // last block, STREAMINFO, length 34
+	info := make([]byte, 34)
+	sampleRate := 44100
+	totalSamples := sampleRate*60 + sampleRate/4
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate<<4) | 1<<1 // 2 channels
+	info[13] = 15<<4 | byte(totalSamples>>32)
+	binary.BigEndian.PutUint32(info[14:18], uint32(totalSamples))
+	buf.Write(info)
+
+	got, err := probeFLACDuration(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 60*time.Second + 250*time.Millisecond; got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+// oggPageBytes returns an Ogg page with a single packet.
+func oggPageBytes(granule int64, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("OggS")
+	buf.Write([]byte{0, 0})
+	binary.Write(&buf, binary.LittleEndian, granule)
+	binary.Write(&buf, binary.LittleEndian, uint32(1234)) // serial
+	binary.Write(&buf, binary.LittleEndian, uint32(0))    // sequence
+	binary.Write(&buf, binary.LittleEndian, uint32(0))    // CRC (not checked)
+	buf.WriteByte(1)
+	buf.WriteByte(byte(len(body)))
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func TestProbeOggOpus(t *testing.T) {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1 // version
+	head[9] = 1 // channels
+	binary.LittleEndian.PutUint16(head[10:12], 312)
+	var buf bytes.Buffer
+	buf.Write(oggPageBytes(0, head))
+	buf.Write(oggPageBytes(48000*10, make([]byte, 100)))
+	buf.Write(oggPageBytes(48000*20+312, make([]byte, 100)))
+	buf.Write(oggPageBytes(-1, make([]byte, 100)))
+
+	got, err := probeOggDuration(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 20 * time.Second; got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func mp4Box(typ string, body []byte) []byte {
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+func TestProbeMP4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], 1000)   // timescale
+	binary.BigEndian.PutUint32(mvhd[16:20], 123456) // duration
+	var buf bytes.Buffer
+	buf.Write(mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")))
+	buf.Write(mp4Box("mdat", make([]byte, 1000)))
+	buf.Write(mp4Box("moov", append(mp4Box("udta", nil), mp4Box("mvhd", mvhd)...)))
+
+	got, err := probeMP4Duration(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 123456 * time.Millisecond; got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestProbeWebM(t *testing.T) {
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(61500))
+	info := []byte{0x2a, 0xd7, 0xb1, 0x83, 0x0f, 0x42, 0x40} // TimecodeScale = 1000000
+	info = append(info, 0x44, 0x89, 0x88)                    // Duration, size 8
+	info = append(info, duration...)
+	var buf bytes.Buffer
+	buf.Write([]byte{0x1a, 0x45, 0xdf, 0xa3, 0x84, 0x42, 0x86, 0x81, 0x01})
+	buf.Write([]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) // Segment, unknown size
+	buf.Write([]byte{0xec, 0x82, 0x00, 0x00})                                                 // Void
+	buf.Write([]byte{0x15, 0x49, 0xa9, 0x66, 0x80 | byte(len(info))})
+	buf.Write(info)
+
+	got, err := probeWebMDuration(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 61500 * time.Millisecond; got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
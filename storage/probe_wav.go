@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// WAVProber reads the fmt and data chunks of RIFF WAVE files to get the duration.
+type WAVProber struct{}
+
+// ProbeDuration implements MediaProber.
+func (WAVProber) ProbeDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return probeWAVDuration(f)
+}
+
+func probeWAVDuration(r io.ReadSeeker) (time.Duration, error) {
+	var header [12]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, fmt.Errorf("read header: %w", err)
+	}
+	if !bytes.Equal(header[:4], []byte("RIFF")) || !bytes.Equal(header[8:], []byte("WAVE")) {
+		return 0, errors.New("not a WAVE file")
+	}
+	var sampleRate, blockAlign int64
+	for {
+		var chunkHeader [8]byte
+		_, err = io.ReadFull(r, chunkHeader[:])
+		if err == io.EOF {
+			return 0, errors.New("no data chunk")
+		} else if err != nil {
+			return 0, fmt.Errorf("read chunk header: %w", err)
+		}
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+		switch string(chunkHeader[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return 0, errors.New("fmt chunk too short")
+			}
+			var fmtChunk [16]byte
+			_, err = io.ReadFull(r, fmtChunk[:])
+			if err != nil {
+				return 0, fmt.Errorf("read fmt chunk: %w", err)
+			}
+			sampleRate = int64(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			blockAlign = int64(binary.LittleEndian.Uint16(fmtChunk[12:14]))
+			size -= 16
+		case "data":
+			if sampleRate == 0 || blockAlign == 0 {
+				return 0, errors.New("data chunk before fmt chunk")
+			}
+			if size == 0xffffffff {
+				// size unknown (e.g. the recorder was interrupted); assume the data extends to the end of the file
+				pos, err := r.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return 0, err
+				}
+				end, err := r.Seek(0, io.SeekEnd)
+				if err != nil {
+					return 0, err
+				}
+				size = end - pos
+			}
+			return samplesDuration(size/blockAlign, sampleRate), nil
+		}
+		// chunks are padded to an even length
+		_, err = r.Seek(size+size%2, io.SeekCurrent)
+		if err != nil {
+			return 0, fmt.Errorf("skip chunk: %w", err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// WebMProber reads the Duration element in the segment Info of WebM (Matroska) files.
+// Files written by live recorders (e.g. MediaRecorder) often have no duration; ErrUnsupportedMedia is returned for those.
+type WebMProber struct{}
+
+const (
+	ebmlIDHeader        = 0x1a45dfa3
+	ebmlIDSegment       = 0x18538067
+	ebmlIDInfo          = 0x1549a966
+	ebmlIDTimecodeScale = 0x2ad7b1
+	ebmlIDDuration      = 0x4489
+	ebmlIDCluster       = 0x1f43b675
+	// ebmlUnknownSize is returned by readEBMLSize for elements of unknown size.
+	ebmlUnknownSize = -1
+)
+
+// ProbeDuration implements MediaProber.
+func (WebMProber) ProbeDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return probeWebMDuration(f)
+}
+
+// readEBMLVint reads a variable-length integer. If keepMarker, the length marker bit is kept (as in element IDs).
+func readEBMLVint(r *bufio.Reader, keepMarker bool) (value int64, length int, err error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	length = 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		length++
+		if length > 8 {
+			return 0, 0, errors.New("invalid EBML variable-length integer")
+		}
+	}
+	if keepMarker {
+		value = int64(first)
+	} else {
+		value = int64(first & (0xff >> length))
+	}
+	allOnes := value == int64(0xff>>length)
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		value = value<<8 | int64(b)
+		allOnes = allOnes && b == 0xff
+	}
+	if !keepMarker && allOnes {
+		return ebmlUnknownSize, length, nil
+	}
+	return value, length, nil
+}
+
+func readEBMLElementHeader(r *bufio.Reader) (id, size int64, err error) {
+	id, _, err = readEBMLVint(r, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, _, err = readEBMLVint(r, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, size, nil
+}
+
+func probeWebMDuration(r io.Reader) (time.Duration, error) {
+	br := bufio.NewReader(r)
+	id, size, err := readEBMLElementHeader(br)
+	if err != nil {
+		return 0, fmt.Errorf("read EBML header: %w", err)
+	}
+	if id != ebmlIDHeader || size == ebmlUnknownSize {
+		return 0, errors.New("not a WebM file")
+	}
+	_, err = br.Discard(int(size))
+	if err != nil {
+		return 0, err
+	}
+	id, _, err = readEBMLElementHeader(br)
+	if err != nil {
+		return 0, fmt.Errorf("read segment header: %w", err)
+	}
+	if id != ebmlIDSegment {
+		return 0, errors.New("no segment")
+	}
+
+	// Look for Info among the children of Segment; it comes before the clusters.
+	for {
+		id, size, err = readEBMLElementHeader(br)
+		if err != nil {
+			return 0, fmt.Errorf("read element header: %w", err)
+		}
+		if id == ebmlIDInfo {
+			break
+		}
+		if id == ebmlIDCluster || size == ebmlUnknownSize {
+			return 0, fmt.Errorf("no segment info: %w", ErrUnsupportedMedia)
+		}
+		_, err = br.Discard(int(size))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if size == ebmlUnknownSize {
+		return 0, errors.New("segment info of unknown size")
+	}
+
+	timecodeScale := int64(1000000)
+	duration := math.NaN()
+	info := io.LimitReader(br, size)
+	ir := bufio.NewReader(info)
+	for {
+		id, size, err = readEBMLElementHeader(ir)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("read segment info: %w", err)
+		}
+		if size == ebmlUnknownSize || size > 8 && (id == ebmlIDTimecodeScale || id == ebmlIDDuration) {
+			return 0, errors.New("invalid segment info element")
+		}
+		switch id {
+		case ebmlIDTimecodeScale:
+			buf := make([]byte, 8)
+			_, err = io.ReadFull(ir, buf[8-size:])
+			if err != nil {
+				return 0, err
+			}
+			timecodeScale = int64(binary.BigEndian.Uint64(buf))
+		case ebmlIDDuration:
+			buf := make([]byte, size)
+			_, err = io.ReadFull(ir, buf)
+			if err != nil {
+				return 0, err
+			}
+			switch size {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(buf)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(buf))
+			default:
+				return 0, errors.New("invalid duration element")
+			}
+		default:
+			_, err = ir.Discard(int(size))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	if math.IsNaN(duration) {
+		return 0, fmt.Errorf("no duration in segment info: %w", ErrUnsupportedMedia)
+	}
+	return time.Duration(duration * float64(timecodeScale)), nil
+}
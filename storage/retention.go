@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// RetentionRule removes the media of samples older than a number of days.
+// The sample's row and other files (e.g. its transcript and summary) are kept, so it is still found by search.
+type RetentionRule struct {
+	// Name identifies the rule in the retention log.
+	Name          string `json:"name"`
+	OlderThanDays int    `json:"older_than_days"`
+	// KeepIfSummary and KeepIfTagged exempt samples with a summary or with any tag.
+	KeepIfSummary bool `json:"keep_if_summary"`
+	KeepIfTagged  bool `json:"keep_if_tagged"`
+	// KeepTags exempts samples with any of these tags.
+	KeepTags []string `json:"keep_tags"`
+}
+
+// applies reports whether the rule removes the media of sp at now.
+// sp.Tags must be set.
+func (r RetentionRule) applies(sp SamplePreview, now time.Time) bool {
+	if !sp.Start.Before(now.AddDate(0, 0, -r.OlderThanDays)) {
+		return false
+	}
+	if r.KeepIfSummary && sp.Summary != "" {
+		return false
+	}
+	if r.KeepIfTagged && len(sp.Tags) != 0 {
+		return false
+	}
+	for _, tag := range r.KeepTags {
+		if slices.Contains(sp.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseRetentionRules parses a JSON array of RetentionRule.
+func ParseRetentionRules(data []byte) ([]RetentionRule, error) {
+	var rules []RetentionRule
+	err := json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d: name must be set", i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+		if r.OlderThanDays <= 0 {
+			return nil, fmt.Errorf("rule %q: older_than_days must be positive", r.Name)
+		}
+		for j, tag := range r.KeepTags {
+			rules[i].KeepTags[j], err = NormalizeTag(tag)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// RetentionOptions configures ApplyRetention.
+type RetentionOptions struct {
+	// DryRun makes ApplyRetention return the report without removing anything.
+	DryRun bool
+}
+
+// RetentionReport lists the samples whose media was removed (or would be removed, for a dry run).
+type RetentionReport struct {
+	DryRun   bool              `json:"dry_run"`
+	Archived []RetentionAction `json:"archived"`
+}
+
+// RetentionAction is the removal of a sample's media by a rule.
+type RetentionAction struct {
+	SampleID string `json:"sample_id"`
+	Rule     string `json:"rule"`
+	// Names are the media files, relative to the samples directory.
+	Names []string `json:"names"`
+	Size  int64    `json:"size"`
+}
+
+// RetentionLogEntry is a media file removed by a rule.
+type RetentionLogEntry struct {
+	ID        int64     `db:"id"`
+	SampleID  string    `db:"sample_id"`
+	Rule      string    `db:"rule"`
+	Name      string    `db:"name"`
+	Size      int64     `db:"size"`
+	RemovedAt time.Time `db:"removed_at"`
+}
+
+// planRetention returns the samples whose media is removed by s.RetentionRules at now.
+// Each sample is removed by the first rule that applies to it.
+func (s *Storage) planRetention(ctx context.Context, now time.Time) ([]RetentionAction, error) {
+	actions := make([]RetentionAction, 0)
+	if len(s.RetentionRules) == 0 {
+		return actions, nil
+	}
+	err := s.ensureIndex()
+	if err != nil {
+		return nil, err
+	}
+	minDays := s.RetentionRules[0].OlderThanDays
+	for _, r := range s.RetentionRules {
+		minDays = min(minDays, r.OlderThanDays)
+	}
+	sps := make([]SamplePreview, 0)
+	err = s.DB.SelectContext(ctx, &sps, "SELECT * FROM samples WHERE deleted_at IS NULL AND archived_at IS NULL AND unixepoch(start) < ? ORDER BY unixepoch(start)", now.AddDate(0, 0, -minDays).Unix())
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	ids := make([]string, len(sps))
+	for i, sp := range sps {
+		ids[i] = sp.ID
+	}
+	tags, err := s.loadTags(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("load tags: %w", err)
+	}
+	for _, sp := range sps {
+		sp.Tags = tags[sp.ID]
+		i := slices.IndexFunc(s.RetentionRules, func(r RetentionRule) bool { return r.applies(sp, now) })
+		if i == -1 {
+			continue
+		}
+		names := s.index.media(sp.ID)
+		if len(names) == 0 {
+			continue
+		}
+		a := RetentionAction{SampleID: sp.ID, Rule: s.RetentionRules[i].Name, Names: names}
+		for _, name := range names {
+			info, err := os.Stat(s.samplePath(name))
+			if err != nil {
+				return nil, fmt.Errorf("stat %s: %w", name, err)
+			}
+			a.Size += info.Size()
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// ApplyRetention removes the media of samples that s.RetentionRules apply to, and records the removed files in the retention log.
+// The samples are marked as archived before their media is removed, so that syncs do not tombstone them.
+func (s *Storage) ApplyRetention(ctx context.Context, opts RetentionOptions) (RetentionReport, error) {
+	s.retentionMu.Lock()
+	defer s.retentionMu.Unlock()
+	now := time.Now()
+	actions, err := s.planRetention(ctx, now)
+	if err != nil {
+		return RetentionReport{}, err
+	}
+	report := RetentionReport{DryRun: opts.DryRun, Archived: actions}
+	if opts.DryRun || len(actions) == 0 {
+		return report, nil
+	}
+
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+	for _, a := range actions {
+		_, err = tx.ExecContext(ctx, "UPDATE samples SET archived_at=? WHERE id=?", now, a.SampleID)
+		if err != nil {
+			return report, fmt.Errorf("archive %s: %w", a.SampleID, err)
+		}
+		for _, name := range a.Names {
+			info, err := os.Stat(s.samplePath(name))
+			if err != nil {
+				return report, fmt.Errorf("stat %s: %w", name, err)
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO retention_log (sample_id, rule, name, size, removed_at) VALUES (?, ?, ?, ?, ?)", a.SampleID, a.Rule, name, info.Size(), now)
+			if err != nil {
+				return report, fmt.Errorf("log %s: %w", name, err)
+			}
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return report, err
+	}
+
+	var errs []error
+	names := make([]string, 0)
+	for _, a := range actions {
+		for _, name := range a.Names {
+			err = os.Remove(s.samplePath(name))
+			if err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	log.Printf("retention: archived %d samples.", len(actions))
+	err = s.SyncFileNames(ctx, names)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("sync: %w", err))
+	}
+	return report, errors.Join(errs...)
+}
+
+// RunRetention applies the retention rules every interval until ctx is done.
+func (s *Storage) RunRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := s.ApplyRetention(ctx, RetentionOptions{})
+		if err != nil {
+			log.Printf("retention: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RetentionLog returns the most recently removed files, up to limit.
+func (s *Storage) RetentionLog(ctx context.Context, limit int) ([]RetentionLogEntry, error) {
+	entries := make([]RetentionLogEntry, 0)
+	err := s.DB.SelectContext(ctx, &entries, "SELECT * FROM retention_log ORDER BY removed_at DESC, id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
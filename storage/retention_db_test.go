@@ -0,0 +1,101 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanRetentionTimezones(t *testing.T) {
+	s := newTestStorage(t)
+	s.RetentionRules = []RetentionRule{{Name: "old", OlderThanDays: 1}}
+	ctx := context.Background()
+	// starts at 2024-01-09T16:00:00Z, but is stored with a later date in its own offset
+	id := "2024-01-10T01:00:00+09:00"
+	err := os.WriteFile(filepath.Join(s.SamplesPath, id+".opus"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := s.planRetention(ctx, time.Date(2024, 1, 10, 17, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].SampleID != id {
+		t.Fatalf("expected the sample to be archived, got %+v", actions)
+	}
+	actions, err = s.planRetention(ctx, time.Date(2024, 1, 10, 15, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Fatalf("expected nothing to be archived, got %+v", actions)
+	}
+}
+
+func TestSyncArchivedSidecars(t *testing.T) {
+	s := newTestStorage(t)
+	s.RetentionRules = []RetentionRule{{Name: "old", OlderThanDays: 1}}
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x", id + "." + SummaryExt: "old summary"})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ApplyRetention(ctx, RetentionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeSampleFiles(t, s, map[string]string{
+		id + "." + SummaryExt:    "new summary",
+		id + "." + TranscriptExt: "WEBVTT\n\n00:00.000 --> 00:01.000\narchived words\n",
+		id + "." + MetadataExt:   `{"device": "recorder"}`,
+	})
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 1 || report.Updated[0] != id || len(report.Deleted) != 0 {
+		t.Fatalf("expected the archived sample to be updated, got %+v", report)
+	}
+	var row SamplePreview
+	err = s.DB.Get(&row, "SELECT id, summary, transcript, metadata, archived_at FROM samples WHERE id=?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Summary != "new summary" || row.Metadata.Device != "recorder" || row.ArchivedAt == nil {
+		t.Fatalf("expected the sidecars of the archived sample to be synced, got %+v", row)
+	}
+	cues, err := s.SampleCues(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 1 || cues[0].Text != "archived words" {
+		t.Fatalf("expected the transcript's cue, got %+v", cues)
+	}
+	sps, err := s.Search(SearchOptions{Query: "archived"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sps) != 1 || sps[0].ID != id {
+		t.Fatalf("expected the transcript to be searchable, got %+v", sps)
+	}
+
+	// The files are in the ledger now, so nothing is left to sync.
+	report, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 0 {
+		t.Fatalf("expected nothing to be updated, got %+v", report)
+	}
+}
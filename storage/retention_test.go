@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionRuleApplies(t *testing.T) {
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -200)
+	cases := []struct {
+		name    string
+		rule    RetentionRule
+		sample  SamplePreview
+		applies bool
+	}{
+		{"old", RetentionRule{OlderThanDays: 180}, SamplePreview{Start: old}, true},
+		{"recent", RetentionRule{OlderThanDays: 180}, SamplePreview{Start: now.AddDate(0, 0, -100)}, false},
+		{"summary kept", RetentionRule{OlderThanDays: 180, KeepIfSummary: true}, SamplePreview{Start: old, Summary: "a"}, false},
+		{"no summary", RetentionRule{OlderThanDays: 180, KeepIfSummary: true}, SamplePreview{Start: old}, true},
+		{"tagged kept", RetentionRule{OlderThanDays: 180, KeepIfTagged: true}, SamplePreview{Start: old, Tags: []string{"a"}}, false},
+		{"keep tag", RetentionRule{OlderThanDays: 180, KeepTags: []string{"keep"}}, SamplePreview{Start: old, Tags: []string{"a", "keep"}}, false},
+		{"other tag", RetentionRule{OlderThanDays: 180, KeepTags: []string{"keep"}}, SamplePreview{Start: old, Tags: []string{"a"}}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.rule.applies(c.sample, now); got != c.applies {
+				t.Fatalf("expected %t, got %t", c.applies, got)
+			}
+		})
+	}
+}
+
+func TestParseRetentionRules(t *testing.T) {
+	rules, err := ParseRetentionRules([]byte(`[{"name": "audio", "older_than_days": 365, "keep_tags": ["Keep"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].OlderThanDays != 365 || rules[0].KeepTags[0] != "keep" {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+	for _, data := range []string{
+		`[{"older_than_days": 1}]`,
+		`[{"name": "a", "older_than_days": 0}]`,
+		`[{"name": "a", "older_than_days": 1}, {"name": "a", "older_than_days": 2}]`,
+	} {
+		_, err = ParseRetentionRules([]byte(data))
+		if err == nil {
+			t.Fatalf("expected error for %s", data)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Fields of samples that have revisions.
+const (
+	RevisionSummary    = "summary"
+	RevisionTranscript = "transcript"
+)
+
+// Revision is a version of a sample's summary or transcript.
+// A revision is recorded each time the field changes, whether through the API, the web UI, or a sync from the sample's files.
+type Revision struct {
+	ID       int64  `db:"id"`
+	SampleID string `db:"sample_id"`
+	Field    string `db:"field"`
+	Body     string `db:"body"`
+	// Author is the GitHub login or API token hash that made the change, or empty if it came from the sample's files.
+	Author    string    `db:"author"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// recordRevision records a revision of a sample's field, unless it is the same as the latest one.
+// Empty fields are not recorded until they had a revision, so that samples without a summary or transcript have no history.
+func recordRevision(ctx context.Context, db sqlx.ExtContext, id, field, body, author string, at time.Time) error {
+	var latest string
+	err := sqlx.GetContext(ctx, db, &latest, "SELECT body FROM revisions WHERE sample_id=? AND field=? ORDER BY id DESC LIMIT 1", id, field)
+	if errors.Is(err, sql.ErrNoRows) {
+		if body == "" {
+			return nil
+		}
+	} else if err != nil {
+		return err
+	} else if latest == body {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO revisions (sample_id, field, body, author, created_at) VALUES (?, ?, ?, ?, ?)", id, field, body, author, at)
+	return err
+}
+
+// SampleRevisions returns the revisions of a sample's field, newest first.
+func (s *Storage) SampleRevisions(ctx context.Context, id, field string) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	err := s.DB.SelectContext(ctx, &revisions, "SELECT * FROM revisions WHERE sample_id=? AND field=? ORDER BY id DESC", id, field)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// ErrRevisionNotFound is returned for revisions that do not exist.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// RevisionGet returns a revision.
+func (s *Storage) RevisionGet(ctx context.Context, id int64) (Revision, error) {
+	var rev Revision
+	err := s.DB.GetContext(ctx, &rev, "SELECT * FROM revisions WHERE id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Revision{}, ErrRevisionNotFound
+	}
+	return rev, err
+}
+
+// RevisionRevert sets the revision's field of its sample back to the revision's body, which is recorded as a new revision by author.
+// Reverting a transcript removes the original it was converted from (see SampleTranscriptImport), as it no longer matches.
+// Like SampleTranscriptSet, reverting a transcript returns ErrNoMedia if the sample has no media.
+func (s *Storage) RevisionRevert(ctx context.Context, id int64, author string) (Revision, error) {
+	rev, err := s.RevisionGet(ctx, id)
+	if err != nil {
+		return Revision{}, err
+	}
+	switch rev.Field {
+	case RevisionSummary:
+		err = s.SampleSummarySet(rev.SampleID, rev.Body, author, ctx)
+	case RevisionTranscript:
+		// The original is only removed once the transcript is set, so that it is kept if setting it fails.
+		err = s.SampleTranscriptSet(rev.SampleID, rev.Body, author, ctx)
+		if err == nil {
+			err = s.setTranscriptOriginal(rev.SampleID, TranscriptVTT, nil)
+		}
+	default:
+		err = fmt.Errorf("unknown field %q", rev.Field)
+	}
+	return rev, err
+}
+
+// DiffOp is the kind of a DiffLine.
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = " "
+	DiffDelete DiffOp = "-"
+	DiffInsert DiffOp = "+"
+	// DiffSkip stands for unchanged lines left out of the diff; its Text says how many.
+	DiffSkip DiffOp = "…"
+)
+
+// DiffLine is a line of a diff.
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// maxDiffCells limits the size of the table used to diff the changed part of two texts (about 16 MB).
+// Larger changes (e.g. a re-transcription of a long sample) are shown as all of the old lines removed and all of the new lines added.
+const maxDiffCells = 1 << 22
+
+// DiffLines returns a line diff from a to b, with up to context unchanged lines around each change.
+func DiffLines(a, b string, context int) []DiffLine {
+	al := splitLines(a)
+	bl := splitLines(b)
+	// The common prefix and suffix are left out of the table.
+	prefix := 0
+	for prefix < len(al) && prefix < len(bl) && al[prefix] == bl[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(al)-prefix && suffix < len(bl)-prefix && al[len(al)-1-suffix] == bl[len(bl)-1-suffix] {
+		suffix++
+	}
+	diff := make([]DiffLine, 0, len(al)+len(bl))
+	for _, line := range al[:prefix] {
+		diff = append(diff, DiffLine{DiffEqual, line})
+	}
+	diff = append(diff, diffMiddle(al[prefix:len(al)-suffix], bl[prefix:len(bl)-suffix])...)
+	for _, line := range al[len(al)-suffix:] {
+		diff = append(diff, DiffLine{DiffEqual, line})
+	}
+	return collapseDiff(diff, context)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n"), "\n")
+}
+
+// diffMiddle diffs two lists of lines by their longest common subsequence.
+func diffMiddle(a, b []string) []DiffLine {
+	diff := make([]DiffLine, 0, len(a)+len(b))
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			diff = append(diff, DiffLine{DiffDelete, line})
+		}
+		for _, line := range b {
+			diff = append(diff, DiffLine{DiffInsert, line})
+		}
+		return diff
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	w := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{DiffEqual, a[i]})
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			diff = append(diff, DiffLine{DiffDelete, a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{DiffInsert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{DiffDelete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{DiffInsert, b[j]})
+	}
+	return diff
+}
+
+// collapseDiff replaces runs of unchanged lines further than context lines from a change with a DiffSkip line.
+func collapseDiff(diff []DiffLine, context int) []DiffLine {
+	keep := make([]bool, len(diff))
+	for i, line := range diff {
+		if line.Op == DiffEqual {
+			continue
+		}
+		for k := max(0, i-context); k <= min(len(diff)-1, i+context); k++ {
+			keep[k] = true
+		}
+	}
+	collapsed := make([]DiffLine, 0, len(diff))
+	skipped := 0
+	for i, line := range diff {
+		if keep[i] {
+			if skipped != 0 {
+				collapsed = append(collapsed, skipLine(skipped))
+				skipped = 0
+			}
+			collapsed = append(collapsed, line)
+		} else {
+			skipped++
+		}
+	}
+	if skipped != 0 {
+		collapsed = append(collapsed, skipLine(skipped))
+	}
+	return collapsed
+}
+
+func skipLine(n int) DiffLine {
+	if n == 1 {
+		return DiffLine{DiffSkip, "1 unchanged line"}
+	}
+	return DiffLine{DiffSkip, fmt.Sprintf("%d unchanged lines", n)}
+}
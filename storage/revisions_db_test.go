@@ -0,0 +1,170 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordRevision(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	steps := []struct {
+		body     string
+		recorded bool
+	}{
+		// empty fields have no history until they are set
+		{"", false},
+		{"a", true},
+		{"a", false},
+		{"b", true},
+		{"", true},
+		{"", false},
+	}
+	expected := make([]string, 0)
+	for _, step := range steps {
+		err := recordRevision(ctx, s.DB, id, RevisionSummary, step.body, "alice", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step.recorded {
+			expected = append([]string{step.body}, expected...)
+		}
+	}
+	revisions, err := s.SampleRevisions(ctx, id, RevisionSummary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != len(expected) {
+		t.Fatalf("expected %d revisions, got %+v", len(expected), revisions)
+	}
+	for i, rev := range revisions {
+		if rev.Body != expected[i] || rev.Author != "alice" {
+			t.Fatalf("revision %d: expected %q by alice, got %+v", i, expected[i], rev)
+		}
+	}
+	transcripts, err := s.SampleRevisions(ctx, id, RevisionTranscript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transcripts) != 0 {
+		t.Fatalf("expected fields to have separate histories, got %+v", transcripts)
+	}
+}
+
+func TestRevisionRevert(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	files := map[string]string{
+		id + ".opus": "x",
+		id + ".srt":  "1\n00:00:01,000 --> 00:00:02,000\nnew\n",
+	}
+	for name, body := range files {
+		err := os.WriteFile(filepath.Join(s.SamplesPath, name), []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SampleSummarySet(id, "old", "alice", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SampleSummarySet(id, "new", "alice", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nold\n"
+	err = s.SampleTranscriptSet(id, old, "alice", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.SampleTranscriptImport(ctx, id, TranscriptSRT, []byte(files[id+".srt"]), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{RevisionSummary, RevisionTranscript} {
+		revisions, err := s.SampleRevisions(ctx, id, field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 2 {
+			t.Fatalf("%s: expected 2 revisions, got %+v", field, revisions)
+		}
+		rev, err := s.RevisionRevert(ctx, revisions[1].ID, "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev.ID != revisions[1].ID {
+			t.Fatalf("%s: expected the reverted revision, got %+v", field, rev)
+		}
+		after, err := s.SampleRevisions(ctx, id, field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(after) != 3 || after[0].Body != revisions[1].Body || after[0].Author != "bob" {
+			t.Fatalf("%s: expected the revert to be recorded as a revision by bob, got %+v", field, after)
+		}
+	}
+	sp, err := s.SampleGet(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Summary != "old" || sp.Transcript != old {
+		t.Fatalf("expected the old summary and transcript, got %q and %q", sp.Summary, sp.Transcript)
+	}
+	if sp.TranscriptOriginal != "" {
+		t.Fatalf("expected the original to be removed, got %s", sp.TranscriptOriginal)
+	}
+	_, err = os.Stat(filepath.Join(s.SamplesPath, id+".srt"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected the original to be removed, got %v", err)
+	}
+
+	_, err = s.SampleTranscriptImport(ctx, id, TranscriptSRT, []byte(files[id+".srt"]), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range []string{"opus", TranscriptExt} {
+		err = os.Remove(filepath.Join(s.SamplesPath, id+"."+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := s.SampleRevisions(ctx, id, RevisionTranscript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.RevisionRevert(ctx, revisions[1].ID, "bob")
+	if !errors.Is(err, ErrNoMedia) {
+		t.Fatalf("expected ErrNoMedia for a sample without media, got %v", err)
+	}
+	_, err = os.Stat(filepath.Join(s.SamplesPath, id+".srt"))
+	if err != nil {
+		t.Fatalf("expected the original to be kept when the revert fails, got %v", err)
+	}
+
+	_, err = s.RevisionRevert(ctx, -1, "bob")
+	if !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("expected ErrRevisionNotFound, got %v", err)
+	}
+	_, err = s.SampleGet("2024-01-03T10:00:00+00:00")
+	if !errors.Is(err, ErrSampleNotFound) {
+		t.Fatalf("expected ErrSampleNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\nten\n"
+	expected := []DiffLine{
+		{DiffSkip, "2 unchanged lines"},
+		{DiffEqual, "3"},
+		{DiffEqual, "4"},
+		{DiffDelete, "5"},
+		{DiffInsert, "five"},
+		{DiffEqual, "6"},
+		{DiffEqual, "7"},
+		{DiffEqual, "8"},
+		{DiffEqual, "9"},
+		{DiffInsert, "ten"},
+	}
+	diff := DiffLines(a, b, 2)
+	if !slices.Equal(diff, expected) {
+		t.Fatalf("expected %v, got %v", expected, diff)
+	}
+
+	diff = DiffLines("", "a\nb", 3)
+	expected = []DiffLine{{DiffInsert, "a"}, {DiffInsert, "b"}}
+	if !slices.Equal(diff, expected) {
+		t.Fatalf("expected %v, got %v", expected, diff)
+	}
+
+	diff = DiffLines("a\nx\nb\ny\nc", "a\nb\nz\nc", 0)
+	expected = []DiffLine{
+		{DiffSkip, "1 unchanged line"},
+		{DiffDelete, "x"},
+		{DiffSkip, "1 unchanged line"},
+		{DiffDelete, "y"},
+		{DiffInsert, "z"},
+		{DiffSkip, "1 unchanged line"},
+	}
+	if !slices.Equal(diff, expected) {
+		t.Fatalf("expected %v, got %v", expected, diff)
+	}
+}
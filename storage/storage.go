@@ -3,11 +3,12 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -19,12 +20,49 @@ const TranscriptExt = "vtt"
 type Storage struct {
 	SamplesPath string
 	DB          *sqlx.DB
+	// FilenameParsers are tried in order to get the start time of a sample from its ID.
+	// Files of samples that no parser accepts are not synced.
+	FilenameParsers []FilenameParser
+	// FallbackProber is used for media without a native prober in MediaProbers, or when the native prober fails.
+	// If nil, only native probers are used.
+	FallbackProber MediaProber
+	// ProbeWorkers is the maximum number of media files probed concurrently during a dry run sync (other syncs queue JobProbe jobs).
+	// If zero or less, runtime.NumCPU() is used.
+	ProbeWorkers int
+	// MaxSyncDeletes is the maximum number of samples a sync deletes without SyncOptions.ConfirmDeletes.
+	// This protects against e.g. an unmounted network drive. If negative, there is no limit.
+	MaxSyncDeletes int
+	// Transcode configures RunTranscoder. If nil, media is not transcoded.
+	Transcode *TranscodeConfig
+	// UploadDir is a time layout (e.g. 2006/01/02) for the directory completed uploads are moved into, relative to the samples directory.
+	// It is formatted with the sample's start time. If empty, uploads are moved into the samples directory itself.
+	UploadDir string
+	// UploadTTL is how long unfinished uploads are kept before SweepUploads removes them. If zero or less, they are kept until cancelled.
+	UploadTTL time.Duration
+	// RetentionRules are applied in order by ApplyRetention. If empty, no media is removed.
+	RetentionRules []RetentionRule
+	index          *sampleIndex
+	// syncMu makes sure only one sync writes to the database at a time.
+	syncMu sync.Mutex
+	// ftsChecked is set once a full sync checked the full text search indexes (see checkFTS). It is guarded by syncMu.
+	ftsChecked bool
+	// uploadLocks maps upload IDs to mutexes, so that only one request writes to an upload at a time.
+	uploadLocks sync.Map
+	// retentionMu makes sure only one ApplyRetention runs at a time.
+	retentionMu sync.Mutex
+	// jobWake wakes up an idle RunJobs worker.
+	jobWake chan struct{}
 }
 
 func New(samplesPath string, db *sqlx.DB) *Storage {
 	return &Storage{
-		SamplesPath: samplesPath,
-		DB:          db,
+		SamplesPath:     samplesPath,
+		DB:              db,
+		FilenameParsers: DefaultFilenameParsers,
+		FallbackProber:  FFProbeProber{},
+		MaxSyncDeletes:  100,
+		index:           newSampleIndex(),
+		jobWake:         make(chan struct{}, 1),
 	}
 }
 
@@ -39,13 +77,36 @@ type SamplePreview struct {
 	End        *time.Time
 	Summary    string
 	Transcript string
-	Media      []string
+	// Media, TranscriptName and TranscriptOriginal are file names relative to the samples directory.
+	// TranscriptOriginal is the SRT or Whisper JSON file the transcript was converted from, if any.
+	Media              []string
+	TranscriptName     string `db:"-"`
+	TranscriptOriginal string `db:"-"`
+	// DeletedAt is set if the sample's files disappeared (the sample is tombstoned).
+	DeletedAt *time.Time     `db:"deleted_at"`
+	Metadata  SampleMetadata `db:"metadata"`
+	// MetadataText is the part of Metadata indexed for full text search.
+	MetadataText string `db:"metadata_text"`
+	// SummaryHash is the hash of the summary when the database and the summary file last agreed.
+	SummaryHash string `db:"summary_hash"`
+	// Tags is set by SampleGet and Search.
+	Tags []string `db:"-"`
+	// ArchivedAt is set if the sample's media was removed by a retention rule.
+	// Archived samples keep their rows (and other files), so they are still found by search.
+	ArchivedAt *time.Time `db:"archived_at"`
+	// SpeechDuration is the total duration of the sample's speech segments, or nil if its audio was not analyzed (yet).
+	SpeechDuration *time.Duration `db:"speech_duration"`
 }
 
 func (sp SamplePreview) SamplePreview_() SamplePreview {
 	return sp
 }
 
+// Silent reports whether the sample's audio was analyzed and no speech was detected.
+func (sp SamplePreview) Silent() bool {
+	return sp.SpeechDuration != nil && *sp.SpeechDuration == 0
+}
+
 func (sp SamplePreview) TimeRange() (start, end time.Time) {
 	if sp.End != nil {
 		return sp.Start, *sp.End
@@ -56,244 +117,109 @@ func (sp SamplePreview) TimeRange() (start, end time.Time) {
 type SamplePreviewWithSnippet struct {
 	SamplePreview
 	Snippet string `db:"snippet"`
+	// CueOffset is the start of the first transcript cue matching the query, if any.
+	CueOffset *time.Duration `db:"-"`
 }
 
 func (spws SamplePreviewWithSnippet) SamplePreview_() SamplePreview {
 	return spws.SamplePreview
 }
 
-func newSamplePreviewFromFilename(filename string) SamplePreview {
-	name := filename[:len(filename)-len(filepath.Ext(filename))]
-	sp := SamplePreview{ID: filename}
-	t, err := time.Parse("2006-01-02T15:04:05-07:00", name)
-	if err == nil {
-		sp.Start = t
-	}
-	return sp
-}
-
 func (s *Storage) newSamplePreviewFromID(id string) (SamplePreview, error) {
 	sp := SamplePreview{ID: id}
-	t, err := time.Parse("2006-01-02T15:04:05-07:00", id)
-	if err == nil {
+	if t, ok := s.parseStart(id); ok {
 		sp.Start = t
 	}
 
-	body, err := os.ReadFile(filepath.Join(s.SamplesPath, fmt.Sprintf("%s.%s", id, SummaryExt)))
-	if err != nil && !os.IsNotExist(err) {
+	err := s.ensureIndex()
+	if err != nil {
 		return sp, err
-	} else if err == nil {
-		sp.Summary = string(body)
 	}
 
-	body, err = os.ReadFile(filepath.Join(s.SamplesPath, fmt.Sprintf("%s.%s", id, TranscriptExt)))
-	if err != nil && !os.IsNotExist(err) {
-		return sp, err
-	} else if err == nil {
-		sp.Transcript = string(body)
+	if name, ok := s.index.get(id, SummaryExt); ok {
+		body, err := os.ReadFile(s.samplePath(name))
+		if err != nil && !os.IsNotExist(err) {
+			return sp, err
+		} else if err == nil {
+			sp.Summary = string(body)
+		}
 	}
 
-	entries, err := os.ReadDir(s.SamplesPath)
-	if err != nil {
-		return sp, err
-	}
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+	if name, ok := s.index.get(id, TranscriptExt); ok {
+		sp.TranscriptName = name
+		body, err := os.ReadFile(s.samplePath(name))
+		if err != nil && !os.IsNotExist(err) {
+			return sp, err
+		} else if err == nil {
+			sp.Transcript = string(body)
 		}
-		ext := filepath.Ext(entry.Name())
-		name := entry.Name()[:len(entry.Name())-len(ext)]
-		if len(ext) == 0 {
-			continue
-		}
-		if _, ok := MediaFileTypes[ext[1:]]; ok && name == id {
-			sp.Media = append(sp.Media, entry.Name())
+	}
+	sp.TranscriptOriginal, _ = s.SampleTranscriptOriginal(id)
+
+	if name, ok := s.index.get(id, MetadataExt); ok {
+		body, err := os.ReadFile(s.samplePath(name))
+		if err != nil && !os.IsNotExist(err) {
+			return sp, err
+		} else if err == nil {
+			sp.Metadata, err = ParseSampleMetadata(body)
+			if err != nil {
+				log.Printf("ignoring metadata in %s: %s", name, err)
+			}
+			sp.MetadataText = sp.Metadata.searchText()
 		}
 	}
 
+	sp.Media = s.index.media(id)
 	return sp, nil
 }
 
 // SamplePreviewList returns a list of SamplePreview structs from the samples directory.
 // This method does not access the SQL database.
 func (s *Storage) SamplePreviewList(ctx context.Context) ([]SamplePreview, error) {
-	entries, err := os.ReadDir(s.SamplesPath)
+	err := s.ensureIndex()
 	if err != nil {
 		return nil, err
 	}
-	sps := map[string]SamplePreview{}
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		ext := filepath.Ext(entry.Name())
-		id := entry.Name()[:len(entry.Name())-len(ext)]
-		if _, ok := sps[id]; ok {
-			continue
-		}
-		if len(ext) == 0 {
-			continue
-		}
-		if _, ok := MediaFileTypes[ext[1:]]; !ok {
-			continue
-		}
+	ids := s.index.ids()
+	sps := make([]SamplePreview, 0, len(ids))
+	for _, id := range ids {
 		sp, err := s.newSamplePreviewFromID(id)
 		if err != nil {
 			return nil, err
 		}
-		sps[id] = sp
+		sps = append(sps, sp)
 	}
-	sps2 := make([]SamplePreview, 0, len(sps))
-	for _, sp := range sps {
-		sps2 = append(sps2, sp)
-	}
-	return sps2, nil
+	return sps, nil
 }
 
+// ErrSampleNotFound is returned for samples without a row.
+var ErrSampleNotFound = errors.New("sample not found")
+
+// SampleGet returns a sample, or ErrSampleNotFound if it has no row.
 func (s *Storage) SampleGet(id string) (SamplePreview, error) {
 	sp, err := s.newSamplePreviewFromID(id)
 	if err != nil {
 		return SamplePreview{}, err
 	}
 	err = s.DB.Get(&sp, "SELECT * FROM samples WHERE id=?", id)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return SamplePreview{}, ErrSampleNotFound
+	} else if err != nil {
 		return SamplePreview{}, err
 	}
+	sp.Tags, err = s.SampleTags(context.Background(), id)
+	if err != nil {
+		return SamplePreview{}, fmt.Errorf("tags: %w", err)
+	}
 	return sp, nil
 }
 
-func (s *Storage) SampleTranscriptSet(id string, transcript string, ctx context.Context) error {
-	return os.WriteFile(filepath.Join(s.SamplesPath, fmt.Sprintf("%s%s", id, TranscriptExt)), []byte(transcript), 0644)
-}
-
-func (s *Storage) SampleSummarySet(id string, transcript string, ctx context.Context) error {
-	_, err := s.DB.ExecContext(ctx, "UPDATE samples SET summary=? WHERE id=?", transcript, id)
-	return err
-}
-
 func (s *Storage) SampleFiles(id string) ([]string, error) {
-	entries, err := os.ReadDir(s.SamplesPath)
+	err := s.ensureIndex()
 	if err != nil {
 		return nil, err
 	}
-	files := make([]string, 0)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		ext := filepath.Ext(entry.Name())
-		name := entry.Name()[:len(entry.Name())-len(ext)]
-		if name == id {
-			files = append(files, entry.Name())
-		}
-	}
-	return files, nil
-}
-
-func (s *Storage) setEnds(ctx context.Context) error {
-	log.Printf("setting end times...")
-	sps := make([]SamplePreview, 0)
-	err := s.DB.Select(&sps, "SELECT id, start, duration FROM samples WHERE end IS NULL")
-	if err != nil {
-		return fmt.Errorf("select: %w", err)
-	}
-	for _, sp := range sps {
-		_, err = s.DB.Exec("UPDATE samples SET end=? WHERE id=?", sp.Start.Add(sp.Duration), sp.ID)
-		if err != nil {
-			return fmt.Errorf("update: %w", err)
-		}
-	}
-	log.Printf("set end times for %d samples.", len(sps))
-	return nil
-}
-
-// SyncFiles syncs the SQL database with files in the samples directory.
-func (s *Storage) SyncFiles(ctx context.Context) error {
-	log.Print("reading samples directory...")
-	sps, err := s.SamplePreviewList(ctx)
-	if err != nil {
-		return err
-	}
-	log.Printf("syncing %d samples...", len(sps))
-	insertCount := 0
-	updateCount := 0
-	durationCount := 0
-	fsIDs := make([]string, 0, len(sps))
-	start := time.Now()
-	lastLog := start
-	for i, sp := range sps {
-		if time.Since(lastLog) > 5*time.Second {
-			perSP := time.Since(start) / time.Duration(i+1)
-			total := (perSP * time.Duration(len(sps)))
-			eta := start.Add(total)
-			log.Printf("syncing %d/%d ; %s to eta (%d inserted, %d updated, %d duration)", i, len(sps), time.Until(eta).Round(time.Second), insertCount, updateCount, durationCount)
-			lastLog = time.Now()
-		}
-
-		fsIDs = append(fsIDs, sp.ID)
-		var oldSP SamplePreview
-		err := s.DB.Get(&oldSP, "SELECT * FROM samples WHERE id=?", sp.ID)
-		if err != nil && err != sql.ErrNoRows {
-			return fmt.Errorf("select: %w", err)
-		}
-		if err == sql.ErrNoRows {
-			durationCount++
-			duration, err := getMediaDuration(filepath.Join(s.SamplesPath, sp.Media[0]))
-			if err != nil {
-				log.Printf("get media duration of %s failed: %s", sp.Media[0], err)
-			} else {
-				sp.Duration = duration
-			}
-			_, err = s.DB.Exec("INSERT INTO samples (id, start, duration, summary, transcript) VALUES (?, ?, ?, ?, ?)", sp.ID, sp.Start, sp.Duration, sp.Summary, sp.Transcript)
-			if err != nil {
-				return fmt.Errorf("insert: %w", err)
-			}
-			insertCount++
-		} else {
-			if oldSP.Duration == 0 && len(sp.Media) != 0 {
-				durationCount++
-				duration, err := getMediaDuration(filepath.Join(s.SamplesPath, sp.Media[0]))
-				if err != nil {
-					log.Printf("get media duration of %s failed: %s", sp.Media[0], err)
-				} else {
-					sp.Duration = duration
-				}
-			} else if oldSP.Duration != 0 {
-				sp.Duration = oldSP.Duration
-			}
-			// Summary is not given from samples directory, so ignore.
-			_, err = s.DB.Exec("UPDATE samples SET start=?, duration=?,  transcript=? WHERE id=?", sp.Start, sp.Duration, sp.Transcript, sp.ID)
-			if err != nil {
-				return fmt.Errorf("update: %w", err)
-			}
-			updateCount++
-		}
-	}
-	dbIDs := make([]string, 0, len(sps))
-	err = s.DB.Select(&dbIDs, "SELECT id FROM samples")
-	if err != nil {
-		return fmt.Errorf("select: %w", err)
-	}
-	notInFS := setMinus(dbIDs, fsIDs)
-	deleteCount := len(notInFS)
-	for _, id := range notInFS {
-		_, err = s.DB.Exec("DELETE FROM samples WHERE id=?", id)
-		if err != nil {
-			return fmt.Errorf("delete: %w", err)
-		}
-	}
-
-	log.Printf("synced %d samples, %d inserted, %d updated, %d deleted (%d new media duration).", len(sps), insertCount, updateCount, deleteCount, durationCount)
-	_, err = s.DB.Exec("INSERT INTO samples_fts(samples_fts) VALUES ('rebuild')")
-	if err != nil {
-		return fmt.Errorf("fts rebuild: %w", err)
-	}
-	log.Printf("rebuilt fts index.")
-	err = s.setEnds(ctx)
-	if err != nil {
-		return fmt.Errorf("set ends: %w", err)
-	}
-	return nil
+	return s.index.names(id), nil
 }
 
 // Returns a set minus b.
@@ -323,6 +249,15 @@ type SearchOptions struct {
 	Query                   string
 	StartAfter, StartBefore *time.Time
 	EndAfter, EndBefore     *time.Time
+	// Tags (normalized by NormalizeTag) limits results to samples with any of the tags, or all of them if TagsMatchAll.
+	Tags         []string
+	TagsMatchAll bool
+	// HideSilent excludes samples in which no speech was detected. Samples that were not analyzed are kept.
+	HideSilent bool
+	// Bounds limits results to samples whose metadata has a location within it.
+	Bounds *GeoBounds
+	// MinGain and MaxGain limit results to samples whose metadata has an input gain (in dB) in the range. Either may be nil.
+	MinGain, MaxGain *float64
 }
 
 func (so *SearchOptions) SetOverlap(start, end time.Time) {
@@ -335,6 +270,73 @@ func (so *SearchOptions) SetContained(start, end time.Time) {
 	so.EndBefore = &end
 }
 
+// filter returns the conditions (each starting with AND) for the time and tag options.
+// start and end are SQL expressions for the Unix start and end times, and sampleID for the sample ID.
+func (so SearchOptions) filter(start, end, sampleID string) (string, []interface{}, error) {
+	var query string
+	var args []interface{}
+	if so.StartAfter != nil {
+		query += "AND " + start + " >= ? "
+		args = append(args, so.StartAfter.Unix())
+	}
+	if so.StartBefore != nil {
+		query += "AND " + start + " <= ? "
+		args = append(args, so.StartBefore.Unix())
+	}
+	if so.EndAfter != nil {
+		query += "AND " + end + " >= ? "
+		args = append(args, so.EndAfter.Unix())
+	}
+	if so.EndBefore != nil {
+		query += "AND " + end + " <= ? "
+		args = append(args, so.EndBefore.Unix())
+	}
+	if len(so.Tags) != 0 {
+		tagQuery, tagArgs, err := sqlx.In("SELECT sample_id FROM sample_tags JOIN tags ON tags.id = sample_tags.tag_id WHERE tags.name IN (?) GROUP BY sample_id", so.Tags)
+		if err != nil {
+			return "", nil, err
+		}
+		if so.TagsMatchAll {
+			tagQuery += " HAVING COUNT(*) = ?"
+			tagArgs = append(tagArgs, len(so.Tags))
+		}
+		query += "AND " + sampleID + " IN (" + tagQuery + ") "
+		args = append(args, tagArgs...)
+	}
+	return query, args, nil
+}
+
+// metadataFilter returns the conditions (each starting with AND) for the metadata options.
+// Samples without the metadata an option is about are excluded by it.
+func (so SearchOptions) metadataFilter() (string, []interface{}, error) {
+	var query string
+	var args []interface{}
+	if so.Bounds != nil {
+		b := *so.Bounds
+		err := b.Validate()
+		if err != nil {
+			return "", nil, fmt.Errorf("bounds: %w", err)
+		}
+		query += "AND metadata->>'$.location.latitude' BETWEEN ? AND ? "
+		args = append(args, b.South, b.North)
+		if b.West <= b.East {
+			query += "AND metadata->>'$.location.longitude' BETWEEN ? AND ? "
+		} else {
+			query += "AND (metadata->>'$.location.longitude' >= ? OR metadata->>'$.location.longitude' <= ?) "
+		}
+		args = append(args, b.West, b.East)
+	}
+	if so.MinGain != nil {
+		query += "AND metadata->>'$.input_gain' >= ? "
+		args = append(args, *so.MinGain)
+	}
+	if so.MaxGain != nil {
+		query += "AND metadata->>'$.input_gain' <= ? "
+		args = append(args, *so.MaxGain)
+	}
+	return query, args, nil
+}
+
 func (s *Storage) Search(so SearchOptions, ctx context.Context) (sps []SamplePreviewWithSnippet, err error) {
 	var query string
 	var args []interface{}
@@ -350,28 +352,69 @@ SELECT * FROM samples JOIN (
 `
 		args = append(args, so.Query)
 	}
-	query += "WHERE TRUE "
-	if so.StartAfter != nil {
-		query += "AND unixepoch(start) >= ?"
-		args = append(args, so.StartAfter.Unix())
-	}
-	if so.StartBefore != nil {
-		query += "AND unixepoch(start) <= ?"
-		args = append(args, so.StartBefore.Unix())
+	where, whereArgs, err := so.filter("unixepoch(start)", "unixepoch(end)", "id")
+	if err != nil {
+		return nil, err
 	}
-	if so.EndAfter != nil {
-		query += "AND unixepoch(end) >= ?"
-		args = append(args, so.EndAfter.Unix())
+	query += "WHERE deleted_at IS NULL " + where
+	args = append(args, whereArgs...)
+	if so.HideSilent {
+		query += "AND (speech_duration IS NULL OR speech_duration > 0) "
 	}
-	if so.EndBefore != nil {
-		query += "AND unixepoch(end) <= ?"
-		args = append(args, so.EndBefore.Unix())
+	where, whereArgs, err = so.metadataFilter()
+	if err != nil {
+		return nil, err
 	}
+	query += where
+	args = append(args, whereArgs...)
 
 	sps = make([]SamplePreviewWithSnippet, 0)
-	err = s.DB.Select(&sps, query, args...)
+	err = s.DB.SelectContext(ctx, &sps, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	ids := make([]string, len(sps))
+	for i, sp := range sps {
+		ids[i] = sp.ID
+	}
+	tags, err := s.loadTags(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("tags: %w", err)
+	}
+	for i := range sps {
+		sps[i].Tags = tags[sps[i].ID]
+	}
+	if so.Query != "" {
+		offsets, err := s.cueOffsets(ctx, so.Query, ids)
+		if err != nil {
+			// e.g. the query filters by a column the cue index does not have
+			log.Printf("cue offsets of %q: %s", so.Query, err)
+		}
+		for i := range sps {
+			if d, ok := offsets[sps[i].ID]; ok {
+				sps[i].CueOffset = &d
+			}
+		}
+	}
 	return sps, nil
 }
+
+// cueOffsets returns the start of the first transcript cue matching query, by sample ID, for the samples with the given IDs.
+func (s *Storage) cueOffsets(ctx context.Context, query string, ids []string) (map[string]time.Duration, error) {
+	offsets := make(map[string]time.Duration)
+	for batch := range slices.Chunk(ids, syncBatchSize) {
+		q, args, err := sqlx.In("SELECT c.sample_id, MIN(c.start_offset) AS start_offset FROM transcript_cues c JOIN transcript_cues_fts f ON f.rowid = c.id WHERE c.sample_id IN (?) AND transcript_cues_fts MATCH ? GROUP BY c.sample_id", batch, query)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([]Cue, 0)
+		err = s.DB.SelectContext(ctx, &rows, s.DB.Rebind(q), args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range rows {
+			offsets[c.SampleID] = c.Start
+		}
+	}
+	return offsets, nil
+}
@@ -0,0 +1,98 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSearchCueOffsets(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	ids := []string{"2024-01-02T10:00:00+00:00", "2024-01-03T10:00:00+00:00"}
+	for _, id := range ids {
+		files := map[string]string{
+			id + ".opus": "x",
+			id + ".vtt":  "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nintro\n\n00:00:03.000 --> 00:00:04.000\nhello\n",
+		}
+		for name, body := range files {
+			err := os.WriteFile(filepath.Join(s.SamplesPath, name), []byte(body), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	so := SearchOptions{Query: "hello"}
+	before := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+	so.StartBefore = &before
+	sps, err := s.Search(so, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sps) != 1 || sps[0].ID != ids[0] {
+		t.Fatalf("expected only %s, got %+v", ids[0], sps)
+	}
+	if sps[0].CueOffset == nil || *sps[0].CueOffset != 3*time.Second {
+		t.Fatalf("expected a cue offset of 3s, got %v", sps[0].CueOffset)
+	}
+}
+
+func TestSearchMetadata(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	tokyo := "2024-01-02T10:00:00+00:00"
+	fiji := "2024-01-03T10:00:00+00:00"
+	none := "2024-01-04T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{
+		tokyo + ".opus":           "x",
+		tokyo + "." + MetadataExt: `{"input_gain": -3.5, "location": {"latitude": 35.6812, "longitude": 139.7671}}`,
+		fiji + ".opus":            "x",
+		fiji + "." + MetadataExt:  `{"input_gain": 6, "location": {"latitude": -17.7, "longitude": 178.1}}`,
+		none + ".opus":            "x",
+	})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gain := func(g float64) *float64 { return &g }
+	for _, c := range []struct {
+		name     string
+		so       SearchOptions
+		expected []string
+	}{
+		{"all", SearchOptions{}, []string{tokyo, fiji, none}},
+		{"bounds", SearchOptions{Bounds: &GeoBounds{South: 35, West: 139, North: 36, East: 140}}, []string{tokyo}},
+		{"bounds across the antimeridian", SearchOptions{Bounds: &GeoBounds{South: -20, West: 170, North: -10, East: -170}}, []string{fiji}},
+		{"minimum gain", SearchOptions{MinGain: gain(0)}, []string{fiji}},
+		{"gain range", SearchOptions{MinGain: gain(-5), MaxGain: gain(0)}, []string{tokyo}},
+	} {
+		sps, err := s.Search(c.so, ctx)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		ids := make([]string, len(sps))
+		for i, sp := range sps {
+			ids[i] = sp.ID
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, ids)
+		}
+	}
+
+	_, err = s.Search(SearchOptions{Bounds: &GeoBounds{South: 10, North: 0}}, ctx)
+	if err == nil {
+		t.Fatal("expected an error for invalid bounds")
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Summaries are kept both in the database and in <id>.txt files.
+// samples.summary_hash is the hash of the summary as of the last time both sides agreed (the base).
+// When a sync looks at a sample (e.g. because its summary file changed), it compares both sides with the base:
+//   - if only the file changed, the file is imported into the database;
+//   - if only the database changed, the database is exported to the file;
+//   - if both changed (to different summaries), the database summary is kept and a conflict is recorded, to be resolved from the UI.
+// Rows without a base (from before summaries were written back) are reconciled on the next full sync; their summaries are exported if there is no file.
+
+// summaryAction is what a sync does with a sample's summary.
+type summaryAction int
+
+const (
+	// summaryKeep keeps the database summary.
+	summaryKeep summaryAction = iota
+	// summaryImport writes the file summary to the database.
+	summaryImport
+	// summaryExport writes the database summary to the file.
+	summaryExport
+	// summaryConflict keeps the database summary and records a conflict.
+	summaryConflict
+)
+
+// SummaryConflict is a summary that was changed both in the database and in its file.
+type SummaryConflict struct {
+	SampleID    string    `db:"sample_id"`
+	DBSummary   string    `db:"db_summary"`
+	FileSummary string    `db:"file_summary"`
+	DetectedAt  time.Time `db:"detected_at"`
+}
+
+func summaryHash(summary string) string {
+	h := sha256.Sum256([]byte(summary))
+	return hex.EncodeToString(h[:])
+}
+
+// reconcileSummary decides what to do with the summary of a sample with media.
+// it.Sample.Summary is the file summary (empty if there is no file); old is the sample's row, if exists.
+// Afterwards, it.Sample.Summary and it.SummaryHash are what is written to the database.
+func (it *syncItem) reconcileSummary(old SamplePreview, exists, hasFile bool) {
+	file := it.Sample.Summary
+	it.FileSummary = file
+	if !exists {
+		it.SummaryAction = summaryImport
+		it.SummaryHash = summaryHash(file)
+		return
+	}
+	db := old.Summary
+	base := old.SummaryHash
+	it.Sample.Summary = db
+	it.SummaryHash = base
+	switch {
+	case base != "" && !it.SummaryChanged && summaryHash(db) == base:
+		// Neither side changed. The database may have changed without the file (e.g. while the sample had no media), so it is compared too.
+		it.SummaryAction = summaryKeep
+	case file == db:
+		it.SummaryAction = summaryKeep
+		it.SummaryHash = summaryHash(db)
+	case base == "" && !hasFile:
+		it.SummaryAction = summaryExport
+		it.SummaryHash = summaryHash(db)
+	case base == "":
+		// There is no base to tell which side changed.
+		it.SummaryAction = summaryConflict
+	case summaryHash(db) == base:
+		it.SummaryAction = summaryImport
+		it.Sample.Summary = file
+		it.SummaryHash = summaryHash(file)
+	case summaryHash(file) == base:
+		it.SummaryAction = summaryExport
+		it.SummaryHash = summaryHash(db)
+	default:
+		it.SummaryAction = summaryConflict
+	}
+}
+
+// sidecarName returns the name of the sample's file with the given extension.
+// If there is no such file, the name is next to the media. ok is false if the sample has no media.
+func (s *Storage) sidecarName(id, ext string) (name string, ok bool) {
+	name, ok = s.index.get(id, ext)
+	if ok {
+		return name, true
+	}
+	media := s.index.media(id)
+	if len(media) == 0 {
+		return "", false
+	}
+	return path.Join(path.Dir(media[0]), id+"."+ext), true
+}
+
+// SampleSummarySet sets the summary in the database and the sample's summary file, and resolves any conflict.
+// If the sample has neither media (e.g. it is tombstoned) nor a summary file, only the database is updated, and summary_hash is kept;
+// the next sync that sees the sample's media sees that the database changed, and exports the summary to the file.
+// A revision is recorded with author (see Revision).
+func (s *Storage) SampleSummarySet(id string, summary string, author string, ctx context.Context) error {
+	err := s.ensureIndex()
+	if err != nil {
+		return err
+	}
+	name, ok := s.sidecarName(id, SummaryExt)
+	if !ok {
+		s.syncMu.Lock()
+		defer s.syncMu.Unlock()
+		return s.setSummaryDB(ctx, id, summary, author)
+	}
+
+	s.syncMu.Lock()
+	err = s.setSummary(ctx, id, name, summary, author)
+	s.syncMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.SyncFileNames(ctx, []string{name})
+}
+
+// setSummaryDB sets the summary of a sample without a summary file. It returns ErrSampleNotFound if the sample has no row.
+func (s *Storage) setSummaryDB(ctx context.Context, id, summary, author string) error {
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "UPDATE samples SET summary=? WHERE id=?", summary, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrSampleNotFound
+	}
+	err = recordRevision(ctx, tx, id, RevisionSummary, summary, author, time.Now())
+	if err != nil {
+		return fmt.Errorf("revision: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) setSummary(ctx context.Context, id, name, summary, author string) error {
+	err := s.writeFileAtomic(name, []byte(summary))
+	if err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "UPDATE samples SET summary=?, summary_hash=? WHERE id=?", summary, summaryHash(summary), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM summary_conflicts WHERE sample_id=?", id)
+	if err != nil {
+		return err
+	}
+	err = recordRevision(ctx, tx, id, RevisionSummary, summary, author, time.Now())
+	if err != nil {
+		return fmt.Errorf("revision: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SummaryConflicts returns the unresolved summary conflicts, oldest first.
+func (s *Storage) SummaryConflicts(ctx context.Context) ([]SummaryConflict, error) {
+	conflicts := make([]SummaryConflict, 0)
+	err := s.DB.SelectContext(ctx, &conflicts, "SELECT * FROM summary_conflicts ORDER BY detected_at")
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import "testing"
+
+func TestReconcileSummary(t *testing.T) {
+	cases := []struct {
+		name           string
+		db, base, file string
+		hasFile        bool
+		changed        bool
+		action         summaryAction
+		summary        string
+	}{
+		{"unchanged", "a", summaryHash("a"), "a", true, false, summaryKeep, "a"},
+		{"file changed", "a", summaryHash("a"), "b", true, true, summaryImport, "b"},
+		{"file removed", "a", summaryHash("a"), "", false, true, summaryImport, ""},
+		{"db changed", "b", summaryHash("a"), "a", true, true, summaryExport, "b"},
+		{"both changed", "b", summaryHash("a"), "c", true, true, summaryConflict, "b"},
+		{"both changed the same", "b", summaryHash("a"), "b", true, true, summaryKeep, "b"},
+		// e.g. the summary was set while the sample had no media
+		{"db changed without file event", "b", summaryHash("a"), "a", true, false, summaryExport, "b"},
+		{"db changed without file", "b", summaryHash(""), "", false, false, summaryExport, "b"},
+		{"unresolved conflict", "b", summaryHash("a"), "c", true, false, summaryConflict, "b"},
+		{"legacy without file", "a", "", "", false, false, summaryExport, "a"},
+		{"legacy agreeing", "a", "", "a", true, false, summaryKeep, "a"},
+		{"legacy differing", "a", "", "b", true, false, summaryConflict, "a"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			it := &syncItem{SummaryChanged: c.changed, Sample: SamplePreview{Summary: c.file}}
+			it.reconcileSummary(SamplePreview{Summary: c.db, SummaryHash: c.base}, true, c.hasFile)
+			if it.SummaryAction != c.action {
+				t.Fatalf("expected action %d, got %d", c.action, it.SummaryAction)
+			}
+			if it.Sample.Summary != c.summary {
+				t.Fatalf("expected summary %q, got %q", c.summary, it.Sample.Summary)
+			}
+			if c.action != summaryConflict && it.SummaryHash != summaryHash(c.summary) {
+				t.Fatalf("expected the hash of %q", c.summary)
+			}
+		})
+	}
+}
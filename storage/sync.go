@@ -0,0 +1,651 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// syncBatchSize is the number of samples written per transaction.
+const syncBatchSize = 500
+
+// syncItem is the change to a single sample (and its ledger records) made by a sync.
+type syncItem struct {
+	ID string
+	// Changed is false if only the ledger needs updating (files were touched, but their contents did not change).
+	Changed        bool
+	MediaChanged   bool
+	SummaryChanged bool
+	// Sample is read from the samples directory. It has no media if the row is to be deleted.
+	Sample SamplePreview
+	// Exists is true if the sample has a row; Tombstoned is true if that row is soft-deleted.
+	// Archived is true if the sample's media was removed by a retention rule.
+	Exists     bool
+	Tombstoned bool
+	Archived   bool
+	Insert     bool
+	Probe      bool
+	// ProbeErr is set if Probe is true and probing failed (only for dry runs; otherwise, a JobProbe job probes the media).
+	ProbeErr error
+	// SummaryAction, SummaryHash and FileSummary are set by reconcileSummary.
+	SummaryAction summaryAction
+	SummaryHash   string
+	FileSummary   string
+	upserts       []fileRecord
+	removed       []fileRecord
+}
+
+// delete reports whether the sample's row is tombstoned.
+// Archived samples have no media on purpose, so they are kept.
+func (it *syncItem) delete() bool {
+	return it.Changed && it.Exists && !it.Tombstoned && !it.Archived && len(it.Sample.Media) == 0
+}
+
+// upsert reports whether the sample's row is inserted or updated.
+func (it *syncItem) upsert() bool {
+	return it.Changed && len(it.Sample.Media) != 0
+}
+
+// updateArchived reports whether the summary, transcript and metadata of an archived sample's row are updated from its remaining files.
+func (it *syncItem) updateArchived() bool {
+	return it.Changed && it.Archived && !it.Tombstoned && len(it.Sample.Media) == 0
+}
+
+// ErrTooManyDeletes is returned by Sync when it would delete more than Storage.MaxSyncDeletes samples without SyncOptions.ConfirmDeletes.
+var ErrTooManyDeletes = errors.New("too many samples would be deleted")
+
+// SyncOptions configures a sync.
+type SyncOptions struct {
+	// DryRun makes Sync return the report without writing to the database.
+	DryRun bool
+	// ConfirmDeletes allows Sync to delete more than Storage.MaxSyncDeletes samples.
+	ConfirmDeletes bool
+}
+
+// SyncReport lists the changes made by a sync (or that would be made, for a dry run).
+type SyncReport struct {
+	DryRun   bool     `json:"dry_run"`
+	Inserted []string `json:"inserted"`
+	Updated  []string `json:"updated"`
+	// Deleted lists the samples that are tombstoned because their media disappeared.
+	Deleted []string `json:"deleted"`
+	// Restored lists the tombstoned samples whose media reappeared.
+	Restored []string `json:"restored"`
+	// SummariesImported and SummariesExported list the samples whose summaries were copied from their files to the database and vice versa.
+	SummariesImported []string `json:"summaries_imported"`
+	SummariesExported []string `json:"summaries_exported"`
+	// SummaryConflicts lists the samples whose summaries changed both in the database and in their files.
+	SummaryConflicts []string `json:"summary_conflicts"`
+	// Unparseable lists the sample files that are ignored because no FilenameParser accepts them.
+	// ProbeFailures lists the media that could not be probed by a dry run; other syncs queue JobProbe jobs for the samples in ProbeQueued.
+	Unparseable   []string       `json:"unparseable"`
+	ProbeFailures []ProbeFailure `json:"probe_failures"`
+	ProbeQueued   []string       `json:"probe_queued"`
+}
+
+// ProbeFailure is a media file whose duration could not be probed.
+type ProbeFailure struct {
+	SampleID string `json:"sample_id"`
+	Name     string `json:"name"`
+	Error    string `json:"error"`
+}
+
+func newSyncReport(items []*syncItem, dryRun bool) SyncReport {
+	r := SyncReport{
+		DryRun:            dryRun,
+		Inserted:          make([]string, 0),
+		Updated:           make([]string, 0),
+		Deleted:           make([]string, 0),
+		Restored:          make([]string, 0),
+		SummariesImported: make([]string, 0),
+		SummariesExported: make([]string, 0),
+		SummaryConflicts:  make([]string, 0),
+		Unparseable:       make([]string, 0),
+		ProbeFailures:     make([]ProbeFailure, 0),
+		ProbeQueued:       make([]string, 0),
+	}
+	for _, it := range items {
+		switch {
+		case it.delete():
+			r.Deleted = append(r.Deleted, it.ID)
+		case it.upsert() && it.Insert:
+			r.Inserted = append(r.Inserted, it.ID)
+		case it.upsert() && it.Tombstoned:
+			r.Restored = append(r.Restored, it.ID)
+		case it.upsert(), it.updateArchived():
+			r.Updated = append(r.Updated, it.ID)
+		}
+		if (it.upsert() && !it.Insert) || it.updateArchived() {
+			switch it.SummaryAction {
+			case summaryImport:
+				r.SummariesImported = append(r.SummariesImported, it.ID)
+			case summaryExport:
+				r.SummariesExported = append(r.SummariesExported, it.ID)
+			case summaryConflict:
+				r.SummaryConflicts = append(r.SummaryConflicts, it.ID)
+			}
+		}
+		if it.upsert() && it.Probe && !dryRun {
+			r.ProbeQueued = append(r.ProbeQueued, it.ID)
+		}
+		if it.ProbeErr != nil {
+			r.ProbeFailures = append(r.ProbeFailures, ProbeFailure{
+				SampleID: it.ID,
+				Name:     it.Sample.Media[0],
+				Error:    it.ProbeErr.Error(),
+			})
+		}
+	}
+	return r
+}
+
+// SyncFiles syncs the SQL database with files in the samples directory.
+// Only samples whose files changed since the last sync (according to the files table) are read and written.
+// Only one sync runs at a time; concurrent calls wait for each other.
+func (s *Storage) SyncFiles(ctx context.Context) error {
+	_, err := s.Sync(ctx, SyncOptions{})
+	return err
+}
+
+// SyncFileNames is like SyncFiles, but only looks at the given files (relative to the samples directory).
+// Files that do not exist anymore are removed from the database.
+func (s *Storage) SyncFileNames(ctx context.Context, names []string) error {
+	_, err := s.syncFiles(ctx, names, SyncOptions{})
+	return err
+}
+
+// Sync is like SyncFiles, but returns a report of the changes.
+// If the sync would delete more than s.MaxSyncDeletes samples and opts.ConfirmDeletes is false, nothing is written, and the report is returned with ErrTooManyDeletes.
+func (s *Storage) Sync(ctx context.Context, opts SyncOptions) (SyncReport, error) {
+	return s.syncFiles(ctx, nil, opts)
+}
+
+// syncFiles syncs the given files, or all files in the samples directory if names is nil.
+func (s *Storage) syncFiles(ctx context.Context, names []string, opts SyncOptions) (SyncReport, error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	items, err := s.planSync(ctx, names)
+	if err != nil {
+		return SyncReport{}, err
+	}
+	if opts.DryRun {
+		s.probeItems(items)
+	}
+	report := newSyncReport(items, opts.DryRun)
+	report.Unparseable, err = s.UnparseableFiles()
+	if err != nil {
+		return report, err
+	}
+	if s.MaxSyncDeletes >= 0 && len(report.Deleted) > s.MaxSyncDeletes && !opts.ConfirmDeletes {
+		return report, fmt.Errorf("%w (%d > %d)", ErrTooManyDeletes, len(report.Deleted), s.MaxSyncDeletes)
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+	if len(items) != 0 {
+		err = s.applySync(ctx, items)
+		if err != nil {
+			return report, err
+		}
+	}
+	if names == nil && !s.ftsChecked {
+		err = s.checkFTS(ctx)
+		if err != nil {
+			return report, fmt.Errorf("check fts: %w", err)
+		}
+		s.ftsChecked = true
+	}
+	return report, nil
+}
+
+// planSync compares the samples directory with the ledger, and reads the samples that changed.
+// It does not write to the database.
+func (s *Storage) planSync(ctx context.Context, names []string) ([]*syncItem, error) {
+	var scanned []fileRecord
+	var unparseable []string
+	var err error
+	if names == nil {
+		scanned, unparseable, err = s.scanSamplesDir()
+	} else {
+		scanned, unparseable, err = s.statSampleFiles(names)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read samples directory: %w", err)
+	}
+	if names == nil {
+		unparseable = s.index.reset(scanned, unparseable)
+	} else {
+		unparseable = s.index.update(names, scanned, unparseable)
+	}
+	for _, name := range unparseable {
+		log.Printf("ignoring %s: no filename parser accepts the sample ID", name)
+	}
+	ledger, err := s.loadLedger(ctx, names)
+	if err != nil {
+		return nil, fmt.Errorf("load ledger: %w", err)
+	}
+
+	itemsByID := map[string]*syncItem{}
+	item := func(id string) *syncItem {
+		it, ok := itemsByID[id]
+		if !ok {
+			it = &syncItem{ID: id}
+			itemsByID[id] = it
+		}
+		return it
+	}
+	markChanged := func(r fileRecord) {
+		it := item(r.SampleID)
+		it.Changed = true
+		it.MediaChanged = it.MediaChanged || isMediaFile(r.Name)
+		it.SummaryChanged = it.SummaryChanged || path.Ext(r.Name) == "."+SummaryExt
+	}
+	fsIDs := make([]string, 0, len(scanned))
+	for _, r := range scanned {
+		if !isSampleFile(r.Name) {
+			// transcript originals are only indexed
+			continue
+		}
+		if isMediaFile(r.Name) {
+			fsIDs = append(fsIDs, r.SampleID)
+		}
+		old, ok := ledger[r.Name]
+		delete(ledger, r.Name)
+		if ok && old.sameStat(r) {
+			continue
+		}
+		r.Hash, err = hashFile(s.samplePath(r.Name))
+		if os.IsNotExist(err) {
+			// removed since the scan; the next sync will notice
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("hash %s: %w", r.Name, err)
+		}
+		it := item(r.SampleID)
+		it.upserts = append(it.upserts, r)
+		if ok && old.Hash == r.Hash {
+			// only touched
+			continue
+		}
+		markChanged(r)
+	}
+	for _, r := range ledger {
+		it := item(r.SampleID)
+		it.removed = append(it.removed, r)
+		markChanged(r)
+	}
+
+	unanalyzed := map[string]bool{}
+	if names == nil {
+		// Rows without any media (e.g. from before the ledger existed) are also deleted.
+		dbIDs := make([]string, 0)
+		err = s.DB.SelectContext(ctx, &dbIDs, "SELECT id FROM samples WHERE deleted_at IS NULL AND archived_at IS NULL")
+		if err != nil {
+			return nil, fmt.Errorf("select: %w", err)
+		}
+		for _, id := range setMinus(dbIDs, fsIDs) {
+			item(id).Changed = true
+		}
+
+		// Summaries of rows from before summaries were written back are reconciled.
+		// Those already in conflict stay as they are until the conflict is resolved (or their files change).
+		legacyIDs := make([]string, 0)
+		err = s.DB.SelectContext(ctx, &legacyIDs, "SELECT id FROM samples WHERE summary_hash='' AND deleted_at IS NULL AND archived_at IS NULL AND id NOT IN (SELECT sample_id FROM summary_conflicts)")
+		if err != nil {
+			return nil, fmt.Errorf("select: %w", err)
+		}
+		for _, id := range legacyIDs {
+			item(id).Changed = true
+		}
+
+		// Peaks and speech of samples from before they were computed are computed.
+		// Samples whose audio could not be decoded, or that have a probe job (including a failed one), are not probed again until their media changes.
+		unanalyzedIDs := make([]string, 0)
+		err = s.DB.SelectContext(ctx, &unanalyzedIDs, "SELECT id FROM samples WHERE deleted_at IS NULL AND archived_at IS NULL AND speech_duration IS NULL AND id NOT IN (SELECT sample_id FROM peaks WHERE error != '') AND id NOT IN (SELECT payload->>'sample_id' FROM jobs WHERE kind=?)", JobProbe)
+		if err != nil {
+			return nil, fmt.Errorf("select: %w", err)
+		}
+		for _, id := range unanalyzedIDs {
+			item(id).Changed = true
+			unanalyzed[id] = true
+		}
+
+		// Cues of transcripts from before cues were stored are stored.
+		// Transcripts without any valid cues are skipped, as they would have none after the sync either.
+		uncued := make([]SamplePreview, 0)
+		err = s.DB.SelectContext(ctx, &uncued, "SELECT id, transcript FROM samples WHERE deleted_at IS NULL AND archived_at IS NULL AND transcript != '' AND id NOT IN (SELECT sample_id FROM transcript_cues)")
+		if err != nil {
+			return nil, fmt.Errorf("select: %w", err)
+		}
+		for _, sp := range uncued {
+			cues, _ := ParseVTT(sp.Transcript)
+			if len(cues) > 0 {
+				item(sp.ID).Changed = true
+			}
+		}
+	}
+
+	items := make([]*syncItem, 0, len(itemsByID))
+	changedIDs := make([]string, 0)
+	for _, it := range itemsByID {
+		items = append(items, it)
+		if it.Changed {
+			changedIDs = append(changedIDs, it.ID)
+		}
+	}
+	slices.SortFunc(items, func(a, b *syncItem) int { return cmp.Compare(a.ID, b.ID) })
+
+	oldRows, err := s.loadRows(ctx, changedIDs)
+	if err != nil {
+		return nil, fmt.Errorf("load rows: %w", err)
+	}
+	for _, it := range items {
+		if !it.Changed {
+			continue
+		}
+		it.Sample, err = s.newSamplePreviewFromID(it.ID)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", it.ID, err)
+		}
+		old, ok := oldRows[it.ID]
+		it.Exists = ok
+		it.Tombstoned = ok && old.DeletedAt != nil
+		it.Archived = ok && old.ArchivedAt != nil
+		_, hasSummaryFile := s.index.get(it.ID, SummaryExt)
+		if it.updateArchived() {
+			it.reconcileSummary(old, ok, hasSummaryFile)
+			if it.SummaryAction == summaryExport && !hasSummaryFile {
+				// There is nowhere to write the file, as with SampleSummarySet.
+				it.SummaryAction = summaryKeep
+				it.SummaryHash = old.SummaryHash
+			}
+			continue
+		}
+		if len(it.Sample.Media) == 0 {
+			continue
+		}
+		it.Insert = !ok
+		it.reconcileSummary(old, ok, hasSummaryFile)
+		// The media is probed (and its audio analyzed) if the sample is new, its media changed, or it has no duration or analysis yet.
+		// Until then, the old duration is kept.
+		it.Sample.Duration = old.Duration
+		it.Probe = it.Insert || it.MediaChanged || old.Duration == 0 || unanalyzed[it.ID]
+	}
+	return items, nil
+}
+
+// loadRows returns the IDs, durations, deletion and archival times and summaries of the samples with the given IDs that have rows (including tombstoned ones).
+func (s *Storage) loadRows(ctx context.Context, ids []string) (map[string]SamplePreview, error) {
+	sps := make(map[string]SamplePreview, len(ids))
+	for batch := range slices.Chunk(ids, syncBatchSize) {
+		query, args, err := sqlx.In("SELECT id, duration, deleted_at, archived_at, summary, summary_hash FROM samples WHERE id IN (?)", batch)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([]SamplePreview, 0, len(batch))
+		err = s.DB.SelectContext(ctx, &rows, s.DB.Rebind(query), args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, sp := range rows {
+			sps[sp.ID] = sp
+		}
+	}
+	return sps, nil
+}
+
+// probeItems probes the media durations of items that need it, using up to s.ProbeWorkers goroutines.
+func (s *Storage) probeItems(items []*syncItem) {
+	toProbe := make([]*syncItem, 0)
+	for _, it := range items {
+		if it.Probe {
+			toProbe = append(toProbe, it)
+		}
+	}
+	if len(toProbe) == 0 {
+		return
+	}
+	workers := s.ProbeWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	log.Printf("probing %d media durations with %d workers...", len(toProbe), workers)
+	ch := make(chan *syncItem)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	start := time.Now()
+	lastLog := start
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range ch {
+				d, err := s.probeDuration(s.samplePath(it.Sample.Media[0]))
+				if err != nil {
+					log.Printf("get media duration of %s failed: %s", it.Sample.Media[0], err)
+					it.ProbeErr = err
+				} else {
+					it.Sample.Duration = d
+				}
+				mu.Lock()
+				done++
+				if time.Since(lastLog) > 5*time.Second {
+					perItem := time.Since(start) / time.Duration(done)
+					eta := start.Add(perItem * time.Duration(len(toProbe)))
+					log.Printf("probing %d/%d ; %s to eta", done, len(toProbe), time.Until(eta).Round(time.Second))
+					lastLog = time.Now()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, it := range toProbe {
+		ch <- it
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// applySync writes items to the database in batches of syncBatchSize.
+// Each sample is written in the same transaction as its ledger records, so an interrupted sync is resumed by the next one.
+func (s *Storage) applySync(ctx context.Context, items []*syncItem) error {
+	// Summary files are written before the database, so that if writing to the database fails, the next sync sees that both sides agree.
+	for _, it := range items {
+		if !(it.upsert() || it.updateArchived()) || it.SummaryAction != summaryExport {
+			continue
+		}
+		name, _ := s.sidecarName(it.ID, SummaryExt)
+		err := s.writeFileAtomic(name, []byte(it.Sample.Summary))
+		if err != nil {
+			return fmt.Errorf("export summary of %s: %w", it.ID, err)
+		}
+	}
+	insertCount := 0
+	updateCount := 0
+	deleteCount := 0
+	restoreCount := 0
+	changedCount := 0
+	for batch := range slices.Chunk(items, syncBatchSize) {
+		err := s.applySyncBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, it := range batch {
+			switch {
+			case it.delete():
+				deleteCount++
+			case it.upsert() && it.Insert:
+				insertCount++
+			case it.upsert() && it.Tombstoned:
+				restoreCount++
+			case it.upsert(), it.updateArchived():
+				updateCount++
+			}
+			if it.Changed {
+				changedCount++
+			}
+		}
+	}
+	log.Printf("synced %d changed samples, %d inserted, %d updated, %d deleted, %d restored.", changedCount, insertCount, updateCount, deleteCount, restoreCount)
+	s.wakeJobs()
+	err := s.setEnds(ctx)
+	if err != nil {
+		return fmt.Errorf("set ends: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) applySyncBatch(ctx context.Context, batch []*syncItem) error {
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// The summary is reconciled by reconcileSummary.
+	upsertSample, err := tx.PreparexContext(ctx, "INSERT INTO samples (id, start, duration, end, summary, summary_hash, transcript, metadata, metadata_text) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO UPDATE SET start=excluded.start, duration=excluded.duration, end=excluded.end, summary=excluded.summary, summary_hash=excluded.summary_hash, transcript=excluded.transcript, metadata=excluded.metadata, metadata_text=excluded.metadata_text, deleted_at=NULL, archived_at=NULL")
+	if err != nil {
+		return err
+	}
+	defer upsertSample.Close()
+	// Archived samples have no media to take the start and duration from, and stay archived.
+	updateArchivedSample, err := tx.PreparexContext(ctx, "UPDATE samples SET summary=?, summary_hash=?, transcript=?, metadata=?, metadata_text=? WHERE id=?")
+	if err != nil {
+		return err
+	}
+	defer updateArchivedSample.Close()
+	deleteSample, err := tx.PreparexContext(ctx, "UPDATE samples SET deleted_at=? WHERE id=?")
+	if err != nil {
+		return err
+	}
+	defer deleteSample.Close()
+	upsertFile, err := tx.PreparexContext(ctx, "INSERT INTO files (name, sample_id, size, mtime, hash) VALUES (?, ?, ?, ?, ?) ON CONFLICT (name) DO UPDATE SET sample_id=excluded.sample_id, size=excluded.size, mtime=excluded.mtime, hash=excluded.hash")
+	if err != nil {
+		return err
+	}
+	defer upsertFile.Close()
+	deleteFile, err := tx.PreparexContext(ctx, "DELETE FROM files WHERE name=?")
+	if err != nil {
+		return err
+	}
+	defer deleteFile.Close()
+	upsertConflict, err := tx.PreparexContext(ctx, "INSERT INTO summary_conflicts (sample_id, db_summary, file_summary, detected_at) VALUES (?, ?, ?, ?) ON CONFLICT (sample_id) DO UPDATE SET db_summary=excluded.db_summary, file_summary=excluded.file_summary")
+	if err != nil {
+		return err
+	}
+	defer upsertConflict.Close()
+	deleteConflict, err := tx.PreparexContext(ctx, "DELETE FROM summary_conflicts WHERE sample_id=?")
+	if err != nil {
+		return err
+	}
+	defer deleteConflict.Close()
+
+	now := time.Now()
+	for _, it := range batch {
+		switch {
+		case it.delete():
+			_, err = deleteSample.ExecContext(ctx, now, it.ID)
+			if err != nil {
+				return fmt.Errorf("delete %s: %w", it.ID, err)
+			}
+		case it.upsert(), it.updateArchived():
+			sp := it.Sample
+			if it.upsert() {
+				_, err = upsertSample.ExecContext(ctx, sp.ID, sp.Start, sp.Duration, sp.Start.Add(sp.Duration), sp.Summary, it.SummaryHash, sp.Transcript, sp.Metadata, sp.MetadataText)
+			} else {
+				_, err = updateArchivedSample.ExecContext(ctx, sp.Summary, it.SummaryHash, sp.Transcript, sp.Metadata, sp.MetadataText, sp.ID)
+			}
+			if err != nil {
+				return fmt.Errorf("upsert %s: %w", it.ID, err)
+			}
+			if it.SummaryAction == summaryConflict {
+				_, err = upsertConflict.ExecContext(ctx, it.ID, sp.Summary, it.FileSummary, now)
+			} else {
+				_, err = deleteConflict.ExecContext(ctx, it.ID)
+			}
+			if err != nil {
+				return fmt.Errorf("summary conflict %s: %w", it.ID, err)
+			}
+			// Cues with errors are skipped; the rest of the transcript is still searchable by cue.
+			var cues []Cue
+			if sp.Transcript != "" {
+				cues, err = ParseVTT(sp.Transcript)
+				if err != nil {
+					log.Printf("transcript of %s: %s", it.ID, err)
+				}
+			}
+			err = replaceCues(ctx, tx, it.ID, cues)
+			if err != nil {
+				return fmt.Errorf("cues %s: %w", it.ID, err)
+			}
+			// Changes made in the files have no author.
+			err = recordRevision(ctx, tx, it.ID, RevisionSummary, sp.Summary, "", now)
+			if err == nil {
+				err = recordRevision(ctx, tx, it.ID, RevisionTranscript, sp.Transcript, "", now)
+			}
+			if err != nil {
+				return fmt.Errorf("revision %s: %w", it.ID, err)
+			}
+			if it.Probe {
+				err = enqueueJob(ctx, tx, JobProbe, probePayload{SampleID: it.ID}, it.Insert || it.MediaChanged)
+				if err != nil {
+					return fmt.Errorf("enqueue probe %s: %w", it.ID, err)
+				}
+			}
+		}
+		for _, r := range it.upserts {
+			_, err = upsertFile.ExecContext(ctx, r.Name, r.SampleID, r.Size, r.ModTime, r.Hash)
+			if err != nil {
+				return fmt.Errorf("ledger upsert %s: %w", r.Name, err)
+			}
+		}
+		for _, r := range it.removed {
+			_, err = deleteFile.ExecContext(ctx, r.Name)
+			if err != nil {
+				return fmt.Errorf("ledger delete %s: %w", r.Name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// setEnds sets the end times of samples synced before the end column existed.
+func (s *Storage) setEnds(ctx context.Context) error {
+	sps := make([]SamplePreview, 0)
+	err := s.DB.SelectContext(ctx, &sps, "SELECT id, start, duration FROM samples WHERE end IS NULL")
+	if err != nil {
+		return fmt.Errorf("select: %w", err)
+	}
+	if len(sps) == 0 {
+		return nil
+	}
+	log.Printf("setting end times...")
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, sp := range sps {
+		_, err = tx.ExecContext(ctx, "UPDATE samples SET end=? WHERE id=?", sp.Start.Add(sp.Duration), sp.ID)
+		if err != nil {
+			return fmt.Errorf("update: %w", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	log.Printf("set end times for %d samples.", len(sps))
+	return nil
+}
@@ -0,0 +1,621 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSyncLegacySummaryConflict(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	for ext, body := range map[string]string{"opus": "x", SummaryExt: "file"} {
+		err := os.WriteFile(filepath.Join(s.SamplesPath, id+"."+ext), []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a row from before summaries were written back, edited in the database
+	_, err = s.DB.ExecContext(ctx, "UPDATE samples SET summary='db', summary_hash='' WHERE id=?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.SummaryConflicts, []string{id}) {
+		t.Fatalf("expected a conflict, got %+v", report)
+	}
+	report, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 0 || len(report.SummaryConflicts) != 0 {
+		t.Fatalf("expected the unresolved conflict to be left alone, got %+v", report)
+	}
+	conflicts, err := s.SummaryConflicts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected the conflict to remain, got %+v", conflicts)
+	}
+}
+
+func TestSyncBackfillCues(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	cued := "2024-01-02T10:00:00+00:00"
+	invalid := "2024-01-02T11:00:00+00:00"
+	files := map[string]string{
+		cued + ".opus":    "x",
+		cued + ".vtt":     "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nhello\n",
+		invalid + ".opus": "x",
+		invalid + ".vtt":  "WEBVTT\n\nnot a cue\n",
+	}
+	for name, body := range files {
+		err := os.WriteFile(filepath.Join(s.SamplesPath, name), []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a transcript from before cues were stored
+	_, err = s.DB.ExecContext(ctx, "DELETE FROM transcript_cues")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Updated, []string{cued}) {
+		t.Fatalf("expected only %s to be updated, got %+v", cued, report)
+	}
+	cues, err := s.SampleCues(ctx, cued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 1 || cues[0].Text != "hello" {
+		t.Fatalf("expected the cue to be stored, got %+v", cues)
+	}
+	report, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 0 {
+		t.Fatalf("expected no updates, got %+v", report)
+	}
+}
+
+// countSampleWrites makes the database count the inserts and updates of samples rows, and returns a function returning the count.
+func countSampleWrites(t *testing.T, s *Storage) func() int {
+	t.Helper()
+	for _, query := range []string{
+		"CREATE TABLE test_sample_writes(sample_id TEXT NOT NULL)",
+		"CREATE TRIGGER test_sample_inserts AFTER INSERT ON samples BEGIN INSERT INTO test_sample_writes VALUES (new.id); END",
+		"CREATE TRIGGER test_sample_updates AFTER UPDATE ON samples BEGIN INSERT INTO test_sample_writes VALUES (new.id); END",
+	} {
+		_, err := s.DB.Exec(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() int {
+		var n int
+		err := s.DB.Get(&n, "SELECT COUNT(*) FROM test_sample_writes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+}
+
+func TestSyncTouchedFile(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x", id + "." + SummaryExt: "summary"})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := countSampleWrites(t, s)
+
+	name := id + "." + SummaryExt
+	mtime := time.Now().Add(time.Hour)
+	err = os.Chtimes(s.samplePath(name), mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 0 || writes() != 0 {
+		t.Fatalf("expected no rows to be written, got %d writes and %+v", writes(), report)
+	}
+	r, ok := ledgerRecord(t, s, name)
+	if !ok || r.ModTime != mtime.UnixNano() {
+		t.Fatalf("expected the ledger to have the new mtime, got %+v", r)
+	}
+}
+
+func TestSyncChangedFile(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	name := id + "." + SummaryExt
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x", name: "one"})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := ledgerRecord(t, s, name)
+
+	cases := []struct {
+		name    string
+		summary string
+		// mtime is the modification time set after writing, or the old one if zero.
+		mtime time.Time
+	}{
+		// the size changes, but the mtime is kept
+		{"size", "three", time.Time{}},
+		// only the mtime shows the change
+		{"mtime", "fours", time.Now().Add(time.Hour)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			writeSampleFiles(t, s, map[string]string{name: c.summary})
+			mtime := c.mtime
+			if mtime.IsZero() {
+				mtime = time.Unix(0, before.ModTime)
+			}
+			err := os.Chtimes(s.samplePath(name), mtime, mtime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := s.Sync(ctx, SyncOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(report.SummariesImported, []string{id}) {
+				t.Fatalf("expected the summary to be imported, got %+v", report)
+			}
+			sp, err := s.SampleGet(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sp.Summary != c.summary {
+				t.Fatalf("expected summary %q, got %q", c.summary, sp.Summary)
+			}
+			r, _ := ledgerRecord(t, s, name)
+			if r.Hash == before.Hash || r.Size != int64(len(c.summary)) {
+				t.Fatalf("expected the ledger to have the new hash and size, got %+v", r)
+			}
+			before = r
+		})
+	}
+}
+
+func TestSyncRemovedFile(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	summary := id + "." + SummaryExt
+	media := id + ".opus"
+	writeSampleFiles(t, s, map[string]string{media: "x", summary: "summary"})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Remove(s.samplePath(summary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ledgerRecord(t, s, summary); ok {
+		t.Fatal("expected the summary's ledger record to be removed")
+	}
+	if _, ok := ledgerRecord(t, s, media); !ok {
+		t.Fatal("expected the media's ledger record to be kept")
+	}
+
+	err = os.Remove(s.samplePath(media))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SyncFileNames(ctx, []string{media})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ledgerRecord(t, s, media); ok {
+		t.Fatal("expected the media's ledger record to be removed")
+	}
+}
+
+func TestSyncNestedLayout(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	a := "2024-01-02T10:00:00+00:00"
+	b := "2024-01-03T10:00:00+00:00"
+	hidden := "2024-01-04T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{
+		"2024/01/02/" + a + ".opus":          "x",
+		"2024/01/02/" + a + "." + SummaryExt: "summary of a",
+		// the same ID in another directory, in another encoding
+		"2024/01/03/" + a + ".wav":           "x",
+		"2024/01/03/" + b + ".opus":          "x",
+		"2024/01/03/" + b + "." + SummaryExt: "summary of b",
+		// hidden directories are skipped
+		uploadsDir + "/" + hidden + ".opus": "x",
+		transcodeDir + "/" + b + ".webm":    "x",
+		"2024/.old/" + hidden + ".opus":     "x",
+	})
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(report.Inserted)
+	if !slices.Equal(report.Inserted, []string{a, b}) {
+		t.Fatalf("expected %s and %s to be inserted, got %+v", a, b, report)
+	}
+	ids := make([]string, 0)
+	err = s.DB.Select(&ids, "SELECT id FROM samples ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{a, b}) {
+		t.Fatalf("expected rows for %s and %s, got %v", a, b, ids)
+	}
+
+	cases := []struct {
+		id      string
+		media   []string
+		summary string
+	}{
+		{a, []string{"2024/01/02/" + a + ".opus", "2024/01/03/" + a + ".wav"}, "summary of a"},
+		{b, []string{"2024/01/03/" + b + ".opus"}, "summary of b"},
+	}
+	for _, c := range cases {
+		sp, err := s.SampleGet(c.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(sp.Media, c.media) {
+			t.Fatalf("%s: expected media %v, got %v", c.id, c.media, sp.Media)
+		}
+		if sp.Summary != c.summary {
+			t.Fatalf("%s: expected summary %q, got %q", c.id, c.summary, sp.Summary)
+		}
+	}
+}
+
+// writeSamples writes media for n samples, a minute apart from start, and returns their IDs.
+func writeSamples(t *testing.T, s *Storage, start time.Time, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	files := make(map[string]string, n)
+	for i := range ids {
+		ids[i] = start.Add(time.Duration(i) * time.Minute).Format("2006-01-02T15:04:05-07:00")
+		files[ids[i]+".opus"] = "x"
+	}
+	writeSampleFiles(t, s, files)
+	return ids
+}
+
+func countRows(t *testing.T, s *Storage, table string) int {
+	t.Helper()
+	var n int
+	err := s.DB.Get(&n, "SELECT COUNT(*) FROM "+table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSyncBatches(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	n := syncBatchSize*2 + 1
+	writeSamples(t, s, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), n)
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Inserted) != n {
+		t.Fatalf("expected %d inserted, got %d", n, len(report.Inserted))
+	}
+	if rows, files := countRows(t, s, "samples"), countRows(t, s, "files"); rows != n || files != n {
+		t.Fatalf("expected %d samples and ledger records, got %d and %d", n, rows, files)
+	}
+}
+
+func TestSyncConcurrent(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	n := syncBatchSize + 1
+	writeSamples(t, s, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), n)
+	type result struct {
+		report SyncReport
+		err    error
+	}
+	results := make(chan result, 2)
+	for range 2 {
+		go func() {
+			report, err := s.Sync(ctx, SyncOptions{})
+			results <- result{report, err}
+		}()
+	}
+	inserted := 0
+	for range 2 {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		inserted += len(r.report.Inserted)
+	}
+	// the second sync starts after the first one is done, so it has nothing to insert
+	if inserted != n {
+		t.Fatalf("expected %d samples to be inserted once, got %d inserts", n, inserted)
+	}
+	if rows, files := countRows(t, s, "samples"), countRows(t, s, "files"); rows != n || files != n {
+		t.Fatalf("expected %d samples and ledger records, got %d and %d", n, rows, files)
+	}
+	if jobs := countRows(t, s, "jobs"); jobs != n {
+		t.Fatalf("expected %d probe jobs, got %d", n, jobs)
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	ids := writeSamples(t, s, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), 3)
+	report, err := s.Sync(ctx, SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(report.Inserted)
+	if !report.DryRun || !slices.Equal(report.Inserted, ids) {
+		t.Fatalf("expected a dry run inserting %v, got %+v", ids, report)
+	}
+	for _, table := range []string{"samples", "files", "jobs", "revisions"} {
+		if n := countRows(t, s, table); n != 0 {
+			t.Fatalf("expected nothing to be written, got %d rows in %s", n, table)
+		}
+	}
+}
+
+func TestSyncTooManyDeletes(t *testing.T) {
+	s := newTestStorage(t)
+	s.MaxSyncDeletes = 2
+	ctx := context.Background()
+	ids := writeSamples(t, s, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), 3)
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e.g. the drive was unmounted
+	for _, id := range ids {
+		err = os.Remove(s.samplePath(id + ".opus"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	countLive := func() int {
+		var n int
+		err := s.DB.Get(&n, "SELECT COUNT(*) FROM samples WHERE deleted_at IS NULL")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	report, err := s.Sync(ctx, SyncOptions{})
+	if !errors.Is(err, ErrTooManyDeletes) {
+		t.Fatalf("expected ErrTooManyDeletes, got %v", err)
+	}
+	if len(report.Deleted) != len(ids) {
+		t.Fatalf("expected the report to list the deletes, got %+v", report)
+	}
+	if live, files := countLive(), countRows(t, s, "files"); live != len(ids) || files != len(ids) {
+		t.Fatalf("expected the rows to be intact, got %d samples and %d ledger records", live, files)
+	}
+
+	report, err = s.Sync(ctx, SyncOptions{ConfirmDeletes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(report.Deleted)
+	if !slices.Equal(report.Deleted, ids) {
+		t.Fatalf("expected %v to be deleted, got %+v", ids, report)
+	}
+	if live, files := countLive(), countRows(t, s, "files"); live != 0 || files != 0 {
+		t.Fatalf("expected the samples to be tombstoned, got %d samples and %d ledger records", live, files)
+	}
+}
+
+func TestSyncReport(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	ids := writeSamples(t, s, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), 5)
+	// ids[0] is left unchanged
+	updated, deleted, restored, inserted := ids[1], ids[2], ids[3], ids[4]
+	err := os.Remove(s.samplePath(inserted + ".opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(s.samplePath(restored + ".opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeSampleFiles(t, s, map[string]string{
+		inserted + ".opus":             "x",
+		restored + ".opus":             "x",
+		updated + "." + SummaryExt:     "summary",
+		"2024/" + updated + ".unknown": "x",
+		"notes.opus":                   "x",
+	})
+	err = os.Remove(s.samplePath(deleted + ".opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dryRun := range []bool{true, false} {
+		report, err := s.Sync(ctx, SyncOptions{DryRun: dryRun})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string][]string{
+			"inserted":           {inserted},
+			"updated":            {updated},
+			"deleted":            {deleted},
+			"restored":           {restored},
+			"summaries_imported": {updated},
+			"summaries_exported": {},
+			"summary_conflicts":  {},
+			"unparseable":        {"notes.opus"},
+		}
+		// the report is printed as JSON by the sync subcommand
+		data, err := json.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		err = json.Unmarshal(data, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got["dry_run"] != dryRun {
+			t.Fatalf("expected dry_run to be %t, got %v", dryRun, got["dry_run"])
+		}
+		for key, ids := range expected {
+			list, ok := got[key].([]any)
+			if !ok {
+				t.Fatalf("dry run %t: expected %s to be a list, got %v", dryRun, key, got[key])
+			}
+			gotIDs := make([]string, len(list))
+			for i, id := range list {
+				gotIDs[i], _ = id.(string)
+			}
+			slices.Sort(gotIDs)
+			if !slices.Equal(gotIDs, ids) {
+				t.Fatalf("dry run %t: expected %s to be %v, got %v", dryRun, key, ids, gotIDs)
+			}
+		}
+	}
+}
+
+func TestSampleSummarySetTombstoned(t *testing.T) {
+	cases := []struct {
+		name string
+		// summary is the summary file of the sample before it was tombstoned, if any.
+		summary string
+	}{
+		{"without file", ""},
+		{"with file", "old"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newTestStorage(t)
+			ctx := context.Background()
+			id := "2024-01-02T10:00:00+00:00"
+			files := map[string]string{id + ".opus": "x"}
+			if c.summary != "" {
+				files[id+"."+SummaryExt] = c.summary
+			}
+			writeSampleFiles(t, s, files)
+			_, err := s.Sync(ctx, SyncOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name := range files {
+				err = os.Remove(s.samplePath(name))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err = s.Sync(ctx, SyncOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.SampleSummarySet(id, "new", "alice", ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeSampleFiles(t, s, files)
+			report, err := s.Sync(ctx, SyncOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(report.Restored, []string{id}) || !slices.Equal(report.SummariesExported, []string{id}) {
+				t.Fatalf("expected the sample to be restored and its summary exported, got %+v", report)
+			}
+			data, err := os.ReadFile(s.samplePath(id + "." + SummaryExt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "new" {
+				t.Fatalf("expected the file to have the new summary, got %q", data)
+			}
+			report, err = s.Sync(ctx, SyncOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.SummariesImported) != 0 || len(report.SummaryConflicts) != 0 {
+				t.Fatalf("expected both sides to agree, got %+v", report)
+			}
+			sp, err := s.SampleGet(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sp.Summary != "new" || sp.SummaryHash != summaryHash("new") {
+				t.Fatalf("expected the new summary and its hash, got %q and %q", sp.Summary, sp.SummaryHash)
+			}
+		})
+	}
+}
+
+func TestSampleSummarySetUnknown(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	err := s.SampleSummarySet(id, "summary", "alice", ctx)
+	if !errors.Is(err, ErrSampleNotFound) {
+		t.Fatalf("expected ErrSampleNotFound, got %v", err)
+	}
+	if n := countRows(t, s, "revisions"); n != 0 {
+		t.Fatalf("expected no revisions, got %d", n)
+	}
+}
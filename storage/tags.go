@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// maxTagLength is the maximum length of a tag in bytes.
+const maxTagLength = 64
+
+// ErrInvalidTag is returned for tags that are empty, too long or contain commas or control characters.
+var ErrInvalidTag = errors.New("invalid tag")
+
+// TagCount is a tag and the number of (non-tombstoned) samples with it.
+type TagCount struct {
+	Name  string `db:"name" json:"name"`
+	Count int    `db:"count" json:"count"`
+}
+
+// NormalizeTag trims and lowercases a tag, and checks that it is valid.
+// Tags may contain spaces, but not commas, so that they can be entered as comma-separated lists.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", fmt.Errorf("%w: empty", ErrInvalidTag)
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalidTag, maxTagLength)
+	}
+	if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+		return "", fmt.Errorf("%w: contains a comma or control character", ErrInvalidTag)
+	}
+	return tag, nil
+}
+
+// ParseTags parses a comma-separated list of tags. Duplicates are removed.
+func ParseTags(s string) ([]string, error) {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(s, ",") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		tag, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// SampleTags returns the sample's tags, sorted.
+func (s *Storage) SampleTags(ctx context.Context, id string) ([]string, error) {
+	tags := make([]string, 0)
+	err := s.DB.SelectContext(ctx, &tags, "SELECT name FROM tags JOIN sample_tags ON tags.id = sample_tags.tag_id WHERE sample_tags.sample_id=? ORDER BY name", id)
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// SampleTagAdd adds a tag to the sample. Adding a tag the sample already has does nothing.
+func (s *Storage) SampleTagAdd(ctx context.Context, id, tag string) error {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var exists bool
+	err = tx.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM samples WHERE id=?)", id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrSampleNotFound
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO NOTHING", tag)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO sample_tags (sample_id, tag_id) SELECT ?, id FROM tags WHERE name=? ON CONFLICT DO NOTHING", id, tag)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SampleTagRemove removes a tag from the sample. Tags without samples are deleted.
+func (s *Storage) SampleTagRemove(ctx context.Context, id, tag string) error {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "DELETE FROM sample_tags WHERE sample_id=? AND tag_id=(SELECT id FROM tags WHERE name=?)", id, tag)
+	if err != nil {
+		return err
+	}
+	err = deleteUnusedTags(ctx, tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteUnusedTags(ctx context.Context, tx *sqlx.Tx) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM sample_tags)")
+	return err
+}
+
+// TagCounts returns all tags with the number of samples with each, sorted by name.
+// Tombstoned samples are not counted.
+func (s *Storage) TagCounts(ctx context.Context) ([]TagCount, error) {
+	counts := make([]TagCount, 0)
+	err := s.DB.SelectContext(ctx, &counts, `
+SELECT tags.name AS name, COUNT(samples.id) AS count FROM tags
+JOIN sample_tags ON tags.id = sample_tags.tag_id
+LEFT JOIN samples ON samples.id = sample_tags.sample_id AND samples.deleted_at IS NULL
+GROUP BY tags.id
+ORDER BY tags.name
+`)
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// loadTags returns the tags of the samples with the given IDs.
+func (s *Storage) loadTags(ctx context.Context, ids []string) (map[string][]string, error) {
+	tags := make(map[string][]string, len(ids))
+	for batch := range slices.Chunk(ids, syncBatchSize) {
+		query, args, err := sqlx.In("SELECT sample_tags.sample_id AS sample_id, tags.name AS name FROM sample_tags JOIN tags ON tags.id = sample_tags.tag_id WHERE sample_tags.sample_id IN (?) ORDER BY tags.name", batch)
+		if err != nil {
+			return nil, err
+		}
+		rows := make([]struct {
+			SampleID string `db:"sample_id"`
+			Name     string `db:"name"`
+		}, 0)
+		err = s.DB.SelectContext(ctx, &rows, s.DB.Rebind(query), args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			tags[row.SampleID] = append(tags[row.SampleID], row.Name)
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags(" Meeting, project x,,meeting ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"meeting", "project x"}; !slices.Equal(tags, expected) {
+		t.Fatalf("expected %v, got %v", expected, tags)
+	}
+	tags, err = ParseTags("")
+	if err != nil || len(tags) != 0 {
+		t.Fatalf("expected no tags, got %v, %v", tags, err)
+	}
+	for _, tag := range []string{"", " ", "a\nb", strings.Repeat("a", maxTagLength+1)} {
+		_, err := NormalizeTag(tag)
+		if !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("%q: expected ErrInvalidTag, got %v", tag, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNotTombstoned is returned when purging or restoring a sample that is not tombstoned.
+var ErrNotTombstoned = errors.New("sample is not tombstoned")
+
+// ErrNoMedia is returned when restoring a sample that has no media in the samples directory.
+var ErrNoMedia = errors.New("sample has no media")
+
+// Tombstones returns the samples whose files disappeared, most recently deleted first.
+func (s *Storage) Tombstones(ctx context.Context) ([]SamplePreview, error) {
+	sps := make([]SamplePreview, 0)
+	err := s.DB.SelectContext(ctx, &sps, "SELECT * FROM samples WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	return sps, nil
+}
+
+// SamplePurge permanently deletes a tombstoned sample, including its summary, tags, clips, bookmarks, revisions, and its transcode, retention, job and ledger records.
+// Files of the sample left in the samples directory (e.g. its summary file) are kept, as they may be the only copy; as they are no longer in the ledger, they are imported again if the sample's media reappears.
+func (s *Storage) SamplePurge(ctx context.Context, id string) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "DELETE FROM samples WHERE id=? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	err = checkTombstoneAffected(res)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM summary_conflicts WHERE sample_id=?",
+		"DELETE FROM sample_tags WHERE sample_id=?",
+		"DELETE FROM clips WHERE sample_id=?",
+		"DELETE FROM bookmarks WHERE sample_id=?",
+		"DELETE FROM peaks WHERE sample_id=?",
+		"DELETE FROM speech_segments WHERE sample_id=?",
+		"DELETE FROM transcript_cues WHERE sample_id=?",
+		"DELETE FROM revisions WHERE sample_id=?",
+		"DELETE FROM transcodes WHERE sample_id=?",
+		"DELETE FROM retention_log WHERE sample_id=?",
+		"DELETE FROM jobs WHERE kind='" + JobProbe + "' AND payload->>'sample_id'=?",
+		"DELETE FROM files WHERE sample_id=?",
+	} {
+		_, err = tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+	}
+	err = deleteUnusedTags(ctx, tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SampleRestore clears the tombstone of a sample whose media is back in the samples directory.
+// Syncs restore such samples automatically; this is for when the sample was tombstoned by mistake and the files were restored without a sync.
+func (s *Storage) SampleRestore(ctx context.Context, id string) error {
+	err := s.ensureIndex()
+	if err != nil {
+		return err
+	}
+	if len(s.index.media(id)) == 0 {
+		return ErrNoMedia
+	}
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	res, err := s.DB.ExecContext(ctx, "UPDATE samples SET deleted_at=NULL WHERE id=? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	return checkTombstoneAffected(res)
+}
+
+func checkTombstoneAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrNotTombstoned
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSampleRestore(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x"})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SampleRestore(ctx, id)
+	if !errors.Is(err, ErrNotTombstoned) {
+		t.Fatalf("expected ErrNotTombstoned for a live sample, got %v", err)
+	}
+
+	// tombstoned by mistake, with the media still there
+	_, err = s.DB.ExecContext(ctx, "UPDATE samples SET deleted_at=? WHERE id=?", time.Now(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tombstones, err := s.Tombstones(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tombstones) != 1 || tombstones[0].ID != id {
+		t.Fatalf("expected %s to be tombstoned, got %+v", id, tombstones)
+	}
+	err = s.SampleRestore(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tombstones, err = s.Tombstones(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tombstones) != 0 {
+		t.Fatalf("expected no tombstones, got %+v", tombstones)
+	}
+
+	err = os.Remove(s.samplePath(id + ".opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SampleRestore(ctx, id)
+	if !errors.Is(err, ErrNoMedia) {
+		t.Fatalf("expected ErrNoMedia, got %v", err)
+	}
+}
+
+func TestSamplePurge(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	summary := id + "." + SummaryExt
+	writeSampleFiles(t, s, map[string]string{
+		id + ".opus":             "x",
+		summary:                  "summary",
+		id + "." + TranscriptExt: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nhello\n",
+	})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.DB.ExecContext(ctx, "UPDATE samples SET duration=? WHERE id=?", time.Minute, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SampleTagAdd(ctx, id, "purged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ClipCreate(ctx, Clip{SampleID: id, StartOffset: time.Second, EndOffset: 2 * time.Second, Title: "clip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.BookmarkCreate(ctx, Bookmark{SampleID: id, Offset: time.Second, Note: "bookmark"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SamplePurge(ctx, id)
+	if !errors.Is(err, ErrNotTombstoned) {
+		t.Fatalf("expected ErrNotTombstoned for a live sample, got %v", err)
+	}
+
+	err = os.Remove(s.samplePath(id + ".opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SamplePurge(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []string{"samples", "sample_tags", "tags", "clips", "bookmarks", "transcript_cues", "revisions", "jobs", "files"}
+	for _, table := range tables {
+		if n := countRows(t, s, table); n != 0 {
+			t.Fatalf("expected %s to be empty, got %d rows", table, n)
+		}
+	}
+	// the summary file is kept
+	data, err := os.ReadFile(s.samplePath(summary))
+	if err != nil || string(data) != "summary" {
+		t.Fatalf("expected the summary file to be kept, got %q, %v", data, err)
+	}
+
+	// media reappearing makes a new sample, with the files that were left
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x"})
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Inserted) != 1 {
+		t.Fatalf("expected the sample to be inserted, got %+v", report)
+	}
+	sp, err := s.SampleGet(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Summary != "summary" || sp.Transcript == "" {
+		t.Fatalf("expected the summary and transcript to be imported, got %q and %q", sp.Summary, sp.Transcript)
+	}
+}
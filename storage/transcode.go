@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TranscodeConfig configures the transcoding of media to a compact encoding.
+// Both files are kept for Grace after transcoding; then the source is removed.
+// While both exist, the output is listed first (see MediaPreference), so it is the one played and probed.
+type TranscodeConfig struct {
+	// Ext is the extension (without the dot) of the output, and decides the container ffmpeg writes.
+	Ext string
+	// Codec and Bitrate are passed to ffmpeg as -c:a and -b:a (e.g. libopus and 32k).
+	Codec   string
+	Bitrate string
+	// SourceExts are the extensions of the media that are transcoded (e.g. aiff and wav).
+	SourceExts []string
+	Grace      time.Duration
+}
+
+// Validate checks that the extensions are media extensions, and that the output is preferred over the sources.
+func (c TranscodeConfig) Validate() error {
+	if _, ok := MediaFileTypes[c.Ext]; !ok {
+		return fmt.Errorf("output extension %q is not a media extension", c.Ext)
+	}
+	if c.Codec == "" {
+		return errors.New("codec must be set")
+	}
+	if len(c.SourceExts) == 0 {
+		return errors.New("no source extensions")
+	}
+	for _, ext := range c.SourceExts {
+		if _, ok := MediaFileTypes[ext]; !ok {
+			return fmt.Errorf("source extension %q is not a media extension", ext)
+		}
+		if slices.Index(MediaPreference, ext) <= slices.Index(MediaPreference, c.Ext) {
+			return fmt.Errorf("source extension %q is preferred over the output extension %q", ext, c.Ext)
+		}
+	}
+	if c.Grace < 0 {
+		return errors.New("negative grace period")
+	}
+	return nil
+}
+
+// transcodeDir is the directory transcodes are written to until they are checked, relative to the samples directory.
+// It is hidden, so syncs and the watcher ignore partial outputs.
+const transcodeDir = ".transcode"
+
+const (
+	TranscodeRunning = "running"
+	// TranscodeDone means both the source and the output exist, until the grace period is over.
+	TranscodeDone = "done"
+	// TranscodeCompacted means the source was removed after the grace period.
+	TranscodeCompacted = "compacted"
+	TranscodeFailed    = "failed"
+)
+
+// Transcode is a row in the transcodes table. Source and Output are relative to the samples directory.
+type Transcode struct {
+	Source          string        `db:"source"`
+	SampleID        string        `db:"sample_id"`
+	Output          string        `db:"output"`
+	Status          string        `db:"status"`
+	Error           string        `db:"error"`
+	SourceSize      int64         `db:"source_size"`
+	OutputSize      int64         `db:"output_size"`
+	SourceDuration  time.Duration `db:"source_duration"`
+	OutputDuration  time.Duration `db:"output_duration"`
+	StartedAt       time.Time     `db:"started_at"`
+	FinishedAt      *time.Time    `db:"finished_at"`
+	SourceRemovedAt *time.Time    `db:"source_removed_at"`
+}
+
+// durationsMatch reports whether the output of a transcode is as long as the source.
+// Encoders pad or trim a little (e.g. Opus pre-skip), so up to 0.5% or half a second is allowed.
+func durationsMatch(source, output time.Duration) bool {
+	tolerance := max(source/200, 500*time.Millisecond)
+	diff := source - output
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
+
+// transcodeOutputName returns the name of the output of source, next to it.
+func transcodeOutputName(source, ext string) string {
+	return strings.TrimSuffix(source, path.Ext(source)) + "." + ext
+}
+
+// TranscodePending returns the media files (relative to the samples directory) that are waiting to be transcoded.
+// Files that failed are not retried until TranscodeRetry is called.
+func (s *Storage) TranscodePending(ctx context.Context) ([]string, error) {
+	pending := make([]string, 0)
+	if s.Transcode == nil {
+		return pending, nil
+	}
+	err := s.ensureIndex()
+	if err != nil {
+		return nil, err
+	}
+	// Transcodes left running by a previous process are started again.
+	known := make([]string, 0)
+	err = s.DB.SelectContext(ctx, &known, "SELECT source FROM transcodes WHERE status != ?", TranscodeRunning)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range s.index.ids() {
+		if _, ok := s.index.get(id, s.Transcode.Ext); ok {
+			continue
+		}
+		for _, ext := range s.Transcode.SourceExts {
+			name, ok := s.index.get(id, ext)
+			if ok && !slices.Contains(known, name) {
+				pending = append(pending, name)
+				break
+			}
+		}
+	}
+	slices.Sort(pending)
+	return pending, nil
+}
+
+// Transcodes returns the transcodes, most recently started first.
+func (s *Storage) Transcodes(ctx context.Context) ([]Transcode, error) {
+	ts := make([]Transcode, 0)
+	err := s.DB.SelectContext(ctx, &ts, "SELECT * FROM transcodes ORDER BY started_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// ErrTranscodeNotFound is returned by TranscodeRetry for sources without a failed transcode.
+var ErrTranscodeNotFound = errors.New("failed transcode not found")
+
+// TranscodeRetry forgets a failed transcode, so that the source is transcoded again by the next run.
+func (s *Storage) TranscodeRetry(ctx context.Context, source string) error {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM transcodes WHERE source=? AND status=?", source, TranscodeFailed)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTranscodeNotFound
+	}
+	return nil
+}
+
+// RunTranscoder transcodes pending media and removes sources after the grace period every interval, until ctx is done.
+// Media is transcoded one file at a time, so that the server stays responsive.
+func (s *Storage) RunTranscoder(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.TranscodeOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("transcode: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TranscodeOnce transcodes all pending media, then removes the sources whose grace period is over.
+// Failures of single files are recorded in the transcodes table instead of being returned.
+func (s *Storage) TranscodeOnce(ctx context.Context) error {
+	if s.Transcode == nil {
+		return nil
+	}
+	pending, err := s.TranscodePending(ctx)
+	if err != nil {
+		return fmt.Errorf("pending: %w", err)
+	}
+	for _, source := range pending {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err = s.transcode(ctx, source)
+		if err != nil {
+			return fmt.Errorf("transcode %s: %w", source, err)
+		}
+	}
+	return s.removeTranscodeSources(ctx)
+}
+
+// transcode transcodes a single file, recording the result in the transcodes table.
+// Only errors writing to the database are returned.
+func (s *Storage) transcode(ctx context.Context, source string) error {
+	t := Transcode{
+		Source:    source,
+		SampleID:  sampleIDFromName(source),
+		Output:    transcodeOutputName(source, s.Transcode.Ext),
+		Status:    TranscodeRunning,
+		StartedAt: time.Now(),
+	}
+	_, err := s.DB.NamedExecContext(ctx, "INSERT INTO transcodes (source, sample_id, output, status, started_at) VALUES (:source, :sample_id, :output, :status, :started_at) ON CONFLICT (source) DO UPDATE SET output=excluded.output, status=excluded.status, error='', started_at=excluded.started_at, finished_at=NULL", t)
+	if err != nil {
+		return err
+	}
+	log.Printf("transcoding %s to %s...", t.Source, t.Output)
+	err = s.runTranscode(ctx, &t)
+	now := time.Now()
+	t.FinishedAt = &now
+	if err != nil {
+		if ctx.Err() != nil {
+			// interrupted by shutdown; retried by the next run
+			_, dbErr := s.DB.ExecContext(context.Background(), "DELETE FROM transcodes WHERE source=?", t.Source)
+			return dbErr
+		}
+		log.Printf("transcoding %s failed: %s", t.Source, err)
+		t.Status = TranscodeFailed
+		t.Error = err.Error()
+	} else {
+		t.Status = TranscodeDone
+	}
+	_, err = s.DB.NamedExecContext(ctx, "UPDATE transcodes SET status=:status, error=:error, source_size=:source_size, output_size=:output_size, source_duration=:source_duration, output_duration=:output_duration, finished_at=:finished_at WHERE source=:source", t)
+	if err != nil {
+		return err
+	}
+	if t.Status == TranscodeDone {
+		return s.SyncFileNames(ctx, []string{t.Output})
+	}
+	return nil
+}
+
+// runTranscode runs ffmpeg, checks the output, and moves it into place. The sizes and durations of t are set.
+func (s *Storage) runTranscode(ctx context.Context, t *Transcode) error {
+	sourcePath := s.samplePath(t.Source)
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+	t.SourceSize = info.Size()
+	t.SourceDuration, err = s.probeDuration(sourcePath)
+	if err != nil {
+		return fmt.Errorf("probe source: %w", err)
+	}
+	if _, err := os.Stat(s.samplePath(t.Output)); err == nil {
+		return errors.New("output already exists")
+	}
+
+	// The output is written to a hidden directory (ignored by syncs and the watcher), with the same extension so that ffmpeg picks the container from it.
+	err = os.MkdirAll(filepath.Join(s.SamplesPath, transcodeDir), 0755)
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(s.SamplesPath, transcodeDir, path.Base(t.Output))
+	defer os.Remove(tmpPath)
+	args := []string{"-nostdin", "-v", "error", "-y", "-i", "file:" + sourcePath, "-vn", "-c:a", s.Transcode.Codec}
+	if s.Transcode.Bitrate != "" {
+		args = append(args, "-b:a", s.Transcode.Bitrate)
+	}
+	args = append(args, "file:"+tmpPath)
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	info, err = os.Stat(tmpPath)
+	if err != nil {
+		return err
+	}
+	t.OutputSize = info.Size()
+	t.OutputDuration, err = s.probeDuration(tmpPath)
+	if err != nil {
+		return fmt.Errorf("probe output: %w", err)
+	}
+	if !durationsMatch(t.SourceDuration, t.OutputDuration) {
+		return fmt.Errorf("output duration %s does not match source duration %s", t.OutputDuration, t.SourceDuration)
+	}
+	return os.Rename(tmpPath, s.samplePath(t.Output))
+}
+
+// removeTranscodeSources removes the sources of transcodes whose grace period is over.
+// Sources whose output disappeared are kept, and their transcodes marked as failed.
+func (s *Storage) removeTranscodeSources(ctx context.Context) error {
+	ts := make([]Transcode, 0)
+	err := s.DB.SelectContext(ctx, &ts, "SELECT * FROM transcodes WHERE status=? AND unixepoch(finished_at) < ?", TranscodeDone, time.Now().Add(-s.Transcode.Grace).Unix())
+	if err != nil {
+		return err
+	}
+	removed := make([]string, 0)
+	for _, t := range ts {
+		_, err = os.Stat(s.samplePath(t.Output))
+		if errors.Is(err, os.ErrNotExist) {
+			_, err = s.DB.ExecContext(ctx, "UPDATE transcodes SET status=?, error=? WHERE source=?", TranscodeFailed, "output disappeared during the grace period", t.Source)
+			if err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = os.Remove(s.samplePath(t.Source))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		_, err = s.DB.ExecContext(ctx, "UPDATE transcodes SET status=?, source_removed_at=? WHERE source=?", TranscodeCompacted, time.Now(), t.Source)
+		if err != nil {
+			return err
+		}
+		removed = append(removed, t.Source)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	log.Printf("removed %d transcoded sources.", len(removed))
+	return s.SyncFileNames(ctx, removed)
+}
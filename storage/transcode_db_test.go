@@ -0,0 +1,62 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRemoveTranscodeSources(t *testing.T) {
+	s := newTestStorage(t)
+	s.Transcode = &TranscodeConfig{Ext: "opus", Codec: "libopus", SourceExts: []string{"aiff"}, Grace: time.Hour}
+	ctx := context.Background()
+	now := time.Now()
+	// The finish times are in zones far from the local one, so that comparing them as text would give the wrong result.
+	inside := now.Add(-30 * time.Minute).In(time.FixedZone("", -10*60*60))
+	past := now.Add(-2 * time.Hour).In(time.FixedZone("", 14*60*60))
+	cases := map[string]time.Time{
+		"2024-01-02T10:00:00+00:00": inside,
+		"2024-01-02T11:00:00+00:00": past,
+	}
+	for id, finishedAt := range cases {
+		writeSampleFiles(t, s, map[string]string{id + ".aiff": "source", id + ".opus": "output"})
+		_, err := s.DB.NamedExecContext(ctx, "INSERT INTO transcodes (source, sample_id, output, status, started_at, finished_at) VALUES (:source, :sample_id, :output, :status, :started_at, :finished_at)", Transcode{
+			Source:     id + ".aiff",
+			SampleID:   id,
+			Output:     id + ".opus",
+			Status:     TranscodeDone,
+			StartedAt:  finishedAt.Add(-time.Minute),
+			FinishedAt: &finishedAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := s.removeTranscodeSources(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, finishedAt := range cases {
+		removed := finishedAt.Equal(past)
+		_, err = os.Stat(s.samplePath(id + ".aiff"))
+		if removed != os.IsNotExist(err) {
+			t.Errorf("%s: expected the source to be removed: %t, got %v", id, removed, err)
+		}
+		var status string
+		err = s.DB.GetContext(ctx, &status, "SELECT status FROM transcodes WHERE source=?", id+".aiff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := TranscodeDone
+		if removed {
+			expected = TranscodeCompacted
+		}
+		if status != expected {
+			t.Errorf("%s: expected status %s, got %s", id, expected, status)
+		}
+	}
+}
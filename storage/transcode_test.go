@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDurationsMatch(t *testing.T) {
+	cases := []struct {
+		source, output time.Duration
+		match          bool
+	}{
+		{time.Minute, time.Minute, true},
+		{time.Minute, time.Minute + 400*time.Millisecond, true},
+		{time.Minute, time.Minute - 600*time.Millisecond, false},
+		{time.Hour, time.Hour - 15*time.Second, true},
+		{time.Hour, time.Hour - 20*time.Second, false},
+		{time.Hour, 0, false},
+	}
+	for _, c := range cases {
+		if got := durationsMatch(c.source, c.output); got != c.match {
+			t.Errorf("durationsMatch(%s, %s) = %t, expected %t", c.source, c.output, got, c.match)
+		}
+	}
+}
+
+func TestTranscodeConfigValidate(t *testing.T) {
+	ok := TranscodeConfig{Ext: "opus", Codec: "libopus", SourceExts: []string{"aiff", "wav"}}
+	if err := ok.Validate(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	for _, c := range []TranscodeConfig{
+		{Ext: "txt", Codec: "libopus", SourceExts: []string{"aiff"}},
+		{Ext: "opus", SourceExts: []string{"aiff"}},
+		{Ext: "opus", Codec: "libopus"},
+		{Ext: "opus", Codec: "libopus", SourceExts: []string{"vtt"}},
+		// the output would not be preferred over the source
+		{Ext: "flac", Codec: "flac", SourceExts: []string{"opus"}},
+		{Ext: "opus", Codec: "libopus", SourceExts: []string{"aiff"}, Grace: -time.Hour},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestTranscodeOutputName(t *testing.T) {
+	got := transcodeOutputName("2024/01/2024-01-02T03:04:05+09:00.aiff", "opus")
+	if got != "2024/01/2024-01-02T03:04:05+09:00.opus" {
+		t.Fatalf("unexpected name %s", got)
+	}
+}
+
+func TestScanSamplesDirSkipsTranscodes(t *testing.T) {
+	s := &Storage{SamplesPath: t.TempDir(), FilenameParsers: DefaultFilenameParsers}
+	id := "2024-01-02T10:00:00+00:00"
+	err := os.MkdirAll(filepath.Join(s.SamplesPath, transcodeDir), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{id + ".wav", filepath.Join(transcodeDir, id+".opus")} {
+		err = os.WriteFile(filepath.Join(s.SamplesPath, name), []byte("x"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, unparseable, err := s.scanSamplesDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != id+".wav" || len(unparseable) != 0 {
+		t.Fatalf("expected only the source, got %+v and unparseable %v", records, unparseable)
+	}
+}
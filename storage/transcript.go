@@ -0,0 +1,492 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TranscriptFormat is a format transcripts are accepted in. They are converted to WebVTT (TranscriptExt) for storage.
+type TranscriptFormat string
+
+const (
+	TranscriptVTT TranscriptFormat = "vtt"
+	TranscriptSRT TranscriptFormat = "srt"
+	// TranscriptWhisper is the JSON output of Whisper (and compatible tools): segments with start and end seconds, text, and optionally words and a speaker.
+	TranscriptWhisper TranscriptFormat = "whisper"
+)
+
+// TranscriptOriginalExts are the extensions of the files the originals of converted transcripts are kept in, next to the sample's media.
+// They are not sample files, so they are not synced.
+var TranscriptOriginalExts = map[TranscriptFormat]string{
+	TranscriptSRT:     "srt",
+	TranscriptWhisper: "whisper.json",
+}
+
+// IsTranscriptOriginal reports whether the file keeps the original of a converted transcript.
+// This is checked before the extension, as whisper.json would otherwise be a metadata file of the sample "<id>.whisper".
+func IsTranscriptOriginal(name string) bool {
+	for _, ext := range TranscriptOriginalExts {
+		if strings.HasSuffix(name, "."+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+var srtTimestamp = regexp.MustCompile(`^(\d{1,}):([0-5]\d):([0-5]\d)[,.](\d{3})$`)
+
+// parseSRTTimestamp parses an SRT timestamp (hh:mm:ss,ttt; a dot is accepted instead of the comma).
+func parseSRTTimestamp(s string) (time.Duration, bool) {
+	m := srtTimestamp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	h, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	mins, _ := strconv.Atoi(m[2])
+	secs, _ := strconv.Atoi(m[3])
+	ms, _ := strconv.Atoi(m[4])
+	return time.Duration(h)*time.Hour + time.Duration(mins)*time.Minute + time.Duration(secs)*time.Second + time.Duration(ms)*time.Millisecond, true
+}
+
+// ParseSRT parses a SubRip file into its cues. Markup (e.g. <i> and <font>) is removed from the text.
+// All errors are returned as TranscriptErrors.
+func ParseSRT(data string) ([]Cue, error) {
+	cues := make([]Cue, 0)
+	var errs TranscriptErrors
+	lines := strings.Split(strings.ReplaceAll(strings.TrimPrefix(data, "\ufeff"), "\r\n", "\n"), "\n")
+	i := 0
+	for i < len(lines) {
+		if strings.TrimSpace(lines[i]) == "" {
+			i++
+			continue
+		}
+		start := i
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			i++
+		}
+		block := lines[start:i]
+		// The counter is optional in practice.
+		timingLine := start
+		if !strings.Contains(block[0], "-->") {
+			if _, err := strconv.Atoi(strings.TrimSpace(block[0])); err != nil {
+				errs = append(errs, TranscriptError{Line: start + 1, Message: fmt.Sprintf("expected a cue number, got %q", block[0])})
+				continue
+			}
+			if len(block) == 1 || !strings.Contains(block[1], "-->") {
+				errs = append(errs, TranscriptError{Line: start + 2, Message: "expected cue timings (start --> end)"})
+				continue
+			}
+			timingLine++
+		}
+		startText, endText, _ := strings.Cut(lines[timingLine], "-->")
+		fields := strings.Fields(endText)
+		cueStart, ok := parseSRTTimestamp(strings.TrimSpace(startText))
+		if !ok {
+			errs = append(errs, TranscriptError{Line: timingLine + 1, Message: fmt.Sprintf("invalid start timestamp %q", strings.TrimSpace(startText))})
+			continue
+		}
+		if len(fields) == 0 {
+			errs = append(errs, TranscriptError{Line: timingLine + 1, Message: "missing end timestamp"})
+			continue
+		}
+		cueEnd, ok := parseSRTTimestamp(fields[0])
+		if !ok {
+			errs = append(errs, TranscriptError{Line: timingLine + 1, Message: fmt.Sprintf("invalid end timestamp %q", fields[0])})
+			continue
+		}
+		if cueEnd <= cueStart {
+			errs = append(errs, TranscriptError{Line: timingLine + 1, Message: "end is not after start"})
+			continue
+		}
+		text, _ := cueText(strings.Join(lines[timingLine+1:i], "\n"))
+		cues = append(cues, Cue{Start: cueStart, End: cueEnd, Text: text})
+	}
+	if len(errs) != 0 {
+		return cues, errs
+	}
+	if len(cues) == 0 {
+		return cues, TranscriptErrors{{Line: 1, Message: "no cues"}}
+	}
+	return cues, nil
+}
+
+type whisperSegment struct {
+	Start   *float64 `json:"start"`
+	End     *float64 `json:"end"`
+	Text    string   `json:"text"`
+	Speaker string   `json:"speaker"`
+	Words   []struct {
+		Word  string   `json:"word"`
+		Start *float64 `json:"start"`
+		End   *float64 `json:"end"`
+	} `json:"words"`
+}
+
+// lineAt returns the line (starting at 1) of the first value after offset in data, skipping whitespace and commas.
+func lineAt(data []byte, offset int64) int {
+	for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) != -1 {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// secondsDuration converts seconds (as in Whisper JSON) to a duration, rounded to milliseconds like WebVTT timestamps.
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds*1000)) * time.Millisecond
+}
+
+// ParseWhisperJSON parses Whisper JSON output into its cues, one per segment. The speaker of a segment (e.g. from WhisperX) is its cue's voice.
+// All errors are returned as TranscriptErrors, with the line of the JSON value they are in.
+func ParseWhisperJSON(data []byte) ([]Cue, error) {
+	cues := make([]Cue, 0)
+	var errs TranscriptErrors
+	syntaxError := func(err error) error {
+		var se *json.SyntaxError
+		var te *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &se):
+			return TranscriptErrors{{Line: lineAt(data, se.Offset-1), Message: se.Error()}}
+		case errors.As(err, &te):
+			return TranscriptErrors{{Line: lineAt(data, te.Offset-1), Message: te.Error()}}
+		case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+			return TranscriptErrors{{Line: lineAt(data, int64(len(data))), Message: "unexpected end of JSON"}}
+		}
+		return TranscriptErrors{{Line: 1, Message: err.Error()}}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return cues, syntaxError(err)
+	}
+	if tok != json.Delim('{') {
+		return cues, TranscriptErrors{{Line: 1, Message: "expected a JSON object"}}
+	}
+	found := false
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return cues, syntaxError(err)
+		}
+		if tok != "segments" {
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+			if err != nil {
+				return cues, syntaxError(err)
+			}
+			continue
+		}
+		found = true
+		segmentsLine := lineAt(data, dec.InputOffset())
+		tok, err = dec.Token()
+		if err != nil {
+			return cues, syntaxError(err)
+		}
+		if tok != json.Delim('[') {
+			return cues, TranscriptErrors{{Line: segmentsLine, Message: "segments is not an array"}}
+		}
+		for dec.More() {
+			line := lineAt(data, dec.InputOffset())
+			var seg whisperSegment
+			err = dec.Decode(&seg)
+			if err != nil {
+				return cues, syntaxError(err)
+			}
+			cue, err := seg.cue()
+			if err != nil {
+				errs = append(errs, TranscriptError{Line: line, Message: err.Error()})
+				continue
+			}
+			cues = append(cues, cue)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return cues, syntaxError(err)
+		}
+	}
+	if !found {
+		return cues, TranscriptErrors{{Line: 1, Message: "missing segments"}}
+	}
+	if len(errs) != 0 {
+		return cues, errs
+	}
+	return cues, nil
+}
+
+// cue validates a Whisper segment, and converts it to a cue.
+func (seg whisperSegment) cue() (Cue, error) {
+	if seg.Start == nil || seg.End == nil {
+		return Cue{}, errors.New("segment is missing start or end")
+	}
+	if *seg.Start < 0 || *seg.End < *seg.Start {
+		return Cue{}, fmt.Errorf("segment ends (%g) before it starts (%g)", *seg.End, *seg.Start)
+	}
+	for i, w := range seg.Words {
+		if w.Start != nil && w.End != nil && *w.End < *w.Start {
+			return Cue{}, fmt.Errorf("word %d (%q) ends before it starts", i, w.Word)
+		}
+	}
+	c := Cue{
+		Start: secondsDuration(*seg.Start),
+		End:   secondsDuration(*seg.End),
+		Text:  strings.TrimSpace(seg.Text),
+		Voice: strings.TrimSpace(seg.Speaker),
+	}
+	if c.End <= c.Start {
+		// Whisper emits zero-length segments (e.g. for a single word); cues must end after they start.
+		c.End = c.Start + time.Millisecond
+	}
+	return c, nil
+}
+
+// formatVTTTimestamp formats a duration as a WebVTT timestamp (hh:mm:ss.ttt).
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// FormatVTT formats cues as a WebVTT file. Voices are written as voice spans.
+func FormatVTT(cues []Cue) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, c := range cues {
+		b.WriteString("\n")
+		b.WriteString(formatVTTTimestamp(c.Start) + " --> " + formatVTTTimestamp(c.End) + "\n")
+		if c.Voice != "" {
+			b.WriteString("<v " + vttEscaper.Replace(c.Voice) + ">")
+		}
+		// Blank lines would end the cue.
+		lines := make([]string, 0)
+		for _, line := range strings.Split(c.Text, "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, vttEscaper.Replace(line))
+			}
+		}
+		b.WriteString(strings.Join(lines, "\n") + "\n")
+	}
+	return b.String()
+}
+
+// ConvertTranscript validates a transcript, and converts it to WebVTT.
+// WebVTT transcripts are kept as they are, so that markup and settings are not lost, and are only rejected if the WEBVTT header is missing.
+// Their cues with errors are skipped when the cues are stored (as in a sync), and the errors are returned as skipped.
+// An empty WebVTT transcript is converted to an empty transcript, which clears the sample's transcript.
+// Errors in transcripts in other formats are returned as TranscriptErrors.
+func ConvertTranscript(format TranscriptFormat, data []byte) (vtt string, skipped TranscriptErrors, err error) {
+	var cues []Cue
+	switch format {
+	case TranscriptVTT:
+		if strings.TrimSpace(string(data)) == "" {
+			return "", nil, nil
+		}
+		_, err = ParseVTT(string(data))
+		errors.As(err, &skipped)
+		if len(skipped) == 1 && skipped[0] == errVTTHeader {
+			return "", nil, err
+		}
+		return string(data), skipped, nil
+	case TranscriptSRT:
+		cues, err = ParseSRT(string(data))
+	case TranscriptWhisper:
+		cues, err = ParseWhisperJSON(data)
+	default:
+		return "", nil, fmt.Errorf("unknown transcript format %q", format)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return FormatVTT(cues), nil, nil
+}
+
+// SampleTranscriptImport converts a transcript to WebVTT and sets it as the sample's transcript, with author recorded in its revision.
+// The original of a converted transcript is kept next to the sample's media (see TranscriptOriginalExts); originals in other formats are removed.
+// Errors that reject the transcript (see ConvertTranscript) are returned as TranscriptErrors, and nothing is written.
+// The errors of skipped WebVTT cues are returned as skipped, after the transcript was written.
+func (s *Storage) SampleTranscriptImport(ctx context.Context, id string, format TranscriptFormat, data []byte, author string) (skipped TranscriptErrors, err error) {
+	vtt, skipped, err := ConvertTranscript(format, data)
+	if err != nil {
+		return nil, err
+	}
+	// The originals are only changed once the transcript is set, so that they are kept if setting it fails.
+	err = s.SampleTranscriptSet(id, vtt, author, ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.setTranscriptOriginal(id, format, data)
+	if err != nil {
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// setTranscriptOriginal writes the original of a transcript in format, and removes the originals in other formats.
+// For WebVTT transcripts, all originals are removed. The index is updated, as the originals are not synced.
+// A new original is written next to the transcript file, so ErrNoMedia is returned (before anything is changed) if the sample has neither a transcript file nor media.
+func (s *Storage) setTranscriptOriginal(id string, format TranscriptFormat, data []byte) error {
+	err := s.ensureIndex()
+	if err != nil {
+		return err
+	}
+	if ext, ok := TranscriptOriginalExts[format]; ok {
+		name, ok := s.index.get(id, ext)
+		if !ok {
+			transcript, ok := s.sidecarName(id, TranscriptExt)
+			if !ok {
+				return ErrNoMedia
+			}
+			name = path.Join(path.Dir(transcript), id+"."+ext)
+		}
+		err = s.writeFileAtomic(name, data)
+		if err != nil {
+			return fmt.Errorf("original: %w", err)
+		}
+		s.index.update([]string{name}, []fileRecord{{Name: name, SampleID: id}}, nil)
+	}
+	for f, ext := range TranscriptOriginalExts {
+		name, ok := s.index.get(id, ext)
+		if f == format || !ok {
+			continue
+		}
+		err = os.Remove(s.samplePath(name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("original: %w", err)
+		}
+		s.index.update([]string{name}, nil, nil)
+	}
+	return nil
+}
+
+// SampleTranscriptSet sets the sample's transcript file, and updates the transcript and its cues in the database (and so the search indexes) in one transaction.
+// The file is recorded in the ledger in the same transaction, so that the next sync does not see it as changed.
+// ErrSampleNotFound is returned if the sample has neither a row nor files, and ErrNoMedia if it has neither media (e.g. it is tombstoned) nor a transcript file, as there is nowhere to write the file.
+// Cues with errors are skipped, as in a sync. A revision is recorded with author (see Revision).
+func (s *Storage) SampleTranscriptSet(id string, transcript string, author string, ctx context.Context) error {
+	err := s.ensureIndex()
+	if err != nil {
+		return err
+	}
+	name, ok := s.sidecarName(id, TranscriptExt)
+	if !ok {
+		var n int
+		err = s.DB.GetContext(ctx, &n, "SELECT COUNT(*) FROM samples WHERE id=?", id)
+		if err != nil {
+			return err
+		}
+		if n == 0 && len(s.index.names(id)) == 0 {
+			return ErrSampleNotFound
+		}
+		return ErrNoMedia
+	}
+
+	s.syncMu.Lock()
+	record, err := s.setTranscript(ctx, id, name, transcript, author)
+	s.syncMu.Unlock()
+	if errors.Is(err, ErrSampleNotFound) {
+		// The sample's files are not synced yet; the sync imports them with the transcript.
+		names := s.index.names(id)
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		return s.SyncFileNames(ctx, names)
+	} else if err != nil {
+		return err
+	}
+	s.index.update([]string{name}, []fileRecord{record}, nil)
+	return nil
+}
+
+// setTranscript writes the transcript file name, and sets the transcript in the database. It returns the ledger record of the file.
+func (s *Storage) setTranscript(ctx context.Context, id, name, transcript, author string) (fileRecord, error) {
+	err := s.writeFileAtomic(name, []byte(transcript))
+	if err != nil {
+		return fileRecord{}, fmt.Errorf("write %s: %w", name, err)
+	}
+	info, err := os.Stat(s.samplePath(name))
+	if err != nil {
+		return fileRecord{}, err
+	}
+	h := sha256.Sum256([]byte(transcript))
+	record := fileRecord{
+		Name:     name,
+		SampleID: id,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		Hash:     hex.EncodeToString(h[:]),
+	}
+	return record, s.setTranscriptDB(ctx, id, transcript, author, record)
+}
+
+// setTranscriptDB sets the transcript and cues of a sample, and records its transcript file in the ledger. It returns ErrSampleNotFound if the sample has no row.
+func (s *Storage) setTranscriptDB(ctx context.Context, id, transcript, author string, file fileRecord) error {
+	var cues []Cue
+	if transcript != "" {
+		var err error
+		cues, err = ParseVTT(transcript)
+		if err != nil {
+			log.Printf("transcript of %s: %s", id, err)
+		}
+	}
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, "UPDATE samples SET transcript=? WHERE id=?", transcript, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrSampleNotFound
+	}
+	err = replaceCues(ctx, tx, id, cues)
+	if err != nil {
+		return fmt.Errorf("cues: %w", err)
+	}
+	err = recordRevision(ctx, tx, id, RevisionTranscript, transcript, author, time.Now())
+	if err != nil {
+		return fmt.Errorf("revision: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO files (name, sample_id, size, mtime, hash) VALUES (?, ?, ?, ?, ?) ON CONFLICT (name) DO UPDATE SET sample_id=excluded.sample_id, size=excluded.size, mtime=excluded.mtime, hash=excluded.hash", file.Name, file.SampleID, file.Size, file.ModTime, file.Hash)
+	if err != nil {
+		return fmt.Errorf("ledger: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SampleTranscriptOriginal returns the name (relative to the samples directory) of the original of the sample's converted transcript, if any.
+func (s *Storage) SampleTranscriptOriginal(id string) (string, bool) {
+	err := s.ensureIndex()
+	if err != nil {
+		return "", false
+	}
+	for _, ext := range TranscriptOriginalExts {
+		if name, ok := s.index.get(id, ext); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,215 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSampleTranscriptSet(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	err := os.WriteFile(filepath.Join(s.SamplesPath, id+".opus"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vtt := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nhello\n"
+	err = s.SampleTranscriptSet(id, vtt, "alice", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(s.SamplesPath, id+"."+TranscriptExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != vtt {
+		t.Fatalf("expected the file to have the transcript, got %q", data)
+	}
+	var transcript string
+	err = s.DB.GetContext(ctx, &transcript, "SELECT transcript FROM samples WHERE id=?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcript != vtt {
+		t.Fatalf("expected the row to have the transcript, got %q", transcript)
+	}
+	cues, err := s.SampleCues(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 1 || cues[0].Text != "hello" {
+		t.Fatalf("expected the cue, got %+v", cues)
+	}
+	sps, err := s.Search(SearchOptions{Query: "hello"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sps) != 1 || sps[0].CueOffset == nil || *sps[0].CueOffset != time.Second {
+		t.Fatalf("expected the sample to be found at its cue, got %+v", sps)
+	}
+	report, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Updated) != 0 {
+		t.Fatalf("expected the file to be in the ledger, got %+v", report)
+	}
+	r, ok := ledgerRecord(t, s, id+"."+TranscriptExt)
+	if !ok || r.SampleID != id || r.Size != int64(len(vtt)) || r.Hash != summaryHash(vtt) {
+		t.Fatalf("expected the ledger to have the file, got %+v", r)
+	}
+	cues2, err := s.SampleCues(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues2) != 1 || cues2[0].ID != cues[0].ID {
+		t.Fatalf("expected the cues to be kept, got %+v", cues2)
+	}
+
+	for _, ext := range []string{"opus", TranscriptExt} {
+		err = os.Remove(filepath.Join(s.SamplesPath, id+"."+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SampleTranscriptSet(id, "WEBVTT\n", "alice", ctx)
+	if !errors.Is(err, ErrNoMedia) {
+		t.Fatalf("expected ErrNoMedia for a tombstoned sample, got %v", err)
+	}
+	err = s.SampleTranscriptSet("2024-01-03T10:00:00+00:00", "WEBVTT\n", "alice", ctx)
+	if !errors.Is(err, ErrSampleNotFound) {
+		t.Fatalf("expected ErrSampleNotFound for an unknown sample, got %v", err)
+	}
+}
+
+func TestSampleTranscriptSetUnsynced(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x"})
+	err := s.ensureIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vtt := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nhello\n"
+	err = s.SampleTranscriptSet(id, vtt, "alice", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcript string
+	err = s.DB.GetContext(ctx, &transcript, "SELECT transcript FROM samples WHERE id=?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcript != vtt {
+		t.Fatalf("expected the sample to be imported with the transcript, got %q", transcript)
+	}
+}
+
+func TestSampleTranscriptImportVTT(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x"})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vtt := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nhello\n\n00:00:03 --> 00:00:04.000\nbad start\n"
+	skipped, err := s.SampleTranscriptImport(ctx, id, TranscriptVTT, []byte(vtt), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0].Line != 6 {
+		t.Fatalf("expected the cue on line 6 to be skipped, got %v", skipped)
+	}
+	cues, err := s.SampleCues(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 1 || cues[0].Text != "hello" {
+		t.Fatalf("expected the valid cue, got %+v", cues)
+	}
+
+	_, err = s.SampleTranscriptImport(ctx, id, TranscriptVTT, []byte("hello\n"), "alice")
+	var errs TranscriptErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected TranscriptErrors for a missing header, got %v", err)
+	}
+
+	_, err = s.SampleTranscriptImport(ctx, id, TranscriptVTT, nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcript string
+	err = s.DB.GetContext(ctx, &transcript, "SELECT transcript FROM samples WHERE id=?", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcript != "" {
+		t.Fatalf("expected the transcript to be cleared, got %q", transcript)
+	}
+	cues, err = s.SampleCues(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 0 {
+		t.Fatalf("expected no cues, got %+v", cues)
+	}
+}
+
+func TestSampleTranscriptImportArchived(t *testing.T) {
+	s := newTestStorage(t)
+	s.RetentionRules = []RetentionRule{{Name: "old", OlderThanDays: 1}}
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{
+		"2024/" + id + ".opus":             "x",
+		"2024/" + id + "." + TranscriptExt: "WEBVTT\n",
+	})
+	_, err := s.Sync(ctx, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ApplyRetention(ctx, RetentionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.SampleTranscriptImport(ctx, id, TranscriptVTT, []byte("WEBVTT\n\n00:01.000 --> 00:02.000\nvtt\n"), "alice")
+	if err != nil {
+		t.Fatalf("expected the transcript of an archived sample to be set, got %v", err)
+	}
+	_, err = s.SampleTranscriptImport(ctx, id, TranscriptSRT, []byte("1\n00:00:01,000 --> 00:00:02,000\nsrt\n"), "alice")
+	if err != nil {
+		t.Fatalf("expected the transcript of an archived sample to be set, got %v", err)
+	}
+	original, _ := s.SampleTranscriptOriginal(id)
+	if original != "2024/"+id+".srt" {
+		t.Fatalf("expected the original next to the transcript, got %q", original)
+	}
+	cues, err := s.SampleCues(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 1 || cues[0].Text != "srt" {
+		t.Fatalf("expected the converted cue, got %+v", cues)
+	}
+}
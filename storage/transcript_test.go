@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSRT(t *testing.T) {
+	data := "1\r\n00:00:01,000 --> 00:00:02,500\r\n<i>Hello</i> & welcome\r\nsecond line\r\n\r\n2\r\n01:00:00.000 --> 01:00:01.000\r\nplain\r\n"
+	cues, err := ParseSRT(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Cue{
+		{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello & welcome\nsecond line"},
+		{Start: time.Hour, End: time.Hour + time.Second, Text: "plain"},
+	}
+	if !slices.Equal(cues, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, cues)
+	}
+
+	_, err = ParseSRT("1\n00:00:01,000 --> 00:00:02,000\nok\n\nx\n00:00:03,000 --> 00:00:04,000\nbad number\n\n3\n00:00:05 --> 00:00:06,000\nbad start\n")
+	var errs TranscriptErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected TranscriptErrors, got %v", err)
+	}
+	lines := make([]int, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Line
+	}
+	if !slices.Equal(lines, []int{5, 10}) {
+		t.Fatalf("expected errors on lines 5 and 10, got %v", errs)
+	}
+}
+
+func TestParseWhisperJSON(t *testing.T) {
+	data := `{
+  "text": "Hello there. Bye.",
+  "segments": [
+    {"start": 0.0, "end": 1.25, "text": " Hello there.", "speaker": "SPEAKER_00",
+     "words": [{"word": "Hello", "start": 0.0, "end": 0.5}]},
+    {"start": 2, "end": 2, "text": " Bye."}
+  ],
+  "language": "en"
+}`
+	cues, err := ParseWhisperJSON([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Cue{
+		{Start: 0, End: 1250 * time.Millisecond, Text: "Hello there.", Voice: "SPEAKER_00"},
+		{Start: 2 * time.Second, End: 2*time.Second + time.Millisecond, Text: "Bye."},
+	}
+	if !slices.Equal(cues, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, cues)
+	}
+
+	for _, c := range []struct {
+		data string
+		line int
+	}{
+		{"{\n\"segments\": [\n{\"start\": 0, \"end\": 1, \"text\": \"ok\"},\n{\"start\": 3, \"end\": 2, \"text\": \"backwards\"}\n]}", 4},
+		{"{\n\"segments\": [\n{\"start\": 0, \"text\": \"no end\"}\n]}", 3},
+		{"{\n\"segments\": [\n{\"start\": 0, \"end\": 1, \"text\": \"ok\"}\n,,]}", 4},
+		{"{\"text\": \"no segments\"}", 1},
+	} {
+		_, err = ParseWhisperJSON([]byte(c.data))
+		var errs TranscriptErrors
+		if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Line != c.line {
+			t.Fatalf("%q: expected an error on line %d, got %v", c.data, c.line, err)
+		}
+	}
+}
+
+func TestFormatVTT(t *testing.T) {
+	cues := []Cue{
+		{Start: 1500 * time.Millisecond, End: time.Hour + 2*time.Second, Text: "a < b & c\n\nsecond line", Voice: "Alice"},
+		{Start: 3 * time.Hour, End: 3*time.Hour + time.Millisecond, Text: "plain"},
+	}
+	vtt := FormatVTT(cues)
+	expectedVTT := "WEBVTT\n\n00:00:01.500 --> 01:00:02.000\n<v Alice>a &lt; b &amp; c\nsecond line\n\n03:00:00.000 --> 03:00:00.001\nplain\n"
+	if vtt != expectedVTT {
+		t.Fatalf("expected %q, got %q", expectedVTT, vtt)
+	}
+	parsed, err := ParseVTT(vtt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cues[0].Text = "a < b & c\nsecond line"
+	if !slices.Equal(parsed, cues) {
+		t.Fatalf("expected %+v, got %+v", cues, parsed)
+	}
+}
+
+func TestConvertTranscriptVTT(t *testing.T) {
+	vtt, skipped, err := ConvertTranscript(TranscriptVTT, nil)
+	if err != nil || vtt != "" || skipped != nil {
+		t.Fatalf("expected an empty transcript, got %q, %v, %v", vtt, skipped, err)
+	}
+
+	_, _, err = ConvertTranscript(TranscriptVTT, []byte("00:01.000 --> 00:02.000\nhi\n"))
+	var errs TranscriptErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Line != 1 {
+		t.Fatalf("expected a header error on line 1, got %v", err)
+	}
+
+	data := "WEBVTT\n\n00:01.000 --> 00:02.000\nok\n\n00:05.000 --> 00:04.000\nbackwards\n"
+	vtt, skipped, err = ConvertTranscript(TranscriptVTT, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vtt != data {
+		t.Fatalf("expected the transcript as it is, got %q", vtt)
+	}
+	if len(skipped) != 1 || skipped[0].Line != 6 {
+		t.Fatalf("expected the cue on line 6 to be skipped, got %v", skipped)
+	}
+}
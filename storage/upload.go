@@ -0,0 +1,426 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadsDir is the staging directory for uploads, relative to the samples directory.
+// It is hidden, so syncs and the watcher ignore partial uploads.
+const uploadsDir = ".uploads"
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadBusy       = errors.New("upload is being written to")
+	ErrUploadOffset     = errors.New("offset does not match the uploaded size")
+	ErrUploadTooLarge   = errors.New("upload is larger than its declared size")
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	ErrInvalidSampleID  = errors.New("invalid sample ID")
+	ErrSampleExists     = errors.New("sample already has files")
+)
+
+// Upload is a resumable upload of a sample's media.
+// The media is written to the staging directory in chunks, and moved into the samples directory when the upload is completed.
+type Upload struct {
+	ID        string    `db:"id" json:"id"`
+	SampleID  string    `db:"sample_id" json:"sample_id"`
+	Ext       string    `db:"ext" json:"ext"`
+	Size      int64     `db:"size" json:"size"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	// Offset is the number of bytes uploaded so far.
+	Offset int64 `db:"-" json:"offset"`
+}
+
+// lockUpload locks the upload with the given ID for writing, or returns ErrUploadBusy if another request has it locked.
+// unlock forgets the lock if the upload is gone (completed, cancelled, swept, or it never existed), so that locks do not pile up.
+func (s *Storage) lockUpload(id string) (unlock func(), err error) {
+	v, _ := s.uploadLocks.LoadOrStore(id, new(sync.Mutex))
+	mu := v.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, ErrUploadBusy
+	}
+	return func() {
+		var n int
+		err := s.DB.Get(&n, "SELECT COUNT(*) FROM uploads WHERE id=?", id)
+		if err == nil && n == 0 {
+			s.uploadLocks.CompareAndDelete(id, mu)
+		}
+		mu.Unlock()
+	}, nil
+}
+
+func (s *Storage) uploadPartPath(id string) string {
+	return filepath.Join(s.SamplesPath, uploadsDir, id+".part")
+}
+
+// CheckSampleID returns ErrInvalidSampleID if id cannot be used as a sample ID for new files.
+// The ID must be a plain file name, and one of s.FilenameParsers must accept it.
+func (s *Storage) CheckSampleID(id string) error {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("%w: must be a file name", ErrInvalidSampleID)
+	}
+	if _, ok := s.parseStart(id); !ok {
+		return fmt.Errorf("%w: no filename parser accepts %q", ErrInvalidSampleID, id)
+	}
+	return nil
+}
+
+// UploadCreate starts an upload of size bytes of media with the extension ext for a new sample.
+func (s *Storage) UploadCreate(ctx context.Context, sampleID, ext string, size int64) (Upload, error) {
+	err := s.CheckSampleID(sampleID)
+	if err != nil {
+		return Upload{}, err
+	}
+	if _, ok := MediaFileTypes[ext]; !ok {
+		return Upload{}, fmt.Errorf("%w: %q", ErrUnsupportedMedia, ext)
+	}
+	if size <= 0 {
+		return Upload{}, errors.New("size must be positive")
+	}
+	err = s.ensureIndex()
+	if err != nil {
+		return Upload{}, err
+	}
+	if len(s.index.names(sampleID)) != 0 {
+		return Upload{}, ErrSampleExists
+	}
+
+	buf := make([]byte, 16)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return Upload{}, err
+	}
+	u := Upload{
+		ID:        hex.EncodeToString(buf),
+		SampleID:  sampleID,
+		Ext:       ext,
+		Size:      size,
+		CreatedAt: time.Now(),
+	}
+	err = os.MkdirAll(filepath.Join(s.SamplesPath, uploadsDir), 0755)
+	if err != nil {
+		return Upload{}, err
+	}
+	f, err := os.OpenFile(s.uploadPartPath(u.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return Upload{}, err
+	}
+	err = f.Close()
+	if err != nil {
+		return Upload{}, err
+	}
+	_, err = s.DB.NamedExecContext(ctx, "INSERT INTO uploads (id, sample_id, ext, size, created_at) VALUES (:id, :sample_id, :ext, :size, :created_at)", u)
+	if err != nil {
+		os.Remove(s.uploadPartPath(u.ID))
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+// UploadGet returns the upload with the given ID, including how much has been uploaded so far.
+func (s *Storage) UploadGet(ctx context.Context, id string) (Upload, error) {
+	var u Upload
+	err := s.DB.GetContext(ctx, &u, "SELECT * FROM uploads WHERE id=?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Upload{}, ErrUploadNotFound
+	} else if err != nil {
+		return Upload{}, err
+	}
+	info, err := os.Stat(s.uploadPartPath(id))
+	if err != nil {
+		return Upload{}, fmt.Errorf("stat partial upload: %w", err)
+	}
+	u.Offset = info.Size()
+	return u, nil
+}
+
+// UploadWrite appends the data read from r to the upload, starting at offset.
+// offset must match the number of bytes uploaded so far; otherwise, ErrUploadOffset is returned.
+// If reading from r fails midway (e.g. the client disconnected), the data read so far is kept, so that the client can resume from the returned offset.
+func (s *Storage) UploadWrite(ctx context.Context, id string, offset int64, r io.Reader) (int64, error) {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	u, err := s.UploadGet(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != u.Offset {
+		return u.Offset, ErrUploadOffset
+	}
+	f, err := os.OpenFile(s.uploadPartPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return u.Offset, err
+	}
+	defer f.Close()
+	// Read one byte more than the remaining size to notice uploads that are too large.
+	n, copyErr := io.Copy(f, io.LimitReader(r, u.Size-u.Offset+1))
+	if u.Offset+n > u.Size {
+		// Discard the whole chunk, as it is probably not what the client meant to upload.
+		err = f.Truncate(u.Offset)
+		if err != nil {
+			return u.Offset, fmt.Errorf("truncate: %w", err)
+		}
+		return u.Offset, ErrUploadTooLarge
+	}
+	err = f.Sync()
+	if err != nil {
+		return u.Offset, err
+	}
+	if copyErr != nil {
+		return u.Offset + n, fmt.Errorf("read: %w", copyErr)
+	}
+	return u.Offset + n, nil
+}
+
+// UploadCancel deletes the upload and the data uploaded so far.
+func (s *Storage) UploadCancel(ctx context.Context, id string) error {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.uploadDelete(ctx, id)
+}
+
+func (s *Storage) uploadDelete(ctx context.Context, id string) error {
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM uploads WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUploadNotFound
+	}
+	err = os.Remove(s.uploadPartPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// UploadComplete moves the uploaded media, and the summary, transcript and metadata (sidecar JSON) if not empty, into the samples directory, and syncs the new sample.
+// The files are moved into s.UploadDir.
+// Each file is written to the staging directory first and linked into place, so that syncs never see a partially written file.
+// The media is moved last, so that the sample is synced with its summary and transcript.
+// The names of the new files (relative to the samples directory) are returned.
+// The transcript must be WebVTT; if ConvertTranscript rejects it, its errors are returned as TranscriptErrors, and nothing is moved.
+func (s *Storage) UploadComplete(ctx context.Context, id string, summary, transcript, metadata string) ([]string, error) {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	u, err := s.UploadGet(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != "" {
+		_, err = ParseSampleMetadata([]byte(metadata))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if transcript != "" {
+		// validated like the transcripts set through SampleTranscriptImport
+		_, _, err = ConvertTranscript(TranscriptVTT, []byte(transcript))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if u.Offset != u.Size {
+		return nil, fmt.Errorf("%w: %d of %d bytes uploaded", ErrUploadIncomplete, u.Offset, u.Size)
+	}
+	err = s.ensureIndex()
+	if err != nil {
+		return nil, err
+	}
+	if len(s.index.names(u.SampleID)) != 0 {
+		return nil, ErrSampleExists
+	}
+
+	dir, err := s.uploadDir(u.SampleID)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		name    string
+		staging string
+	}
+	files := make([]file, 0, 4)
+	for _, f := range []struct{ ext, body string }{{SummaryExt, summary}, {TranscriptExt, transcript}, {MetadataExt, metadata}} {
+		if f.body == "" {
+			continue
+		}
+		staging, err := s.writeStagingFile(f.body)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(staging)
+		files = append(files, file{name: path.Join(dir, u.SampleID+"."+f.ext), staging: staging})
+	}
+	files = append(files, file{name: path.Join(dir, u.SampleID+"."+u.Ext), staging: s.uploadPartPath(id)})
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		// Link instead of renaming, as linking fails if the file exists, so files that appeared since the index was last updated
+		// (e.g. from another upload of the same sample completing at the same time) are never overwritten.
+		err = os.Link(f.staging, s.samplePath(f.name))
+		if err != nil {
+			for _, name := range names {
+				os.Remove(s.samplePath(name))
+			}
+			if os.IsExist(err) {
+				return nil, fmt.Errorf("%w: %s", ErrSampleExists, f.name)
+			}
+			return nil, fmt.Errorf("move %s: %w", f.name, err)
+		}
+		names = append(names, f.name)
+	}
+
+	err = s.uploadDelete(ctx, id)
+	if err != nil && !errors.Is(err, ErrUploadNotFound) {
+		return nil, fmt.Errorf("delete upload: %w", err)
+	}
+	err = s.SyncFileNames(ctx, names)
+	if err != nil {
+		return names, fmt.Errorf("sync: %w", err)
+	}
+	return names, nil
+}
+
+// uploadDir returns the directory (relative to the samples directory) the files of a completed upload of the sample are moved into, and creates it.
+func (s *Storage) uploadDir(sampleID string) (string, error) {
+	if s.UploadDir == "" {
+		return "", nil
+	}
+	start, ok := s.parseStart(sampleID)
+	if !ok {
+		return "", fmt.Errorf("%w: no filename parser accepts %q", ErrInvalidSampleID, sampleID)
+	}
+	dir := start.Format(s.UploadDir)
+	if !filepath.IsLocal(filepath.FromSlash(dir)) || strings.HasPrefix(dir, ".") || strings.Contains(dir, "/.") {
+		return "", fmt.Errorf("upload directory %q is not a visible subdirectory of the samples directory", dir)
+	}
+	err := os.MkdirAll(s.samplePath(dir), 0755)
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// SweepUploads removes the unfinished uploads older than s.UploadTTL, and leftover files in the staging directory.
+// Uploads being written to are skipped. The number of uploads removed is returned.
+func (s *Storage) SweepUploads(ctx context.Context) (int, error) {
+	if s.UploadTTL <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-s.UploadTTL)
+	ids := make([]string, 0)
+	err := s.DB.SelectContext(ctx, &ids, "SELECT id FROM uploads WHERE unixepoch(created_at) < ?", cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range ids {
+		unlock, err := s.lockUpload(id)
+		if errors.Is(err, ErrUploadBusy) {
+			continue
+		} else if err != nil {
+			return removed, err
+		}
+		err = s.uploadDelete(ctx, id)
+		unlock()
+		if errors.Is(err, ErrUploadNotFound) {
+			continue
+		} else if err != nil {
+			return removed, fmt.Errorf("upload %s: %w", id, err)
+		}
+		removed++
+	}
+
+	// Partial uploads without a row (e.g. the row was inserted in a database that was restored from a backup) and staging files left by crashes.
+	entries, err := os.ReadDir(filepath.Join(s.SamplesPath, uploadsDir))
+	if os.IsNotExist(err) {
+		return removed, nil
+	} else if err != nil {
+		return removed, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if id, ok := strings.CutSuffix(entry.Name(), ".part"); ok {
+			var n int
+			err = s.DB.GetContext(ctx, &n, "SELECT COUNT(*) FROM uploads WHERE id=?", id)
+			if err != nil || n != 0 {
+				continue
+			}
+		}
+		err = os.Remove(filepath.Join(s.SamplesPath, uploadsDir, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("sweep uploads: %s", err)
+		}
+	}
+	return removed, nil
+}
+
+// RunUploadSweeper calls SweepUploads every interval until ctx is done.
+func (s *Storage) RunUploadSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := s.SweepUploads(ctx)
+		if err != nil {
+			log.Printf("sweep uploads: %s", err)
+		} else if n != 0 {
+			log.Printf("removed %d stale uploads.", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeStagingFile writes body to a new file in the staging directory and returns its path.
+func (s *Storage) writeStagingFile(body string) (string, error) {
+	f, err := os.CreateTemp(filepath.Join(s.SamplesPath, uploadsDir), "*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(body)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
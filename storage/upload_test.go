@@ -0,0 +1,159 @@
+//go:build fts5
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func uploadLockCount(s *Storage) int {
+	n := 0
+	s.uploadLocks.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestUploadComplete(t *testing.T) {
+	s := newTestStorage(t)
+	s.UploadDir = "2006/01"
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	u, err := s.UploadCreate(ctx, id, "opus", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UploadWrite(ctx, u.ID, 0, bytes.NewReader([]byte("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a file of the sample appears after the upload was created
+	err = os.MkdirAll(filepath.Join(s.SamplesPath, "2024", "01"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(s.SamplesPath, "2024", "01", id+".opus"), []byte("existing"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UploadComplete(ctx, u.ID, "summary", "", "")
+	if !errors.Is(err, ErrSampleExists) {
+		t.Fatalf("expected ErrSampleExists, got %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(s.SamplesPath, "2024", "01", id+".opus"))
+	if err != nil || string(data) != "existing" {
+		t.Fatalf("existing media was overwritten: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(s.SamplesPath, "2024", "01", id+".txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the summary to be removed again, got %v", err)
+	}
+
+	err = os.Remove(filepath.Join(s.SamplesPath, "2024", "01", id+".opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := s.UploadComplete(ctx, u.ID, "summary", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[1] != "2024/01/"+id+".opus" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if n := uploadLockCount(s); n != 0 {
+		t.Fatalf("expected no upload locks left, got %d", n)
+	}
+	_, err = s.UploadWrite(ctx, "nonexistent", 0, bytes.NewReader(nil))
+	if !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected ErrUploadNotFound, got %v", err)
+	}
+	if n := uploadLockCount(s); n != 0 {
+		t.Fatalf("expected no upload locks left, got %d", n)
+	}
+}
+
+func TestSweepUploads(t *testing.T) {
+	s := newTestStorage(t)
+	s.UploadTTL = time.Hour
+	ctx := context.Background()
+	old, err := s.UploadCreate(ctx, "2024-01-02T10:00:00+00:00", "opus", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := s.UploadCreate(ctx, "2024-01-02T11:00:00+00:00", "opus", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.DB.ExecContext(ctx, "UPDATE uploads SET created_at=? WHERE id=?", time.Now().Add(-2*time.Hour), old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(s.SamplesPath, uploadsDir, "orphan.part")
+	err = os.WriteFile(orphan, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(orphan, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.SweepUploads(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 upload removed, got %d", n)
+	}
+	if _, err := s.UploadGet(ctx, old.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected the old upload to be removed, got %v", err)
+	}
+	if _, err := s.UploadGet(ctx, fresh.ID); err != nil {
+		t.Fatalf("expected the fresh upload to be kept, got %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("expected the orphaned partial upload to be removed, got %v", err)
+	}
+}
+
+func TestUploadCompleteInvalidTranscript(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	id := "2024-01-02T10:00:00+00:00"
+	u, err := s.UploadCreate(ctx, id, "opus", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UploadWrite(ctx, u.ID, 0, bytes.NewReader([]byte("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UploadComplete(ctx, u.ID, "summary", "not a transcript", "")
+	var terrs TranscriptErrors
+	if !errors.As(err, &terrs) {
+		t.Fatalf("expected TranscriptErrors, got %v", err)
+	}
+	entries, err := os.ReadDir(s.SamplesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != uploadsDir {
+			t.Fatalf("expected nothing to be moved, got %s", e.Name())
+		}
+	}
+
+	names, err := s.UploadComplete(ctx, u.ID, "summary", "WEBVTT\n\n00:00.000 --> 00:01.000\nhello\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Fatalf("unexpected names %v", names)
+	}
+}
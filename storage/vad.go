@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// SpeechSegment is a part of a sample in which speech was detected, by offsets relative to the start of the sample.
+type SpeechSegment struct {
+	SampleID string        `db:"sample_id" json:"-"`
+	Start    time.Duration `db:"start_offset" json:"start"`
+	End      time.Duration `db:"end_offset" json:"end"`
+}
+
+const (
+	// speechMinLevel is the RMS level (of full scale) below which audio is never speech (about -46 dBFS).
+	speechMinLevel = 0.005
+	// speechNoiseRatio is how much louder than the noise floor speech is (about 12 dB).
+	speechNoiseRatio = 4
+	// speechMaxGap is the longest pause within a speech segment.
+	speechMaxGap = 500 * time.Millisecond
+	// speechMinLength is the shortest speech segment; shorter ones are noise (e.g. clicks).
+	speechMinLength = 250 * time.Millisecond
+)
+
+// detectSpeech returns the speech segments in audio with the given RMS levels of consecutive frames, each frameLength long.
+// Frames louder than both speechMinLevel and speechNoiseRatio times the noise floor (the 10th percentile level) are speech.
+func detectSpeech(levels []float64, frameLength time.Duration) []SpeechSegment {
+	segments := make([]SpeechSegment, 0)
+	if len(levels) == 0 {
+		return segments
+	}
+	sorted := slices.Clone(levels)
+	slices.Sort(sorted)
+	threshold := max(speechMinLevel, sorted[len(sorted)/10]*speechNoiseRatio)
+
+	var cur *SpeechSegment
+	for i, level := range levels {
+		if level < threshold {
+			continue
+		}
+		start := time.Duration(i) * frameLength
+		end := start + frameLength
+		if cur != nil && start-cur.End <= speechMaxGap {
+			cur.End = end
+			continue
+		}
+		if cur != nil && cur.End-cur.Start >= speechMinLength {
+			segments = append(segments, *cur)
+		}
+		cur = &SpeechSegment{Start: start, End: end}
+	}
+	if cur != nil && cur.End-cur.Start >= speechMinLength {
+		segments = append(segments, *cur)
+	}
+	return segments
+}
+
+// speechDuration returns the total duration of the segments.
+func speechDuration(segments []SpeechSegment) time.Duration {
+	var d time.Duration
+	for _, seg := range segments {
+		d += seg.End - seg.Start
+	}
+	return d
+}
+
+// SampleSpeech returns the speech segments of a sample, in order.
+func (s *Storage) SampleSpeech(ctx context.Context, id string) ([]SpeechSegment, error) {
+	segments := make([]SpeechSegment, 0)
+	err := s.DB.SelectContext(ctx, &segments, "SELECT * FROM speech_segments WHERE sample_id=? ORDER BY start_offset", id)
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
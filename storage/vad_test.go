@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// levelsOf returns 10ms levels of noise at 0.001, with speech at 0.1 in the given ranges (in frames).
+func levelsOf(n int, speech ...[2]int) []float64 {
+	levels := make([]float64, n)
+	for i := range levels {
+		levels[i] = 0.001
+	}
+	for _, r := range speech {
+		for i := r[0]; i < r[1]; i++ {
+			levels[i] = 0.1
+		}
+	}
+	return levels
+}
+
+func TestDetectSpeech(t *testing.T) {
+	const frame = 10 * time.Millisecond
+	cases := []struct {
+		name     string
+		levels   []float64
+		expected []SpeechSegment
+	}{
+		{"empty", nil, []SpeechSegment{}},
+		{"silence", levelsOf(1000), []SpeechSegment{}},
+		{"speech", levelsOf(1000, [2]int{100, 200}), []SpeechSegment{{Start: time.Second, End: 2 * time.Second}}},
+		{"short pause", levelsOf(1000, [2]int{100, 200}, [2]int{240, 300}), []SpeechSegment{{Start: time.Second, End: 3 * time.Second}}},
+		{"long pause", levelsOf(1000, [2]int{100, 200}, [2]int{300, 400}), []SpeechSegment{
+			{Start: time.Second, End: 2 * time.Second},
+			{Start: 3 * time.Second, End: 4 * time.Second},
+		}},
+		{"click", levelsOf(1000, [2]int{100, 105}), []SpeechSegment{}},
+		{"quiet", func() []float64 {
+			levels := levelsOf(1000, [2]int{100, 200})
+			for i := 100; i < 200; i++ {
+				levels[i] = speechMinLevel / 2
+			}
+			return levels
+		}(), []SpeechSegment{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			segments := detectSpeech(c.levels, frame)
+			if !slices.Equal(segments, c.expected) {
+				t.Fatalf("expected %v, got %v", c.expected, segments)
+			}
+		})
+	}
+}
+
+func TestDetectSpeechNoiseFloor(t *testing.T) {
+	// Constant loud noise (e.g. wind) is not speech, but speech louder than it is.
+	levels := levelsOf(1000, [2]int{100, 200})
+	for i := range levels {
+		levels[i] += 0.02
+	}
+	segments := detectSpeech(levels, 10*time.Millisecond)
+	expected := []SpeechSegment{{Start: time.Second, End: 2 * time.Second}}
+	if !slices.Equal(segments, expected) {
+		t.Fatalf("expected %v, got %v", expected, segments)
+	}
+	if d := speechDuration(segments); d != time.Second {
+		t.Fatalf("expected 1s of speech, got %s", d)
+	}
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Cue is a cue of a sample's WebVTT transcript, by offsets relative to the start of the sample.
+type Cue struct {
+	ID       int64         `db:"id" json:"-"`
+	SampleID string        `db:"sample_id" json:"-"`
+	Start    time.Duration `db:"start_offset" json:"start"`
+	End      time.Duration `db:"end_offset" json:"end"`
+	// Text is the cue's text without markup; lines are separated by newlines.
+	Text string `db:"text" json:"text"`
+	// Voice is the speaker from the cue's first voice span (<v Speaker>), if any.
+	Voice string `db:"voice" json:"voice,omitempty"`
+}
+
+// TranscriptError is an error in a transcript at a line (starting at 1).
+type TranscriptError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e TranscriptError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// TranscriptErrors are all the errors found in a transcript.
+type TranscriptErrors []TranscriptError
+
+func (errs TranscriptErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// errVTTHeader is the error of a WebVTT file without the WEBVTT header, which is not parsed any further.
+var errVTTHeader = TranscriptError{Line: 1, Message: "missing WEBVTT header"}
+
+var (
+	vttTimestamp = regexp.MustCompile(`^(?:(\d{2,}):)?([0-5]\d):([0-5]\d)\.(\d{3})$`)
+	vttVoice     = regexp.MustCompile(`<v(?:\.[^\s>]*)?[ \t]+([^>]*)>`)
+	vttTag       = regexp.MustCompile(`</?[^>]*>`)
+)
+
+// parseVTTTimestamp parses a WebVTT timestamp ([hh:]mm:ss.ttt).
+func parseVTTTimestamp(s string) (time.Duration, bool) {
+	m := vttTimestamp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	var d time.Duration
+	if m[1] != "" {
+		h, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		d += time.Duration(h) * time.Hour
+	}
+	mins, _ := strconv.Atoi(m[2])
+	secs, _ := strconv.Atoi(m[3])
+	ms, _ := strconv.Atoi(m[4])
+	return d + time.Duration(mins)*time.Minute + time.Duration(secs)*time.Second + time.Duration(ms)*time.Millisecond, true
+}
+
+// cueText returns the text of a cue payload without markup, and the voice of its first voice span.
+func cueText(payload string) (text, voice string) {
+	if m := vttVoice.FindStringSubmatch(payload); m != nil {
+		voice = html.UnescapeString(strings.TrimSpace(m[1]))
+	}
+	text = html.UnescapeString(vttTag.ReplaceAllString(payload, ""))
+	return strings.TrimSpace(text), voice
+}
+
+// ParseVTT parses a WebVTT file into its cues.
+// Cues with errors are skipped, and the errors are returned as TranscriptErrors along with the other cues.
+func ParseVTT(data string) ([]Cue, error) {
+	cues := make([]Cue, 0)
+	var errs TranscriptErrors
+	lines := strings.Split(strings.ReplaceAll(strings.TrimPrefix(data, "\ufeff"), "\r\n", "\n"), "\n")
+	if len(lines) == 0 || !(lines[0] == "WEBVTT" || strings.HasPrefix(lines[0], "WEBVTT ") || strings.HasPrefix(lines[0], "WEBVTT\t")) {
+		return cues, TranscriptErrors{errVTTHeader}
+	}
+	i := 1
+	// the rest of the header
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+		i++
+	}
+	for i < len(lines) {
+		if strings.TrimSpace(lines[i]) == "" {
+			i++
+			continue
+		}
+		// A block runs until a blank line.
+		start := i
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			i++
+		}
+		block := lines[start:i]
+		if first := block[0]; first == "NOTE" || strings.HasPrefix(first, "NOTE ") || strings.HasPrefix(first, "NOTE\t") || first == "STYLE" || first == "REGION" {
+			continue
+		}
+		// An optional identifier comes before the timings.
+		timingLine := start
+		if !strings.Contains(block[0], "-->") {
+			if len(block) == 1 || !strings.Contains(block[1], "-->") {
+				errs = append(errs, TranscriptError{Line: start + 1, Message: "expected cue timings (start --> end)"})
+				continue
+			}
+			timingLine++
+		}
+		cue, err := parseCueTimings(lines[timingLine])
+		if err != nil {
+			errs = append(errs, TranscriptError{Line: timingLine + 1, Message: err.Error()})
+			continue
+		}
+		cue.Text, cue.Voice = cueText(strings.Join(lines[timingLine+1:i], "\n"))
+		cues = append(cues, cue)
+	}
+	if len(errs) != 0 {
+		return cues, errs
+	}
+	return cues, nil
+}
+
+// parseCueTimings parses a cue timings line (start --> end, optionally followed by cue settings).
+func parseCueTimings(line string) (Cue, error) {
+	startText, rest, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return Cue{}, fmt.Errorf("missing end timestamp")
+	}
+	start, ok := parseVTTTimestamp(strings.TrimSpace(startText))
+	if !ok {
+		return Cue{}, fmt.Errorf("invalid start timestamp %q", strings.TrimSpace(startText))
+	}
+	end, ok := parseVTTTimestamp(fields[0])
+	if !ok {
+		return Cue{}, fmt.Errorf("invalid end timestamp %q", fields[0])
+	}
+	if end <= start {
+		return Cue{}, fmt.Errorf("end %s is not after start %s", fields[0], strings.TrimSpace(startText))
+	}
+	return Cue{Start: start, End: end}, nil
+}
+
+// replaceCues replaces the cues of a sample (and their search index rows).
+func replaceCues(ctx context.Context, db sqlx.ExtContext, id string, cues []Cue) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM transcript_cues WHERE sample_id=?", id)
+	if err != nil {
+		return err
+	}
+	for _, c := range cues {
+		c.SampleID = id
+		_, err = sqlx.NamedExecContext(ctx, db, "INSERT INTO transcript_cues (sample_id, start_offset, end_offset, text, voice) VALUES (:sample_id, :start_offset, :end_offset, :text, :voice)", c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SampleCues returns the transcript cues of a sample, in order.
+func (s *Storage) SampleCues(ctx context.Context, id string) ([]Cue, error) {
+	cues := make([]Cue, 0)
+	err := s.DB.SelectContext(ctx, &cues, "SELECT * FROM transcript_cues WHERE sample_id=? ORDER BY start_offset, id", id)
+	if err != nil {
+		return nil, err
+	}
+	return cues, nil
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseVTT(t *testing.T) {
+	data := "\ufeffWEBVTT - test\r\nKind: captions\r\n\r\nNOTE a comment\r\nspanning lines\r\n\r\n1\r\n00:01.000 --> 00:02.500 align:start\r\n<v.loud Alice>Hello &amp; welcome</v>\r\nsecond line\r\n\r\n01:00:00.000 --> 01:00:01.000\r\n<i>plain</i>\r\n"
+	cues, err := ParseVTT(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Cue{
+		{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello & welcome\nsecond line", Voice: "Alice"},
+		{Start: time.Hour, End: time.Hour + time.Second, Text: "plain"},
+	}
+	if !slices.Equal(cues, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, cues)
+	}
+}
+
+func TestParseVTTErrors(t *testing.T) {
+	_, err := ParseVTT("1\n00:01.000 --> 00:02.000\nhi\n")
+	var errs TranscriptErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Line != 1 {
+		t.Fatalf("expected a header error on line 1, got %v", err)
+	}
+
+	data := "WEBVTT\n\n00:01.000 --> 00:02.000\nok\n\nid\n00:03 --> 00:04.000\nbad start\n\n00:05.000 --> 00:04.000\nbackwards\n\njust text\n"
+	cues, err := ParseVTT(data)
+	if len(cues) != 1 || cues[0].Text != "ok" {
+		t.Fatalf("expected the valid cue, got %+v", cues)
+	}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected TranscriptErrors, got %v", err)
+	}
+	lines := make([]int, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Line
+	}
+	if !slices.Equal(lines, []int{7, 10, 13}) {
+		t.Fatalf("expected errors on lines 7, 10 and 13, got %v", errs)
+	}
+}
@@ -3,46 +3,106 @@ package storage
 import (
 	"context"
 	"log"
-	"os"
 	"time"
 )
 
-// WatchAndSyncFiles watches the samples directory every interval seconds and calls SyncFiles if the directory has files newer than the last sync.
-// Currently, there is no way to stop this method.
-func (s *Storage) WatchAndSyncFiles(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	lastSync := time.Now()
-	for range ticker.C {
-		log.Println("WatchAndSyncFiles: checking samples directory...")
-		entries, err := os.ReadDir(s.SamplesPath)
-		if err != nil {
-			log.Printf("WatchAndSyncFiles: read samples directory: %s", err)
-			continue
-		}
-		var latestModified time.Time
-		for _, entry := range entries {
-			info, err := entry.Info()
-			if err != nil {
-				log.Printf("WatchAndSyncFiles: get file info (ignoring this file): %s", err)
-				continue
+// dirWatcher reports changes to files in a directory.
+type dirWatcher interface {
+	// Events returns a channel of names of changed files, as slash-separated paths relative to the watched directory.
+	// An empty name means that events were lost, and the whole directory should be rescanned.
+	// The channel is closed when the watcher stops; Err then returns the reason.
+	Events() <-chan string
+	Err() error
+	Close() error
+}
+
+// WatchAndSyncFiles watches the samples directory and syncs changed files until ctx is done.
+// Events are debounced: changed files are synced once no new events arrived for debounce.
+// If inotify is unavailable, SyncFiles is called every pollInterval instead.
+func (s *Storage) WatchAndSyncFiles(ctx context.Context, pollInterval, debounce time.Duration) error {
+	w, err := newDirWatcher(s.SamplesPath)
+	if err != nil {
+		log.Printf("WatchAndSyncFiles: cannot watch samples directory, polling every %s instead: %s", pollInterval, err)
+		return s.pollAndSyncFiles(ctx, pollInterval)
+	}
+	defer w.Close()
+
+	// Sync once to pick up changes made before the watch was set up.
+	err = s.SyncFiles(ctx)
+	if err != nil {
+		log.Printf("WatchAndSyncFiles: sync files: %s", err)
+	}
+	if watchFiles(ctx, w, debounce, s.SyncFileNames) {
+		log.Printf("WatchAndSyncFiles: watcher stopped, polling every %s instead: %s", pollInterval, w.Err())
+		return s.pollAndSyncFiles(ctx, pollInterval)
+	}
+	return nil
+}
+
+// watchFiles calls sync with the files changed according to w, debounced, until ctx is done.
+// names is nil if the whole directory should be rescanned (see SyncFileNames).
+// It returns true if the watcher stopped before ctx was done.
+func watchFiles(ctx context.Context, w dirWatcher, debounce time.Duration, sync func(ctx context.Context, names []string) error) (stopped bool) {
+	// A constant stream of events should not delay syncing forever.
+	maxDelay := 10 * debounce
+	pending := map[string]struct{}{}
+	rescan := false
+	var firstPending time.Time
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case name, ok := <-w.Events():
+			if !ok {
+				return ctx.Err() == nil
 			}
-			if info.ModTime().After(latestModified) {
-				latestModified = info.ModTime()
+			if name == "" {
+				rescan = true
+			} else {
+				pending[name] = struct{}{}
 			}
-		}
-		if latestModified.After(lastSync) {
-			log.Printf("WatchAndSyncFiles: latest modified time (%s) is after last sync (%s), syncing files…", latestModified, lastSync)
-			err := s.SyncFiles(context.Background())
+			if firstPending.IsZero() {
+				firstPending = time.Now()
+			}
+			if time.Since(firstPending) < maxDelay {
+				timer.Reset(debounce)
+			}
+		case <-timer.C:
+			var names []string
+			if !rescan {
+				names = make([]string, 0, len(pending))
+				for name := range pending {
+					names = append(names, name)
+				}
+			}
+			err := sync(ctx, names)
 			if err != nil {
 				log.Printf("WatchAndSyncFiles: sync files: %s", err)
-				continue
-			} else {
-				log.Printf("WatchAndSyncFiles: files synced.")
-				lastSync = time.Now()
 			}
-		} else {
-			log.Println("WatchAndSyncFiles: no new files.")
+			pending = map[string]struct{}{}
+			rescan = false
+			firstPending = time.Time{}
+		}
+	}
+}
+
+// pollAndSyncFiles calls SyncFiles right away and then every interval until ctx is done.
+// SyncFiles only stats the samples directory unless files changed, so interval can be a few seconds.
+func (s *Storage) pollAndSyncFiles(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.SyncFiles(ctx)
+		if err != nil {
+			log.Printf("WatchAndSyncFiles: sync files: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
 		}
 	}
 }
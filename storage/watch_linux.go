@@ -0,0 +1,169 @@
+//go:build linux
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+type inotifyWatcher struct {
+	fd   int
+	root string
+	// dirs maps watch descriptors to slash-separated directory paths relative to root.
+	// The root directory is "".
+	dirs      map[int32]string
+	f         *os.File
+	events    chan string
+	err       error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newDirWatcher(path string) (dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	// The fd is non-blocking, so reads go through the runtime poller and Close unblocks them.
+	w := &inotifyWatcher{
+		fd:     fd,
+		root:   path,
+		dirs:   map[int32]string{},
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan string),
+		done:   make(chan struct{}),
+	}
+	err = w.addWatches("")
+	if err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// addWatches watches the directory rel (relative to root) and its subdirectories, except hidden ones.
+func (w *inotifyWatcher) addWatches(rel string) error {
+	return filepath.WalkDir(filepath.Join(w.root, filepath.FromSlash(rel)), func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && rel != "" {
+				return nil
+			}
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if p != w.root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("inotify add watch %s: %w", p, err)
+		}
+		dirRel, err := filepath.Rel(w.root, p)
+		if err != nil {
+			return err
+		}
+		if dirRel == "." {
+			dirRel = ""
+		}
+		w.dirs[int32(wd)] = filepath.ToSlash(dirRel)
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) Events() <-chan string { return w.events }
+
+func (w *inotifyWatcher) Err() error { return w.err }
+
+func (w *inotifyWatcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.f.Close()
+	})
+	return err
+}
+
+func (w *inotifyWatcher) run() {
+	defer close(w.events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			w.err = err
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			var ev syscall.InotifyEvent
+			_, err = binary.Decode(buf[off:off+syscall.SizeofInotifyEvent], binary.NativeEndian, &ev)
+			if err != nil {
+				w.err = err
+				return
+			}
+			nameStart := off + syscall.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(ev.Len)], "\x00"))
+			off = nameStart + int(ev.Len)
+
+			dir, known := w.dirs[ev.Wd]
+			var ok bool
+			switch {
+			case ev.Mask&syscall.IN_Q_OVERFLOW != 0:
+				ok = w.send("")
+			case ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0:
+				if known && dir == "" {
+					w.err = errors.New("samples directory was removed or moved")
+					return
+				}
+				delete(w.dirs, ev.Wd)
+				ok = true
+			case !known:
+				ok = true
+			case ev.Mask&syscall.IN_ISDIR != 0:
+				if strings.HasPrefix(name, ".") {
+					ok = true
+					break
+				}
+				if ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					err = w.addWatches(path.Join(dir, name))
+					if err != nil {
+						w.err = err
+						return
+					}
+				}
+				// Files may have been added to (or removed with) the directory before it was watched.
+				ok = w.send("")
+			case name != "":
+				ok = w.send(path.Join(dir, name))
+			default:
+				ok = true
+			}
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// send returns false if the watcher was closed.
+func (w *inotifyWatcher) send(name string) bool {
+	select {
+	case w.events <- name:
+		return true
+	case <-w.done:
+		return false
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package storage
+
+import "errors"
+
+func newDirWatcher(path string) (dirWatcher, error) {
+	return nil, errors.New("inotify is only available on Linux")
+}
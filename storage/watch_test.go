@@ -0,0 +1,97 @@
+//go:build fts5
+
+package storage
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWatchFilesDebounce(t *testing.T) {
+	s := newTestStorage(t)
+	w, err := newDirWatcher(s.SamplesPath)
+	if err != nil {
+		t.Skipf("cannot watch: %s", err)
+	}
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const debounce = 200 * time.Millisecond
+	synced := make(chan []string, 10)
+	done := make(chan bool)
+	go func() {
+		done <- watchFiles(ctx, w, debounce, func(ctx context.Context, names []string) error {
+			synced <- slices.Clone(names)
+			return s.SyncFileNames(ctx, names)
+		})
+	}()
+
+	ids := []string{"2024-01-02T10:00:00+00:00", "2024-01-02T11:00:00+00:00", "2024-01-02T12:00:00+00:00"}
+	for _, id := range ids {
+		writeSampleFiles(t, s, map[string]string{id + ".opus": "x"})
+	}
+	var names []string
+	select {
+	case names = <-synced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a sync")
+	}
+	select {
+	case more := <-synced:
+		t.Fatalf("expected a single sync, got another one of %v", more)
+	case <-time.After(3 * debounce):
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{ids[0] + ".opus", ids[1] + ".opus", ids[2] + ".opus"}) {
+		t.Fatalf("expected the files to be synced together, got %v", names)
+	}
+	var count int
+	err = s.DB.Get(&count, "SELECT COUNT(*) FROM samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(ids) {
+		t.Fatalf("expected %d samples, got %d", len(ids), count)
+	}
+
+	cancel()
+	if <-done {
+		t.Fatal("expected the watch to stop because ctx is done, not because the watcher stopped")
+	}
+}
+
+func TestPollAndSyncFilesSyncsRightAway(t *testing.T) {
+	s := newTestStorage(t)
+	id := "2024-01-02T10:00:00+00:00"
+	writeSampleFiles(t, s, map[string]string{id + ".opus": "x"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- s.pollAndSyncFiles(ctx, time.Hour)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var count int
+		err := s.DB.Get(&count, "SELECT COUNT(*) FROM samples")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a sync before the first interval passed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}